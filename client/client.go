@@ -2,76 +2,273 @@
 package client
 
 import (
-	"bufio"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"net"
+	"log/slog"
+	"sync"
+	"time"
 
-	"github.com/jemgunay/procedural-game/server"
-	"github.com/pkg/errors"
+	"github.com/jemgunay/procedural-game/protocol"
 )
 
 const (
 	maxSendFails           = uint(10)
 	messageQueueBufferSize = 2048
+
+	// reconnectAttempts is the number of times the client tries to reconnect after losing its connection, waiting
+	// twice as long after each failed attempt, from minReconnectDelay up to maxReconnectDelay. The attempts span
+	// roughly the server's reconnect grace period, within which the session is resumed.
+	reconnectAttempts = 8
+	minReconnectDelay = time.Millisecond * 250
+	maxReconnectDelay = time.Second * 8
 )
 
-var (
-	conn         net.Conn
-	messageQueue chan server.Message
-	connected    bool
+// Client is a connection to a game server, which queues the messages received from the server to be polled. If the
+// connection is lost after the user has been welcomed by the server, the client reconnects using the session token it
+// was issued, and the server's welcome is queued again. The message queue is only closed once the client disconnects
+// or gives up reconnecting.
+type Client struct {
+	transport    protocol.Transport
+	addr         string
+	messageQueue chan protocol.Payload
+	// log tags every message logged about the connection with the server's address
+	log *slog.Logger
 
+	// mu guards the fields below, which are shared between the sending, receiving and heartbeat goroutines
+	mu sync.Mutex
+	// conn is nil while waiting to reconnect
+	conn protocol.Conn
+	// connectReq is the handshake sent to the server and token is the latest session token issued by it, which are
+	// used to reconnect
+	connectReq protocol.Connect
+	token      string
+	// welcomed is set once the server has welcomed the user on the current connection, until which only the handshake
+	// can be sent over it. resumable is cleared once the server has told the client that it won't be able to reconnect
+	welcomed  bool
+	resumable bool
+	// attempts is the number of reconnect attempts made since the user was last welcomed
+	attempts        int
+	lastReceived    time.Time
+	rtt             time.Duration
 	sendFailCounter uint
-	stopChan        chan struct{}
-)
 
-// Start initialises a connection with a TCP game server.
-func Start(addr string) error {
-	stopChan = make(chan struct{}, 1)
-	messageQueue = make(chan server.Message, messageQueueBufferSize)
-	sendFailCounter = 0
+	stopChan chan struct{}
+	stopOnce sync.Once
+}
 
-	var err error
-	conn, err = net.Dial("tcp", addr)
+// Start initialises a connection with a game server. The connection is made over TCP unless the address is prefixed
+// with UDPScheme, and is encrypted if the address is prefixed with TLSScheme.
+func Start(addr string) (*Client, error) {
+	transport, host := transportFor(addr)
+	return Connect(transport, host)
+}
+
+// Connect initialises a connection with the game server at addr over the provided transport.
+func Connect(transport protocol.Transport, addr string) (*Client, error) {
+	conn, err := transport.Dial(addr)
 	if err != nil {
-		return fmt.Errorf("failed to bind TCP on port %s: %s", addr, err)
+		return nil, fmt.Errorf("failed to connect to %s: %s", addr, err)
+	}
+
+	c := &Client{
+		transport:    transport,
+		addr:         addr,
+		conn:         conn,
+		messageQueue: make(chan protocol.Payload, messageQueueBufferSize),
+		log:          slog.With("transport", conn.RemoteAddr().Network(), "server", addr),
+		resumable:    true,
+		lastReceived: time.Now(),
+		stopChan:     make(chan struct{}),
 	}
+	c.log.Info("server connection established")
 
-	connected = true
-	fmt.Println("TCP server connection established on " + addr)
-
-	go func() {
-		defer conn.Close()
-		// listen for reply
-		r := bufio.NewReader(conn)
-		for {
-			select {
-			case <-stopChan:
-				fmt.Println("TCP client connection disconnected on " + addr)
-				close(messageQueue)
+	go c.receive(conn)
+	go c.heartbeat()
+	return c, nil
+}
+
+// receive reads messages from the server and queues them to be polled, reconnecting if the connection is lost.
+func (c *Client) receive(conn protocol.Conn) {
+	defer close(c.messageQueue)
+
+	for {
+		msg, err := conn.Receive()
+		if err != nil {
+			if errors.Is(err, protocol.ErrInvalidMessage) {
+				c.log.Warn("invalid message received", "err", err)
+				continue
+			}
+			if c.stopped() {
+				c.log.Info("server connection closed")
+				return
+			}
+			c.log.Warn("failed to read message", "err", err)
+			conn.Close()
+			if conn = c.reconnect(); conn == nil {
+				c.Disconnect()
 				return
+			}
+			continue
+		}
+
+		c.mu.Lock()
+		c.lastReceived = time.Now()
+		c.mu.Unlock()
+
+		switch data := msg.(type) {
+		// heartbeats are answered and measured here rather than being queued
+		case protocol.Ping:
+			if err := conn.Send(protocol.Pong{Time: data.Time}); err != nil {
+				c.log.Warn("failed to send message", "type", protocol.TypePong, "err", err)
+			}
+			continue
+		case protocol.Pong:
+			c.mu.Lock()
+			c.rtt = time.Since(data.Sent())
+			c.mu.Unlock()
+			continue
+
+		// switch over to the codec agreed during the handshake before reading any further messages
+		case protocol.RegisterSuccess:
+			c.welcome(conn, data.Welcome)
+		case protocol.ConnectSuccess:
+			c.welcome(conn, data.Welcome)
+
+		// the server won't accept the session again after shutting down or kicking the user
+		case protocol.ServerShutdown, protocol.Kick:
+			c.mu.Lock()
+			c.resumable = false
+			c.mu.Unlock()
+		}
+
+		select {
+		case c.messageQueue <- msg:
+		case <-c.stopChan:
+			c.log.Info("server connection closed")
+			return
+		}
+	}
+}
+
+// welcome switches a connection over to the codec selected by the server in its welcome response, and records the
+// session token issued by it. Messages are only sent over a new connection once it has been welcomed.
+func (c *Client) welcome(conn protocol.Conn, welcome protocol.Welcome) {
+	codec, err := protocol.NewCodec(welcome.Codec)
+	if err != nil {
+		c.log.Error("server selected an unsupported codec", "err", err)
+		c.Disconnect()
+		return
+	}
+	conn.Switch(codec)
+
+	c.mu.Lock()
+	c.welcomed = true
+	c.attempts = 0
+	if welcome.Token != "" {
+		c.token = welcome.Token
+	}
+	c.mu.Unlock()
+}
 
-			default:
-				resp, err := r.ReadString('\n')
-				if err != nil {
-					fmt.Printf("failed to read incoming TCP request: %s\n", err)
-					Disconnect()
-					continue
-				}
-
-				// unmarshal raw request
-				var msg server.Message
-				if err := json.Unmarshal([]byte(resp), &msg); err != nil {
-					fmt.Printf("invalid request received from %s, %s:\n%s\n", addr, err, []byte(resp))
-					continue
-				}
-
-				messageQueue <- msg
+// reconnect attempts to reconnect to the server with backoff after the connection has been lost, returning the new
+// connection once the handshake has been sent over it. The server's response is received as normal. nil is returned
+// if the client can't or shouldn't reconnect, i.e. it was never welcomed, or the attempts made since it was last
+// welcomed have been exhausted.
+func (c *Client) reconnect() protocol.Conn {
+	c.mu.Lock()
+	canResume := (c.welcomed || c.attempts > 0) && c.resumable && c.token != ""
+	req := c.connectReq
+	req.Password = ""
+	req.Token = c.token
+	c.conn = nil
+	c.welcomed = false
+	c.mu.Unlock()
+	if !canResume {
+		return nil
+	}
+
+	for {
+		c.mu.Lock()
+		c.attempts++
+		attempt := c.attempts
+		c.mu.Unlock()
+		if attempt > reconnectAttempts {
+			c.log.Warn("giving up reconnecting", "attempts", reconnectAttempts)
+			return nil
+		}
+
+		delay := minReconnectDelay << uint(attempt-1)
+		if delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+		select {
+		case <-time.After(delay):
+		case <-c.stopChan:
+			return nil
+		}
+
+		c.log.Info("reconnecting", "attempt", attempt, "attempts", reconnectAttempts)
+		conn, err := c.transport.Dial(c.addr)
+		if err != nil {
+			c.log.Warn("failed to reconnect", "err", err)
+			continue
+		}
+		if err := conn.Send(req); err != nil {
+			c.log.Warn("failed to send message", "type", protocol.TypeConnect, "err", err)
+			conn.Close()
+			continue
+		}
+
+		c.mu.Lock()
+		c.conn = conn
+		c.lastReceived = time.Now()
+		c.mu.Unlock()
+		// the client may have disconnected before the connection was replaced, in which case it must be closed here
+		if c.stopped() {
+			conn.Close()
+			return nil
+		}
+		return conn
+	}
+}
+
+// heartbeat pings the server every heartbeat interval to measure the round trip time, and closes the connection if
+// nothing has been received from the server within the idle timeout, which triggers a reconnect. Pings are only sent
+// once the user has been welcomed.
+func (c *Client) heartbeat() {
+	ticker := time.NewTicker(protocol.HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stopChan:
+			return
+		case now := <-ticker.C:
+			c.mu.Lock()
+			conn, idle := c.conn, now.Sub(c.lastReceived)
+			c.mu.Unlock()
+			if conn == nil {
+				continue
 			}
+			// this also times out handshakes which the server never responds to
+			if idle > protocol.IdleTimeout {
+				c.log.Warn("no messages received, closing connection", "idle", idle.Truncate(time.Second))
+				conn.Close()
+				continue
+			}
+			c.Send(protocol.Ping{Time: now.UnixNano()})
 		}
-	}()
+	}
+}
 
-	return nil
+// stopped determines whether the client has disconnected.
+func (c *Client) stopped() bool {
+	select {
+	case <-c.stopChan:
+		return true
+	default:
+		return false
+	}
 }
 
 var (
@@ -82,40 +279,76 @@ var (
 )
 
 // Poll pulls a message from the queue and returns it to be processed by the scene. If there are no messages in
-// the queue, an empty Message is returned.
-func Poll() (server.Message, error) {
+// the queue, a nil payload is returned.
+func (c *Client) Poll() (protocol.Payload, error) {
 	select {
-	case msg, ok := <-messageQueue:
+	case msg, ok := <-c.messageQueue:
 		if ok {
 			return msg, nil
 		}
-		return server.Message{}, ErrQueueClosed
+		return nil, ErrQueueClosed
 	default:
-		return server.Message{}, ErrQueueEmpty
+		return nil, ErrQueueEmpty
 	}
 }
 
-// Send marshals and writes a message to a server.
-func Send(msg server.Message) {
-	if _, err := conn.Write(msg.Pack()); err != nil {
-		fmt.Printf("failed to the following to %s: %s:\n%+v\n", conn.RemoteAddr(), err, msg)
+// Send encodes and writes a message to a server. Messages other than the handshake are dropped until the user has been
+// welcomed, such as those sent while the client is reconnecting.
+func (c *Client) Send(msg protocol.Payload) {
+	c.mu.Lock()
+	conn, welcomed := c.conn, c.welcomed
+	// the handshake is remembered so that it can be repeated when reconnecting
+	req, handshake := msg.(protocol.Connect)
+	if handshake {
+		c.connectReq = req
+	}
+	c.mu.Unlock()
+	if conn == nil || (!welcomed && !handshake) {
+		return
+	}
+
+	if err := conn.Send(msg); err != nil {
+		c.log.Warn("failed to send message", "type", msg.Type(), "err", err)
 
-		// if too many write fails occur in a row, then disconnect from server
-		sendFailCounter++
-		if sendFailCounter >= maxSendFails {
-			fmt.Println("too many failed sends occurred - triggering a client disconnect")
-			Disconnect()
+		// if too many write fails occur in a row, then close the connection to trigger a reconnect
+		c.mu.Lock()
+		c.sendFailCounter++
+		failed := c.sendFailCounter >= maxSendFails
+		if failed {
+			c.sendFailCounter = 0
+		}
+		c.mu.Unlock()
+		if failed {
+			c.log.Warn("too many failed sends, closing connection")
+			conn.Close()
 		}
 		return
 	}
-	sendFailCounter = 0
+
+	c.mu.Lock()
+	c.sendFailCounter = 0
+	c.mu.Unlock()
 }
 
-// Disconnect disconnects the client from the server.
-func Disconnect() {
-	if !connected {
-		return
-	}
-	fmt.Println("disconnecting client")
-	stopChan <- struct{}{}
+// RTT returns the round trip time to the server measured by the latest heartbeat, or zero if none has been measured.
+func (c *Client) RTT() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rtt
+}
+
+// Disconnect disconnects the client from the server. The message queue is closed once any message being received has
+// been dropped.
+func (c *Client) Disconnect() {
+	c.stopOnce.Do(func() {
+		c.log.Info("disconnecting client")
+		close(c.stopChan)
+
+		c.mu.Lock()
+		conn := c.conn
+		c.mu.Unlock()
+		if conn != nil {
+			conn.Close()
+		}
+	})
 }
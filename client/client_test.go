@@ -0,0 +1,101 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jemgunay/procedural-game/protocol"
+	"github.com/jemgunay/procedural-game/server"
+)
+
+// testTimeout is how long tests wait for the client to receive an expected message.
+const testTimeout = time.Second * 5
+
+// waitFor polls a client until it receives a message satisfying match, failing the test if none arrives in time.
+func waitFor(t *testing.T, c *Client, match func(protocol.Payload) bool) protocol.Payload {
+	t.Helper()
+	deadline := time.Now().Add(testTimeout)
+	for time.Now().Before(deadline) {
+		msg, err := c.Poll()
+		switch err {
+		case nil:
+			if match(msg) {
+				return msg
+			}
+		case ErrQueueEmpty:
+			time.Sleep(time.Millisecond)
+		default:
+			t.Fatalf("failed to poll client: %s", err)
+		}
+	}
+	t.Fatal("timed out waiting for message")
+	return nil
+}
+
+// TestReconnect checks that a client which loses its connection reconnects and resumes its session, and that it
+// doesn't try to reconnect once the server has shut down.
+func TestReconnect(t *testing.T) {
+	srv, err := server.New("test-seed", "", server.DefaultTickRate)
+	if err != nil {
+		t.Fatalf("failed to create server: %s", err)
+	}
+	transport := protocol.NewPipeTransport()
+	l, err := transport.Listen("server")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+	go srv.Serve(l)
+	srv.Start()
+
+	c, err := Connect(transport, "server")
+	if err != nil {
+		t.Fatalf("failed to connect: %s", err)
+	}
+	defer c.Disconnect()
+	c.Send(protocol.Connect{
+		Username: "jemgunay",
+		Password: "password",
+		Version:  protocol.Version,
+		Codec:    protocol.BinaryCodecName,
+	})
+	waitFor(t, c, func(p protocol.Payload) bool {
+		_, ok := p.(protocol.RegisterSuccess)
+		return ok
+	})
+
+	// drop the connection without disconnecting the client
+	c.mu.Lock()
+	c.conn.Close()
+	c.mu.Unlock()
+
+	msg := waitFor(t, c, func(p protocol.Payload) bool {
+		_, ok := p.(protocol.ConnectSuccess)
+		return ok
+	})
+	if welcome := msg.(protocol.ConnectSuccess).Welcome; !welcome.Resumed || welcome.Player.Name != "jemgunay" {
+		t.Fatalf("expected session to be resumed: %+v", welcome)
+	}
+
+	// the new connection is usable once welcomed
+	c.Send(protocol.Ping{Time: time.Now().UnixNano()})
+	deadline := time.Now().Add(testTimeout)
+	for c.RTT() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for round trip time to be measured")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// the client gives up once the server has shut down
+	srv.Shutdown()
+	deadline = time.Now().Add(testTimeout)
+	for {
+		if _, err := c.Poll(); err == ErrQueueClosed {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for client to disconnect")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,33 @@
+package client
+
+import (
+	"strings"
+
+	"github.com/jemgunay/procedural-game/protocol"
+)
+
+// Server address prefixes used to select the transport, e.g. "tls://localhost:9000".
+const (
+	TLSScheme = "tls://"
+	UDPScheme = "udp://"
+)
+
+// transportFor selects the transport to connect to a game server over by the address prefix, defaulting to TCP. The
+// address with the prefix removed is also returned.
+func transportFor(addr string) (protocol.Transport, string) {
+	switch {
+	case strings.HasPrefix(addr, UDPScheme):
+		return protocol.UDPTransport{}, strings.TrimPrefix(addr, UDPScheme)
+
+	case strings.HasPrefix(addr, TLSScheme):
+		host := strings.TrimPrefix(addr, TLSScheme)
+		return protocol.TCPTransport{TLSConfig: pinnedTLSConfig(host)}, host
+	}
+	return protocol.TCPTransport{}, addr
+}
+
+// dial connects to a game server over the transport selected by the address prefix.
+func dial(addr string) (protocol.Conn, error) {
+	transport, host := transportFor(addr)
+	return transport.Dial(host)
+}
@@ -0,0 +1,184 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jemgunay/procedural-game/protocol"
+)
+
+const (
+	// BrowseInterval is how often a browser queries the LAN for servers.
+	BrowseInterval = time.Second * 2
+	// browseExpiry is how long a discovered server is listed for without replying to a query.
+	browseExpiry = BrowseInterval*2 + time.Second
+	// maxDiscoveryReplySize is the size of the buffer discovery replies are read into.
+	maxDiscoveryReplySize = 2048
+)
+
+// DiscoveredServer is a server found on the LAN by a Browser.
+type DiscoveredServer struct {
+	protocol.ServerInfo
+	// Addr is the address to join the server at, prefixed with TLSScheme if it requires TLS.
+	Addr string
+	// Ping is the round trip time of the latest query answered by the server.
+	Ping     time.Duration
+	LastSeen time.Time
+}
+
+// Compatible determines whether the server supports a protocol version supported by this client.
+func (s DiscoveredServer) Compatible() bool {
+	_, err := protocol.Negotiate(s.Version)
+	return err == nil
+}
+
+// Browser discovers the game servers on the LAN by periodically broadcasting discovery queries, which servers reply
+// to with a description of themselves.
+type Browser struct {
+	conn    net.PacketConn
+	targets []*net.UDPAddr
+
+	mu      sync.Mutex
+	servers map[string]DiscoveredServer
+
+	stopChan chan struct{}
+	stopOnce sync.Once
+}
+
+// Browse starts browsing for servers in the background until the browser is closed. Queries are broadcast to the
+// discovery port on the LAN, unless target addresses to query are provided.
+func Browse(targets ...string) (*Browser, error) {
+	if len(targets) == 0 {
+		targets = []string{net.JoinHostPort(net.IPv4bcast.String(), strconv.Itoa(protocol.DiscoveryPort))}
+	}
+
+	b := &Browser{
+		servers:  make(map[string]DiscoveredServer),
+		stopChan: make(chan struct{}),
+	}
+	for _, target := range targets {
+		addr, err := net.ResolveUDPAddr("udp4", target)
+		if err != nil {
+			return nil, fmt.Errorf("invalid discovery address %s: %s", target, err)
+		}
+		b.targets = append(b.targets, addr)
+	}
+
+	var err error
+	if b.conn, err = net.ListenPacket("udp4", ":0"); err != nil {
+		return nil, fmt.Errorf("failed to listen for discovery replies: %s", err)
+	}
+
+	go b.receive()
+	go func() {
+		ticker := time.NewTicker(BrowseInterval)
+		defer ticker.Stop()
+		for {
+			b.Refresh()
+			select {
+			case <-ticker.C:
+			case <-b.stopChan:
+				return
+			}
+		}
+	}()
+	return b, nil
+}
+
+// Refresh queries for servers immediately rather than waiting for the next periodic query.
+func (b *Browser) Refresh() {
+	query, err := protocol.Discovery{Time: time.Now().UnixNano()}.Encode()
+	if err != nil {
+		slog.Error("failed to encode discovery query", "err", err)
+		return
+	}
+	for _, target := range b.targets {
+		if _, err := b.conn.WriteTo(query, target); err != nil && !b.stopped() {
+			slog.Warn("failed to send discovery query", "addr", target.String(), "err", err)
+		}
+	}
+}
+
+// receive records the servers which reply to queries until the browser is closed.
+func (b *Browser) receive() {
+	buf := make([]byte, maxDiscoveryReplySize)
+	for {
+		n, from, err := b.conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			slog.Warn("failed to read discovery reply", "err", err)
+			continue
+		}
+		now := time.Now()
+		reply, err := protocol.DecodeDiscovery(buf[:n])
+		if err != nil || reply.Server == nil {
+			continue
+		}
+		udpAddr, ok := from.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+
+		server := DiscoveredServer{
+			ServerInfo: *reply.Server,
+			Addr:       net.JoinHostPort(udpAddr.IP.String(), strconv.Itoa(reply.Server.Port)),
+			Ping:       now.Sub(reply.Sent()),
+			LastSeen:   now,
+		}
+		if server.TLS {
+			server.Addr = TLSScheme + server.Addr
+		}
+		b.mu.Lock()
+		b.servers[server.Addr] = server
+		b.mu.Unlock()
+	}
+}
+
+// Servers returns the servers which have recently replied to queries, ordered by name and then address.
+func (b *Browser) Servers() []DiscoveredServer {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	servers := make([]DiscoveredServer, 0, len(b.servers))
+	for addr, server := range b.servers {
+		if now.Sub(server.LastSeen) > browseExpiry {
+			delete(b.servers, addr)
+			continue
+		}
+		servers = append(servers, server)
+	}
+	sort.Slice(servers, func(i, j int) bool {
+		if servers[i].Name != servers[j].Name {
+			return servers[i].Name < servers[j].Name
+		}
+		return servers[i].Addr < servers[j].Addr
+	})
+	return servers
+}
+
+// Close stops browsing for servers.
+func (b *Browser) Close() {
+	b.stopOnce.Do(func() {
+		close(b.stopChan)
+		b.conn.Close()
+	})
+}
+
+// stopped determines whether the browser has been closed.
+func (b *Browser) stopped() bool {
+	select {
+	case <-b.stopChan:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,29 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+)
+
+// readJSONFile unmarshals the JSON file at path into v. v is left untouched if the file doesn't exist.
+func readJSONFile(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// writeJSONFile marshals v into the file at path. The file is only readable by the current user as it may contain
+// credentials.
+func writeJSONFile(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
@@ -0,0 +1,51 @@
+package client
+
+import (
+	"log/slog"
+	"sync"
+)
+
+// SessionFile is the file that session tokens issued by servers are saved to, allowing users to reconnect without
+// re-entering their password.
+const SessionFile = "sessions.json"
+
+var sessionMu sync.Mutex
+
+// sessionKey identifies the session of a user on a server.
+func sessionKey(addr, username string) string {
+	return username + "@" + addr
+}
+
+// SessionToken retrieves the session token saved for a user on the server at addr. An empty string is returned if no
+// token has been saved.
+func SessionToken(addr, username string) string {
+	sessionMu.Lock()
+	defer sessionMu.Unlock()
+
+	sessions := make(map[string]string)
+	if err := readJSONFile(SessionFile, &sessions); err != nil {
+		slog.Warn("failed to read session tokens", "path", SessionFile, "err", err)
+	}
+	return sessions[sessionKey(addr, username)]
+}
+
+// SaveSessionToken saves the session token issued to a user by the server at addr, replacing any previous token. An
+// empty token removes the saved token.
+func SaveSessionToken(addr, username, token string) {
+	sessionMu.Lock()
+	defer sessionMu.Unlock()
+
+	sessions := make(map[string]string)
+	if err := readJSONFile(SessionFile, &sessions); err != nil {
+		slog.Warn("failed to read session tokens", "path", SessionFile, "err", err)
+	}
+	if token == "" {
+		delete(sessions, sessionKey(addr, username))
+	} else {
+		sessions[sessionKey(addr, username)] = token
+	}
+
+	if err := writeJSONFile(SessionFile, sessions); err != nil {
+		slog.Warn("failed to save session tokens", "path", SessionFile, "err", err)
+	}
+}
@@ -0,0 +1,62 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/jemgunay/procedural-game/protocol"
+)
+
+// KnownServersFile is the file that the TLS certificate fingerprints of previously joined servers are pinned in.
+var KnownServersFile = "known_servers.json"
+
+var pinMu sync.Mutex
+
+// pinnedTLSConfig creates the TLS configuration used to connect to the game server at host. Self-signed certificates
+// are expected, so rather than verifying the certificate chain the certificate is trusted on first use and pinned for
+// subsequent connections.
+func pinnedTLSConfig(host string) *tls.Config {
+	return &tls.Config{
+		InsecureSkipVerify: true,
+		MinVersion:         tls.VersionTLS12,
+		VerifyConnection: func(state tls.ConnectionState) error {
+			if len(state.PeerCertificates) == 0 {
+				return errors.New("server did not present a certificate")
+			}
+			return verifyPin(host, state.PeerCertificates[0])
+		},
+	}
+}
+
+// verifyPin checks a server's certificate against the fingerprint pinned for its address, pinning the certificate if
+// the server hasn't been seen before.
+func verifyPin(addr string, cert *x509.Certificate) error {
+	pinMu.Lock()
+	defer pinMu.Unlock()
+
+	known := make(map[string]string)
+	if err := readJSONFile(KnownServersFile, &known); err != nil {
+		return fmt.Errorf("failed to read known servers: %s", err)
+	}
+
+	fingerprint := protocol.Fingerprint(cert)
+	pinned, ok := known[addr]
+	if ok {
+		if pinned != fingerprint {
+			return fmt.Errorf("certificate fingerprint for %s has changed from %s to %s - remove it from %s if this is expected",
+				addr, pinned, fingerprint, KnownServersFile)
+		}
+		return nil
+	}
+
+	known[addr] = fingerprint
+	if err := writeJSONFile(KnownServersFile, known); err != nil {
+		return fmt.Errorf("failed to pin certificate: %s", err)
+	}
+	slog.Info("pinned TLS certificate", "server", addr, "fingerprint", fingerprint)
+	return nil
+}
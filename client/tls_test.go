@@ -0,0 +1,97 @@
+package client
+
+import (
+	"crypto/tls"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/jemgunay/procedural-game/protocol"
+	"github.com/jemgunay/procedural-game/server"
+)
+
+// newTestTLSConfig loads or generates a certificate pair with the provided name in dir.
+func newTestTLSConfig(t *testing.T, dir, name string) *tls.Config {
+	t.Helper()
+	cfg, err := server.TLSConfig(filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key"))
+	if err != nil {
+		t.Fatalf("failed to create TLS config: %s", err)
+	}
+	return cfg
+}
+
+// TestTLSPinning connects to a loopback TLS server, checking that its self-signed certificate is pinned on first use
+// and that connections are refused if the server presents a different certificate.
+func TestTLSPinning(t *testing.T) {
+	dir := t.TempDir()
+	KnownServersFile = filepath.Join(dir, "known_servers.json")
+
+	original := newTestTLSConfig(t, dir, "original")
+	// the generated certificate is saved so that the server's identity is stable across restarts
+	if reloaded := newTestTLSConfig(t, dir, "original"); protocol.Fingerprint(reloaded.Certificates[0].Leaf) !=
+		protocol.Fingerprint(original.Certificates[0].Leaf) {
+		t.Fatal("reloaded certificate has a different fingerprint")
+	}
+	impostor := newTestTLSConfig(t, dir, "impostor")
+
+	// serve whichever certificate is current
+	var (
+		mu   sync.Mutex
+		cert = &original.Certificates[0]
+	)
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			return cert, nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+	defer listener.Close()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			// complete the handshake and echo an encoded message back to the client
+			go func() {
+				defer conn.Close()
+				c := protocol.NewStreamConn(conn)
+				msg, err := c.Receive()
+				if err != nil {
+					return
+				}
+				c.Send(msg)
+			}()
+		}
+	}()
+
+	addr := TLSScheme + listener.Addr().String()
+	for i := 0; i < 2; i++ {
+		c, err := dial(addr)
+		if err != nil {
+			t.Fatalf("connection %d: failed to dial trusted server: %s", i, err)
+		}
+		want := protocol.UserJoined{Name: "jemgunay"}
+		if err := c.Send(want); err != nil {
+			t.Fatalf("connection %d: failed to send: %s", i, err)
+		}
+		if got, err := c.Receive(); err != nil || got != want {
+			t.Fatalf("connection %d: got %+v (%v), want %+v", i, got, err, want)
+		}
+		c.Close()
+	}
+
+	mu.Lock()
+	cert = &impostor.Certificates[0]
+	mu.Unlock()
+
+	if conn, err := dial(addr); err == nil {
+		conn.Close()
+		t.Fatal("connected to server presenting a different certificate")
+	}
+}
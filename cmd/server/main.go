@@ -0,0 +1,128 @@
+// Package main is the headless dedicated game server entry point.
+package main
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/jemgunay/procedural-game/logging"
+	"github.com/jemgunay/procedural-game/protocol"
+	"github.com/jemgunay/procedural-game/server"
+)
+
+func main() {
+	addr := flag.String("addr", ":9000", "address for the TCP server to listen on")
+	seed := flag.String("seed", "", "seed used to generate the world, defaults to the saved world's seed")
+	state := flag.String("state", server.DefaultStatePath, "file to persist server state to, or empty to disable")
+	useTLS := flag.Bool("tls", false, "encrypt connections with TLS")
+	certFile := flag.String("tls-cert", server.DefaultCertFile, "PEM encoded TLS certificate, generated as self-signed if neither it nor the key exist")
+	keyFile := flag.String("tls-key", server.DefaultKeyFile, "PEM encoded TLS private key")
+	tickRate := flag.Uint("tick-rate", server.DefaultTickRate, fmt.Sprintf("number of server simulation steps per second between 1 and %d, e.g. 30 or 60", server.MaxTickRate))
+	admins := flag.String("admins", "", "comma separated names of the users who can use admin chat commands such as /kick and /tp")
+	adminAddr := flag.String("admin-addr", "", "loopback address for the admin HTTP/JSON API to listen on, e.g. localhost:9001, or empty to disable")
+	adminToken := flag.String("admin-token", "", "bearer token required by the admin API, generated and logged at startup if empty")
+	metricsAddr := flag.String("metrics-addr", "", "loopback address to serve Prometheus metrics on at /metrics, e.g. localhost:9002, or empty to disable")
+	discoveryAddr := flag.String("discovery-addr", fmt.Sprintf(":%d", protocol.DiscoveryPort), "UDP address to answer LAN discovery queries on, or empty to disable")
+	name := flag.String("name", "", "name the server is listed under on the LAN, defaults to the hostname")
+	console := flag.Bool("console", true, "read admin commands from stdin, enter help to list them")
+	interestRadius := flag.Float64("interest-radius", server.DefaultInterestRadius, "distance from a player within which they are sent updates about other players")
+	logLevel := flag.String("log-level", "info", "minimum level of the messages logged, i.e. "+logging.Levels)
+	logFormat := flag.String("log-format", "text", "format of the messages logged, i.e. "+logging.Formats)
+	flag.Parse()
+
+	// log to stderr, leaving stdout to the console
+	logger, err := logging.New(os.Stderr, *logLevel, *logFormat)
+	if err != nil {
+		fmt.Printf("failed to configure logging: %s\n", err)
+		os.Exit(1)
+	}
+	slog.SetDefault(logger)
+
+	var tlsConfig *tls.Config
+	if *useTLS {
+		if tlsConfig, err = server.TLSConfig(*certFile, *keyFile); err != nil {
+			slog.Error("failed to configure TLS", "err", err)
+			os.Exit(1)
+		}
+	}
+
+	srv, err := server.New(*seed, *state, *tickRate)
+	if err != nil {
+		slog.Error("server failed to start", "err", err)
+		os.Exit(1)
+	}
+	srv.SetInterestRadius(*interestRadius)
+	if *admins != "" {
+		srv.SetAdmins(strings.Split(*admins, ",")...)
+	}
+	if err = srv.Listen(*addr, tlsConfig); err != nil {
+		slog.Error("server failed to start", "err", err)
+		os.Exit(1)
+	}
+	if *adminAddr != "" {
+		if *adminToken == "" {
+			if *adminToken, err = newAdminToken(); err != nil {
+				slog.Error("server failed to start", "err", err)
+				os.Exit(1)
+			}
+			slog.Info("generated admin API token", "token", *adminToken)
+		}
+		if err = srv.ListenAdmin(*adminAddr, *adminToken); err != nil {
+			slog.Error("server failed to start", "err", err)
+			os.Exit(1)
+		}
+	}
+	if *discoveryAddr != "" {
+		if *name == "" {
+			*name, _ = os.Hostname()
+		}
+		// another server on the same host may already be answering queries, so this doesn't prevent starting
+		if err = srv.ListenDiscovery(*discoveryAddr, *name); err != nil {
+			slog.Warn("server won't be discoverable on the LAN", "err", err)
+		}
+	}
+	if *metricsAddr != "" {
+		if err = srv.ListenMetrics(*metricsAddr); err != nil {
+			slog.Error("server failed to start", "err", err)
+			os.Exit(1)
+		}
+	}
+	srv.Start()
+
+	// shut down the server on interrupt/terminate signals, or the console's shutdown command
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	consoleShutdown := make(chan struct{})
+	if *console {
+		go func() {
+			if srv.RunConsole(os.Stdin, os.Stdout) {
+				close(consoleShutdown)
+			}
+		}()
+	}
+
+	select {
+	case sig := <-sigCh:
+		slog.Info("received signal", "signal", sig)
+	case <-consoleShutdown:
+		slog.Info("shutdown requested from the console")
+	}
+	srv.Shutdown()
+}
+
+// newAdminToken generates a random token for the admin API.
+func newAdminToken() (string, error) {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return "", fmt.Errorf("failed to generate admin API token: %s", err)
+	}
+	return hex.EncodeToString(token), nil
+}
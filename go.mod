@@ -1,13 +1,19 @@
 module github.com/jemgunay/procedural-game
 
+go 1.24
+
 require (
 	github.com/aquilax/go-perlin v0.0.0-20150412072437-3f94c9ea34d7
+	github.com/faiface/pixel v0.8.0
+	github.com/pkg/errors v0.8.1
+	golang.org/x/image v0.0.0-20190507092727-e4e5bf290fec
+)
+
+require (
 	github.com/faiface/glhf v0.0.0-20181018222622-82a6317ac380 // indirect
 	github.com/faiface/mainthread v0.0.0-20171120011319-8b78f0a41ae3 // indirect
-	github.com/faiface/pixel v0.8.0
 	github.com/go-gl/gl v0.0.0-20190320180904-bf2b1f2f34d7 // indirect
 	github.com/go-gl/glfw v0.0.0-20190409004039-e6da0acd62b1 // indirect
 	github.com/go-gl/mathgl v0.0.0-20190416160123-c4601bc793c7 // indirect
-	github.com/pkg/errors v0.8.1
-	golang.org/x/image v0.0.0-20190507092727-e4e5bf290fec
+	golang.org/x/text v0.3.0 // indirect
 )
@@ -0,0 +1,32 @@
+// Package logging configures the leveled, structured logger used by the game and dedicated server.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Levels are the names of the supported log levels.
+const Levels = "debug, info, warn or error"
+
+// Formats are the names of the supported log formats.
+const Formats = "text or json"
+
+// New creates a logger which writes records at or above the named level to w, in the named format.
+func New(w io.Writer, level, format string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("unsupported log level %s, must be %s", level, Levels)
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+	switch strings.ToLower(format) {
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	}
+	return nil, fmt.Errorf("unsupported log format %s, must be %s", format, Formats)
+}
@@ -0,0 +1,34 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+// TestNew checks that loggers only write records at or above their level, in their format.
+func TestNew(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, "warn", "json")
+	if err != nil {
+		t.Fatalf("failed to create logger: %s", err)
+	}
+	logger.Info("ignored")
+	logger.With("addr", "127.0.0.1:1234").Warn("failed to read message", "type", "input")
+
+	var record map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("expected a single JSON record, got %q: %s", buf.String(), err)
+	}
+	if record["level"] != "WARN" || record["msg"] != "failed to read message" || record["addr"] != "127.0.0.1:1234" ||
+		record["type"] != "input" {
+		t.Errorf("unexpected record %v", record)
+	}
+
+	if _, err := New(&buf, "verbose", "text"); err == nil {
+		t.Error("expected an unsupported level to be rejected")
+	}
+	if _, err := New(&buf, "info", "xml"); err == nil {
+		t.Error("expected an unsupported format to be rejected")
+	}
+}
@@ -2,18 +2,33 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"log/slog"
+	"os"
 
 	"github.com/faiface/pixel/pixelgl"
 	"github.com/jemgunay/procedural-game/file"
+	"github.com/jemgunay/procedural-game/logging"
 	"github.com/jemgunay/procedural-game/scene"
 )
 
 func main() {
+	logLevel := flag.String("log-level", "info", "minimum level of the messages logged, i.e. "+logging.Levels)
+	logFormat := flag.String("log-format", "text", "format of the messages logged, i.e. "+logging.Formats)
+	flag.Parse()
+
+	logger, err := logging.New(os.Stderr, *logLevel, *logFormat)
+	if err != nil {
+		fmt.Printf("failed to configure logging: %s\n", err)
+		os.Exit(1)
+	}
+	slog.SetDefault(logger)
+
 	pixelgl.Run(func() {
 		// load assets
 		if err := file.LoadAllAssets(); err != nil {
-			fmt.Printf("failed to process assets: %s\n", err)
+			slog.Error("failed to process assets", "err", err)
 			return
 		}
 
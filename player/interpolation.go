@@ -0,0 +1,117 @@
+package player
+
+import (
+	"math"
+	"time"
+
+	"github.com/faiface/pixel"
+
+	"github.com/jemgunay/procedural-game/sim"
+)
+
+const (
+	// DefaultInterpolationDelay is the default delay behind the present at which remote players are rendered. It should
+	// comfortably exceed the interval between server updates so that there are usually two snapshots to interpolate
+	// between.
+	DefaultInterpolationDelay = sim.InterpolationDelay
+	// maxExtrapolation is the longest period a remote player's movement is extrapolated for when no newer snapshot has
+	// arrived, e.g. due to packet loss. The player is held in place after this.
+	maxExtrapolation = time.Millisecond * 250
+	// snapshotBufferSize is the number of snapshots retained per remote player.
+	snapshotBufferSize = 32
+)
+
+// snapshot is a remote player's state received from the server at a point in time.
+type snapshot struct {
+	time time.Time
+	pos  pixel.Vec
+	rot  float64
+}
+
+// SnapshotBuffer is a time ordered ring buffer of a remote player's received states, which can be sampled at any point
+// in time by interpolating or extrapolating between them.
+type SnapshotBuffer struct {
+	snapshots [snapshotBufferSize]snapshot
+	head      int
+	count     int
+}
+
+// get returns the ith oldest snapshot.
+func (b *SnapshotBuffer) get(i int) snapshot {
+	return b.snapshots[(b.head+i)%snapshotBufferSize]
+}
+
+// Add appends a snapshot to the buffer, overwriting the oldest snapshot if the buffer is full. Snapshots older than
+// the newest snapshot are discarded.
+func (b *SnapshotBuffer) Add(t time.Time, pos pixel.Vec, rot float64) {
+	if b.count > 0 && t.Before(b.get(b.count-1).time) {
+		return
+	}
+	if b.count == snapshotBufferSize {
+		b.head = (b.head + 1) % snapshotBufferSize
+		b.count--
+	}
+	b.snapshots[(b.head+b.count)%snapshotBufferSize] = snapshot{time: t, pos: pos, rot: rot}
+	b.count++
+}
+
+// At samples the position and orientation at time t. Between snapshots, the state is linearly interpolated. After the
+// newest snapshot, the position is extrapolated from the last known velocity for up to maxExtrapolation. False is
+// returned if the buffer is empty.
+func (b *SnapshotBuffer) At(t time.Time) (pixel.Vec, float64, bool) {
+	if b.count == 0 {
+		return pixel.ZV, 0, false
+	}
+
+	oldest := b.get(0)
+	if !t.After(oldest.time) {
+		return oldest.pos, oldest.rot, true
+	}
+
+	// interpolate between the snapshots either side of t
+	for i := 1; i < b.count; i++ {
+		to := b.get(i)
+		if to.time.Before(t) {
+			continue
+		}
+		from := b.get(i - 1)
+		frac := fraction(t.Sub(from.time), to.time.Sub(from.time))
+		return lerp(from.pos, to.pos, frac), lerpAngle(from.rot, to.rot, frac), true
+	}
+
+	// no newer snapshot has arrived - extrapolate from the velocity between the two newest snapshots
+	newest := b.get(b.count - 1)
+	if b.count == 1 {
+		return newest.pos, newest.rot, true
+	}
+	prev := b.get(b.count - 2)
+	interval := newest.time.Sub(prev.time)
+	if interval <= 0 {
+		return newest.pos, newest.rot, true
+	}
+	ahead := t.Sub(newest.time)
+	if ahead > maxExtrapolation {
+		ahead = maxExtrapolation
+	}
+	vel := newest.pos.Sub(prev.pos).Scaled(1 / interval.Seconds())
+	return newest.pos.Add(vel.Scaled(ahead.Seconds())), newest.rot, true
+}
+
+// fraction returns elapsed as a fraction of total, clamped between 0 and 1.
+func fraction(elapsed, total time.Duration) float64 {
+	if total <= 0 {
+		return 1
+	}
+	return math.Max(0, math.Min(1, elapsed.Seconds()/total.Seconds()))
+}
+
+// lerp linearly interpolates between two positions.
+func lerp(a, b pixel.Vec, frac float64) pixel.Vec {
+	return a.Add(b.Sub(a).Scaled(frac))
+}
+
+// lerpAngle linearly interpolates between two angles in radians along the shortest arc.
+func lerpAngle(a, b, frac float64) float64 {
+	delta := math.Remainder(b-a, 2*math.Pi)
+	return a + delta*frac
+}
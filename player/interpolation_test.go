@@ -0,0 +1,66 @@
+package player
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/faiface/pixel"
+)
+
+func TestSnapshotBufferAt(t *testing.T) {
+	start := time.Unix(1557000000, 0)
+	at := func(ms int) time.Time {
+		return start.Add(time.Duration(ms) * time.Millisecond)
+	}
+
+	var b SnapshotBuffer
+	if _, _, ok := b.At(start); ok {
+		t.Fatal("expected empty buffer to have no state")
+	}
+
+	b.Add(at(0), pixel.V(0, 0), 3)
+	b.Add(at(100), pixel.V(100, 50), -3)
+	b.Add(at(200), pixel.V(200, 100), -3)
+	// out of order snapshots are discarded
+	b.Add(at(150), pixel.V(-1000, -1000), 0)
+
+	tests := []struct {
+		name string
+		time time.Time
+		pos  pixel.Vec
+		rot  float64
+	}{
+		{name: "before oldest", time: at(-50), pos: pixel.V(0, 0), rot: 3},
+		{name: "exact snapshot", time: at(100), pos: pixel.V(100, 50), rot: -3},
+		// rotation is interpolated across the -pi/pi boundary rather than the long way round
+		{name: "interpolated", time: at(50), pos: pixel.V(50, 25), rot: math.Pi},
+		{name: "extrapolated", time: at(300), pos: pixel.V(300, 150), rot: -3},
+		{name: "extrapolation capped", time: at(2000), pos: pixel.V(450, 225), rot: -3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pos, rot, ok := b.At(tt.time)
+			if !ok {
+				t.Fatal("expected buffer to have state")
+			}
+			if pos.Sub(tt.pos).Len() > 1e-9 || math.Abs(math.Remainder(rot-tt.rot, 2*math.Pi)) > 1e-9 {
+				t.Fatalf("expected pos %v rot %f, got pos %v rot %f", tt.pos, tt.rot, pos, rot)
+			}
+		})
+	}
+}
+
+func TestSnapshotBufferOverwritesOldest(t *testing.T) {
+	start := time.Unix(1557000000, 0)
+	var b SnapshotBuffer
+	for i := 0; i < snapshotBufferSize+10; i++ {
+		b.Add(start.Add(time.Duration(i)*time.Second), pixel.V(float64(i), 0), 0)
+	}
+
+	// the oldest retained snapshot is returned for times before it
+	pos, _, _ := b.At(start)
+	if pos.X != 10 {
+		t.Fatalf("expected oldest retained snapshot at x=10, got %v", pos)
+	}
+}
@@ -4,61 +4,65 @@ package player
 import (
 	"math"
 	"sync"
+	"time"
 
 	"github.com/faiface/pixel"
+	"github.com/faiface/pixel/imdraw"
 	"github.com/faiface/pixel/pixelgl"
+
+	"github.com/jemgunay/procedural-game/sim"
 )
 
 const PlayerSpriteScale = 0.3
 
+// health bar dimensions
+const (
+	healthBarWidth  = 60.0
+	healthBarHeight = 6.0
+	healthBarOffset = 60.0
+)
+
 // Player represents a drawable client player.
 type Player struct {
-	name            string
-	pos             pixel.Vec
-	prevPos         pixel.Vec
-	orientation     float64
-	prevOrientation float64
-	health          uint64
+	name        string
+	pos         pixel.Vec
+	orientation float64
+	health      uint64
 
 	baseSpeed float64
 	sprite    *pixel.Sprite
+	// snapshots are the states received from the server for remote players
+	snapshots SnapshotBuffer
 
 	sync.RWMutex
 }
 
-// Draw draws a player onto a window.
+// Draw draws a player and their health bar onto a window. Dead players are not drawn.
 func (p *Player) Draw(win *pixelgl.Window) {
 	p.RLock()
-	p.sprite.Draw(win, pixel.IM.Moved(p.pos).Scaled(p.pos, PlayerSpriteScale).Rotated(p.pos, p.orientation))
-	p.RUnlock()
-}
+	defer p.RUnlock()
+	if p.health == 0 {
+		return
+	}
 
-// Up moves the player upwards.
-func (p *Player) Up(dt float64) {
-	p.Lock()
-	p.pos.Y += p.baseSpeed * dt
-	p.Unlock()
-}
-
-// Down moves the player downwards.
-func (p *Player) Down(dt float64) {
-	p.Lock()
-	p.pos.Y -= p.baseSpeed * dt
-	p.Unlock()
-}
+	p.sprite.Draw(win, pixel.IM.Moved(p.pos).Scaled(p.pos, PlayerSpriteScale).Rotated(p.pos, p.orientation))
 
-// Left moves the player leftwards.
-func (p *Player) Left(dt float64) {
-	p.Lock()
-	p.pos.X -= p.baseSpeed * dt
-	p.Unlock()
+	// draw remaining health over a red background
+	healthFrac := math.Min(1, float64(p.health)/float64(sim.MaxHealth))
+	barMin := p.pos.Add(pixel.V(-healthBarWidth/2, healthBarOffset))
+	bar := imdraw.New(nil)
+	bar.Color = pixel.RGB(0.8, 0.1, 0.1)
+	bar.Push(barMin, barMin.Add(pixel.V(healthBarWidth, healthBarHeight)))
+	bar.Rectangle(0)
+	bar.Color = pixel.RGB(0.1, 0.8, 0.1)
+	bar.Push(barMin, barMin.Add(pixel.V(healthBarWidth*healthFrac, healthBarHeight)))
+	bar.Rectangle(0)
+	bar.Draw(win)
 }
 
-// Right moves the player rightwards.
-func (p *Player) Right(dt float64) {
-	p.Lock()
-	p.pos.X += p.baseSpeed * dt
-	p.Unlock()
+// Name retrieves the player's username.
+func (p *Player) Name() string {
+	return p.name
 }
 
 // Health retrieves the player health.
@@ -76,6 +80,11 @@ func (p *Player) SetHealth(health uint64) {
 	p.Unlock()
 }
 
+// Dead determines whether the player is dead and awaiting respawn.
+func (p *Player) Dead() bool {
+	return p.Health() == 0
+}
+
 // Pos retrieves the player position.
 func (p *Player) Pos() pixel.Vec {
 	p.RLock()
@@ -87,7 +96,6 @@ func (p *Player) Pos() pixel.Vec {
 // SetPos moves the player to the specified coordinates.
 func (p *Player) SetPos(pos pixel.Vec) {
 	p.Lock()
-	p.prevPos = p.pos
 	p.pos = pos
 	p.Unlock()
 }
@@ -103,7 +111,6 @@ func (p *Player) Orientation() float64 {
 // SetOrientation sets the player's orientation.
 func (p *Player) SetOrientation(orientation float64) {
 	p.Lock()
-	p.prevOrientation = p.orientation
 	p.orientation = orientation
 	p.Unlock()
 }
@@ -119,15 +126,36 @@ func (p *Player) Speed() float64 {
 // PointTo rotates the player to face the specified target.
 func (p *Player) PointTo(target pixel.Vec) {
 	p.Lock()
-	p.prevOrientation = p.orientation
 	p.orientation = math.Atan2(target.Y-p.pos.Y, target.X-p.pos.X)
 	p.Unlock()
 }
 
-// HasMoved determines if the player has moved position or changed orientation.
-func (p *Player) HasMoved() bool {
-	p.RLock()
-	moved := p.pos != p.prevPos && p.orientation != p.prevOrientation
-	p.RUnlock()
-	return moved
+// AddSnapshot records a remote player state received from the server at time t, to be rendered once the interpolation
+// delay has passed.
+func (p *Player) AddSnapshot(t time.Time, pos pixel.Vec, orientation float64) {
+	p.Lock()
+	p.snapshots.Add(t, pos, orientation)
+	p.Unlock()
+}
+
+// Teleport moves a remote player to a new state received at time t without interpolating from its previous states,
+// e.g. when respawning.
+func (p *Player) Teleport(t time.Time, pos pixel.Vec, orientation float64) {
+	p.Lock()
+	p.snapshots = SnapshotBuffer{}
+	p.snapshots.Add(t, pos, orientation)
+	p.pos = pos
+	p.orientation = orientation
+	p.Unlock()
+}
+
+// Interpolate moves the player to its state at time t, as sampled from its received snapshots. Players without any
+// snapshots, such as the main player, are left untouched.
+func (p *Player) Interpolate(t time.Time) {
+	p.Lock()
+	if pos, rot, ok := p.snapshots.At(t); ok {
+		p.pos = pos
+		p.orientation = rot
+	}
+	p.Unlock()
 }
@@ -5,26 +5,49 @@ import (
 	"fmt"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/faiface/pixel"
 	"github.com/faiface/pixel/pixelgl"
 
 	"github.com/jemgunay/procedural-game/file"
+	"github.com/jemgunay/procedural-game/sim"
 )
 
 // Store is a player store which can be concurrently accessed safely.
 type Store struct {
 	players map[string]*Player
+	// interpolationDelay is how far behind the present remote players are rendered
+	interpolationDelay time.Duration
 	sync.RWMutex
 }
 
 // NewStore creates and initialises a new player store.
 func NewStore() *Store {
 	return &Store{
-		players: make(map[string]*Player),
+		players:            make(map[string]*Player),
+		interpolationDelay: DefaultInterpolationDelay,
 	}
 }
 
+// SetInterpolationDelay sets how far behind the present remote players are rendered. Longer delays tolerate more
+// jitter and packet loss at the cost of showing older state.
+func (s *Store) SetInterpolationDelay(delay time.Duration) {
+	s.Lock()
+	s.interpolationDelay = delay
+	s.Unlock()
+}
+
+// Interpolate moves each remote player to its interpolated state at the interpolation delay behind now.
+func (s *Store) Interpolate(now time.Time) {
+	s.RLock()
+	renderTime := now.Add(-s.interpolationDelay)
+	for _, p := range s.players {
+		p.Interpolate(renderTime)
+	}
+	s.RUnlock()
+}
+
 // Find returns the player which corresponds with the specified username.
 func (s *Store) Find(username string) (*Player, error) {
 	s.RLock()
@@ -55,7 +78,8 @@ func (s *Store) Add(username string) (*Player, error) {
 	newPlayer := &Player{
 		name:        username,
 		pos:         pixel.ZV,
-		baseSpeed:   300.0,
+		baseSpeed:   sim.BaseSpeed,
+		health:      sim.MaxHealth,
 		orientation: 0.0,
 		sprite:      sprite,
 	}
@@ -74,6 +98,17 @@ func (s *Store) Remove(username string) {
 	s.Unlock()
 }
 
+// RemoveOthers removes every player other than the named player from the player store.
+func (s *Store) RemoveOthers(username string) {
+	s.Lock()
+	for name := range s.players {
+		if name != username {
+			delete(s.players, name)
+		}
+	}
+	s.Unlock()
+}
+
 // Draw draws each of the players in the player store.
 func (s *Store) Draw(win *pixelgl.Window) {
 	s.RLock()
@@ -1,7 +1,7 @@
 package player
 
 import (
-	"fmt"
+	"log/slog"
 	"sync"
 	"time"
 
@@ -9,67 +9,65 @@ import (
 	"github.com/faiface/pixel/imdraw"
 	"github.com/faiface/pixel/pixelgl"
 	"github.com/jemgunay/procedural-game/client"
-	"github.com/jemgunay/procedural-game/server"
+	"github.com/jemgunay/procedural-game/protocol"
+	"github.com/jemgunay/procedural-game/sim"
 	"github.com/pkg/errors"
 )
 
 var (
-	AmmoStore    map[Ammo]int
+	AmmoStore    map[sim.Ammo]int
 	Armoury      []*ProjectileWeapon
 	ActiveWeapon *ProjectileWeapon
-	Projectiles  []Projectile
+	// Projectiles are the projectiles in flight. They're created from snapshots received on the server update
+	// goroutine and updated and drawn on the render goroutine, so they must only be accessed with projectilesMu held.
+	Projectiles   []Projectile
+	projectilesMu sync.Mutex
 
-	projectileSpeed   = 100.0
 	isWeaponTriggered bool
+	// gameClient is the server connection that the main player's projectiles and reloads are sent over
+	gameClient *client.Client
 )
 
-// InitArmoury initialises the main player's armoury, setting the starting ammo and weapons.
-func InitArmoury() {
-	// give initial ammo stock
-	AmmoStore = map[Ammo]int{
-		PistolAmmo:  14,
-		RifleAmmo:   60,
-		ShotgunAmmo: 20,
-	}
+// InitArmoury initialises the main player's armoury, setting the starting ammo and weapons. Projectiles and reloads
+// are sent to the server over the provided client.
+func InitArmoury(c *client.Client) {
+	gameClient = c
 
-	// give pistol
-	if err := CollectWeapon(Deagle); err != nil {
-		fmt.Printf("failed to add new weapon: %s\n", err)
+	// give initial ammo stock
+	AmmoStore = make(map[sim.Ammo]int, len(sim.StartingAmmo))
+	for ammo, count := range sim.StartingAmmo {
+		AmmoStore[ammo] = count
 	}
 
-	// give rifle
-	if err := CollectWeapon(M4A1); err != nil {
-		fmt.Printf("failed to add new weapon: %s\n", err)
+	// give starting weapons
+	Armoury, ActiveWeapon = nil, nil
+	for _, name := range sim.StartingWeapons {
+		if err := CollectWeapon(name); err != nil {
+			slog.Error("failed to add new weapon", "weapon", name, "err", err)
+		}
 	}
 }
 
-// Ammo represents an ammunition type.
-type Ammo string
-
-// Ammunition type constants.
-const (
-	PistolAmmo  Ammo = "pistol"
-	RifleAmmo   Ammo = "rifle"
-	ShotgunAmmo Ammo = "shotgun"
-)
-
 // CollectWeapon adds the specified weapon to the player's weapon inventory.
-func CollectWeapon(name WeaponName) error {
-	w, ok := weapons[name]
+func CollectWeapon(name sim.WeaponName) error {
+	stats, ok := sim.Weapons[name]
 	if !ok {
 		return errors.New("weapon \"" + string(name) + "\" does not exist")
 	}
-	w.currentAmmoCapacity = w.maxAmmoCapacity
-	w.state = Ready
+	w := &ProjectileWeapon{
+		Weapon:              stats,
+		currentAmmoCapacity: stats.MaxAmmoCapacity,
+		state:               Ready,
+	}
 
-	Armoury = append(Armoury, &w)
+	Armoury = append(Armoury, w)
 	SwitchWeapon(len(Armoury))
 	return nil
 }
 
 // SwitchWeapon switches the player's active weapon to one in their inventory.
 func SwitchWeapon(inventorySlot int) {
-	if len(weapons) < inventorySlot {
+	if len(Armoury) < inventorySlot {
 		return
 	}
 	if ActiveWeapon != nil {
@@ -82,42 +80,7 @@ func SwitchWeapon(inventorySlot int) {
 		}
 	}
 	ActiveWeapon = Armoury[inventorySlot-1]
-	fmt.Printf("switched to %s\n", ActiveWeapon)
-}
-
-// WeaponName represents a weapon name.
-type WeaponName string
-
-// Weapon type constants.
-const (
-	Deagle WeaponName = "Deagle"
-	M4A1   WeaponName = "M4A1"
-)
-
-// weapons is the list of supported weapons
-var weapons = map[WeaponName]ProjectileWeapon{
-	Deagle: {
-		weaponName:      Deagle,
-		ammoType:        PistolAmmo,
-		automatic:       false,
-		maxAmmoCapacity: 7,
-		barrelLength:    40,
-		maxSpreadAngle:  3.2,
-
-		fireDelay:   time.Millisecond * 500,
-		reloadDelay: time.Second * 3,
-	},
-	M4A1: {
-		weaponName:      M4A1,
-		ammoType:        RifleAmmo,
-		automatic:       true,
-		maxAmmoCapacity: 30,
-		barrelLength:    70,
-		maxSpreadAngle:  4,
-
-		fireDelay:   time.Millisecond * 150,
-		reloadDelay: time.Second * 3,
-	},
+	slog.Debug("switched weapon", "weapon", ActiveWeapon.Name)
 }
 
 // WeaponState represents the current state of a weapon.
@@ -134,16 +97,8 @@ const (
 
 // ProjectileWeapon is a weapon that produces projectiles.
 type ProjectileWeapon struct {
-	weaponName          WeaponName
-	ammoType            Ammo
-	automatic           bool
-	maxAmmoCapacity     uint
+	sim.Weapon
 	currentAmmoCapacity uint
-	fireDelay           time.Duration
-	reloadDelay         time.Duration
-
-	barrelLength   float64
-	maxSpreadAngle float64
 
 	state           WeaponState
 	stateChangeTime time.Time
@@ -152,36 +107,57 @@ type ProjectileWeapon struct {
 
 // String returns the weapon's name as a string.
 func (p *ProjectileWeapon) String() string {
-	return string(p.weaponName)
+	return string(p.Name)
 }
 
-func NewProjectile(pos, vel pixel.Vec, spawnTime time.Time, ttl time.Duration) {
+// NewProjectile creates a projectile fired by another player, identified by the ID assigned to it by the server.
+func NewProjectile(id uint32, pos, vel pixel.Vec, spawnTime time.Time, ttl time.Duration) {
 	newProjectile := Projectile{
+		id:        id,
 		startPos:  pos,
 		velocity:  vel,
 		spawnTime: spawnTime,
 		ttl:       ttl,
 	}
+	projectilesMu.Lock()
 	Projectiles = append(Projectiles, newProjectile)
+	projectilesMu.Unlock()
+}
+
+// RemoveProjectile removes a projectile fired by another player, e.g. once it has hit a player.
+func RemoveProjectile(id uint32) {
+	projectilesMu.Lock()
+	defer projectilesMu.Unlock()
+	for i, p := range Projectiles {
+		if p.id == id {
+			Projectiles = append(Projectiles[:i], Projectiles[i+1:]...)
+			return
+		}
+	}
 }
 
 // Projectile represents a single projectile. It contains time information to determine when it should be destroyed.
 type Projectile struct {
+	// id is the ID assigned by the server to projectiles fired by other players, or 0 for the main player's projectiles
+	id            uint32
+	weapon        sim.WeaponName
 	startPos, pos pixel.Vec
 	velocity      pixel.Vec
 	spawnTime     time.Time
 	ttl           time.Duration
 }
 
-func (p Projectile) Concat() string {
-	return fmt.Sprintf("%d|%f|%f|%s|%f|%f",
-		p.spawnTime.UnixNano(),
-		p.startPos.X,
-		p.startPos.Y,
-		p.ttl.String(),
-		p.velocity.X,
-		p.velocity.Y,
-	)
+// Payload produces the create projectile message describing the projectile.
+func (p Projectile) Payload() protocol.Projectile {
+	return protocol.Projectile{
+		Weapon:    string(p.weapon),
+		SpawnTime: p.spawnTime.UnixNano(),
+		StartX:    p.startPos.X,
+		StartY:    p.startPos.Y,
+		VelX:      p.velocity.X,
+		VelY:      p.velocity.Y,
+		TTL:       p.ttl,
+	}
 }
 
 // Reload sets the currently active weapon's state to reloading assuming the weapon is in a reloadable state.
@@ -192,16 +168,22 @@ func Reload() {
 	if ActiveWeapon.state != Ready {
 		return
 	}
-	if ActiveWeapon.currentAmmoCapacity == ActiveWeapon.maxAmmoCapacity {
+	if ActiveWeapon.currentAmmoCapacity == ActiveWeapon.MaxAmmoCapacity {
 		return
 	}
-	if AmmoStore[ActiveWeapon.ammoType] <= 0 {
-		fmt.Println("not enough ammo to reload")
+	if AmmoStore[ActiveWeapon.Ammo] <= 0 {
+		slog.Debug("not enough ammo to reload", "weapon", ActiveWeapon.Name)
 		return
 	}
 
 	ActiveWeapon.state = Reloading
 	ActiveWeapon.stateChangeTime = time.Now().UTC()
+
+	// the server tracks ammo to validate projectiles
+	gameClient.Send(protocol.Reload{
+		Weapon: string(ActiveWeapon.Name),
+		Time:   ActiveWeapon.stateChangeTime.UnixNano(),
+	})
 }
 
 // Attack causes the weapon to be fireable assuming the weapon is in a state to be fired.
@@ -217,46 +199,47 @@ func StopAttack() {
 // Shoot causes a projectile to be fired from a weapon. It determines the initial position and direction the projectile
 // should have, and alters the active weapon's state.
 func (p *Player) Shoot() {
-	if ActiveWeapon == nil {
+	if ActiveWeapon == nil || p.Dead() {
 		return
 	}
 	if ActiveWeapon.state != Ready {
-		fmt.Printf("weapon not ready to shoot: %s", ActiveWeapon.stateChangeTime)
+		slog.Debug("weapon not ready to shoot", "weapon", ActiveWeapon.Name, "since", ActiveWeapon.stateChangeTime)
 		return
 	}
 	if ActiveWeapon.currentAmmoCapacity <= 0 {
-		fmt.Println("out of ammo")
+		slog.Debug("out of ammo", "weapon", ActiveWeapon.Name)
 		return
 	}
 
 	projectileUnit := pixel.Unit(p.Orientation())
-	startPos := p.Pos().Add(projectileUnit.Scaled(ActiveWeapon.barrelLength))
+	startPos := p.Pos().Add(projectileUnit.Scaled(ActiveWeapon.BarrelLength))
 	// determine offset to position bullet to the right on the tip of the weapon barrel
 	startPos = startPos.Add(pixel.Unit(p.Orientation() - 90).Scaled((p.sprite.Frame().H() * PlayerSpriteScale) / 4))
 
 	projectile := Projectile{
+		weapon:    ActiveWeapon.Name,
 		startPos:  startPos,
 		pos:       startPos,
-		velocity:  projectileUnit.Scaled(projectileSpeed),
+		velocity:  projectileUnit.Scaled(sim.ProjectileSpeed),
 		spawnTime: time.Now().UTC(),
-		ttl:       time.Second * 5,
+		ttl:       sim.ProjectileTTL,
 	}
+	projectilesMu.Lock()
 	Projectiles = append(Projectiles, projectile)
+	projectilesMu.Unlock()
 
 	// consume ammo round
 	ActiveWeapon.currentAmmoCapacity--
-	fmt.Printf("shot: ammo in weapon: %d/%d, ammo in armoury: %d\n", ActiveWeapon.currentAmmoCapacity, ActiveWeapon.maxAmmoCapacity, AmmoStore[ActiveWeapon.ammoType])
+	slog.Debug("shot", "weapon", ActiveWeapon.Name, "ammo", ActiveWeapon.currentAmmoCapacity, "armoury",
+		AmmoStore[ActiveWeapon.Ammo])
 	ActiveWeapon.state = Attacking
 	ActiveWeapon.stateChangeTime = time.Now().UTC()
 
-	if !ActiveWeapon.automatic {
+	if !ActiveWeapon.Automatic {
 		isWeaponTriggered = false
 	}
 
-	client.Send(server.Message{
-		Type:  "create_projectile",
-		Value: projectile.Concat(),
-	})
+	gameClient.Send(projectile.Payload())
 }
 
 func (p *Player) Update(dt float64) {
@@ -265,24 +248,25 @@ func (p *Player) Update(dt float64) {
 
 		switch ActiveWeapon.state {
 		case Attacking:
-			if ActiveWeapon.stateChangeTime.Add(ActiveWeapon.fireDelay).After(time.Now().UTC()) {
+			if !time.Now().UTC().Before(ActiveWeapon.stateChangeTime.Add(ActiveWeapon.FireDelay)) {
 				ActiveWeapon.state = Ready
 			}
 
 		case Reloading:
-			if ActiveWeapon.stateChangeTime.Add(ActiveWeapon.reloadDelay).After(time.Now().UTC()) {
-				requiredAmmo := ActiveWeapon.maxAmmoCapacity - ActiveWeapon.currentAmmoCapacity
-				availableAmmo := AmmoStore[ActiveWeapon.ammoType]
+			if !time.Now().UTC().Before(ActiveWeapon.stateChangeTime.Add(ActiveWeapon.ReloadDelay)) {
+				requiredAmmo := ActiveWeapon.MaxAmmoCapacity - ActiveWeapon.currentAmmoCapacity
+				availableAmmo := AmmoStore[ActiveWeapon.Ammo]
 
 				if availableAmmo >= int(requiredAmmo) {
-					AmmoStore[ActiveWeapon.ammoType] = availableAmmo - int(requiredAmmo)
+					AmmoStore[ActiveWeapon.Ammo] = availableAmmo - int(requiredAmmo)
 					ActiveWeapon.currentAmmoCapacity += requiredAmmo
 				} else {
-					AmmoStore[ActiveWeapon.ammoType] = 0
+					AmmoStore[ActiveWeapon.Ammo] = 0
 					ActiveWeapon.currentAmmoCapacity += uint(availableAmmo)
 				}
 				ActiveWeapon.state = Ready
-				fmt.Printf("reloaded: ammo in weapon: %d/%d, ammo in armoury: %d\n", ActiveWeapon.currentAmmoCapacity, ActiveWeapon.maxAmmoCapacity, AmmoStore[ActiveWeapon.ammoType])
+				slog.Debug("reloaded", "weapon", ActiveWeapon.Name, "ammo", ActiveWeapon.currentAmmoCapacity, "armoury",
+					AmmoStore[ActiveWeapon.Ammo])
 			}
 		case Ready:
 			if isWeaponTriggered {
@@ -292,6 +276,8 @@ func (p *Player) Update(dt float64) {
 		ActiveWeapon.Unlock()
 	}
 
+	projectilesMu.Lock()
+	defer projectilesMu.Unlock()
 	var aliveProjectiles []Projectile
 	for _, p := range Projectiles {
 		// only retain projectiles with unexpired TTLs
@@ -305,6 +291,8 @@ func (p *Player) Update(dt float64) {
 }
 
 func DrawProjectiles(win *pixelgl.Window) {
+	projectilesMu.Lock()
+	defer projectilesMu.Unlock()
 	for _, p := range Projectiles {
 		circle := imdraw.New(nil)
 		circle.Color = pixel.RGB(0.2, 0.2, 0.2)
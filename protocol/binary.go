@@ -0,0 +1,456 @@
+package protocol
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+	"time"
+)
+
+// Codec names which can be requested by a client during the handshake.
+const (
+	JSONCodecName   = "json"
+	BinaryCodecName = "binary"
+)
+
+// NewCodec creates a new codec instance corresponding with the provided codec name.
+func NewCodec(name string) (Codec, error) {
+	switch name {
+	case "", JSONCodecName:
+		return JSONCodec{}, nil
+	case BinaryCodecName:
+		return NewBinaryCodec(), nil
+	}
+	return nil, fmt.Errorf("unsupported codec: %s", name)
+}
+
+const (
+	// maxFrameSize is the largest binary frame body which will be accepted.
+	maxFrameSize = 1 << 20
+	// posScale is the fixed-point scale applied to positions, i.e. positions have a precision of 1/100th of a pixel.
+	posScale = 100
+	// rotScale is the fixed-point scale applied to orientations in radians, which are normalised to [-π, π] so that
+	// they fit in 16 bits.
+	rotScale = 10000
+)
+
+// binary frame kinds
+const (
+	kindJSON byte = iota
+	kindVitals
+	kindProjectile
+	kindInput
+	kindSnapshot
+)
+
+// vitals/input flags
+const (
+	flagDelta byte = 1 << iota
+	flagFire
+)
+
+// BinaryCodec encodes high frequency vitals, snapshot, input and projectile messages into compact length-prefixed
+// binary frames with fixed-width fields. Vitals positions are delta-encoded against the previous position sent for the
+// same player, so each connection direction requires its own BinaryCodec instance and frames must be delivered in
+// order, unless delta encoding is disabled. All other message types are carried as JSON within a binary frame.
+type BinaryCodec struct {
+	// absolute disables delta encoding so that frames can be decoded independently of each other, e.g. when they may be
+	// lost or reordered
+	absolute bool
+
+	encMu  sync.Mutex
+	encBuf []byte
+	encPos map[string]fixedPos
+
+	decBuf []byte
+	decPos map[string]fixedPos
+}
+
+// fixedPos is a position quantised to fixed-point integers.
+type fixedPos struct {
+	x, y int32
+}
+
+// NewBinaryCodec creates and initialises a new BinaryCodec.
+func NewBinaryCodec() *BinaryCodec {
+	return &BinaryCodec{
+		encPos: make(map[string]fixedPos),
+		decPos: make(map[string]fixedPos),
+	}
+}
+
+// Encode writes a payload to w as a single binary frame.
+func (c *BinaryCodec) Encode(w io.Writer, p Payload) error {
+	// the delta state must be updated in the same order that frames are written
+	c.encMu.Lock()
+	defer c.encMu.Unlock()
+
+	// reserve space for the length prefix, which is filled in once the body size is known
+	body := append(c.encBuf[:0], make([]byte, binary.MaxVarintLen32)...)
+	switch v := p.(type) {
+	case Vitals:
+		body = append(body, kindVitals)
+		body = c.appendVitals(body, v)
+	case Snapshot:
+		body = c.appendSnapshot(body, v)
+	case Projectile:
+		body = append(body, kindProjectile)
+		body = appendProjectileFields(body, v)
+	case Input:
+		body = appendInput(body, v)
+	default:
+		data, err := json.Marshal(p)
+		if err != nil {
+			return fmt.Errorf("failed to marshal %s payload: %s", p.Type(), err)
+		}
+		raw, err := json.Marshal(envelope{Type: p.Type(), Data: data})
+		if err != nil {
+			return fmt.Errorf("failed to marshal %s message: %s", p.Type(), err)
+		}
+		body = append(body, kindJSON)
+		body = append(body, raw...)
+	}
+	c.encBuf = body
+
+	// write the length prefix immediately before the body
+	size := len(body) - binary.MaxVarintLen32
+	var prefix [binary.MaxVarintLen32]byte
+	n := binary.PutUvarint(prefix[:], uint64(size))
+	start := binary.MaxVarintLen32 - n
+	copy(body[start:], prefix[:n])
+
+	_, err := w.Write(body[start:])
+	return err
+}
+
+// Decode reads a single binary frame from r and decodes it into its typed payload.
+func (c *BinaryCodec) Decode(r *bufio.Reader) (Payload, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if size == 0 || size > maxFrameSize {
+		// the stream can't be resynchronised after an invalid length prefix
+		return nil, fmt.Errorf("invalid binary frame size: %d", size)
+	}
+
+	if uint64(cap(c.decBuf)) < size {
+		c.decBuf = make([]byte, size)
+	}
+	body := c.decBuf[:size]
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+
+	d := frameReader{buf: body[1:]}
+	var p Payload
+	switch body[0] {
+	case kindVitals:
+		p = c.readVitals(&d)
+	case kindSnapshot:
+		p = c.readSnapshot(&d)
+	case kindProjectile:
+		p = readProjectile(&d)
+	case kindInput:
+		p = readInput(&d)
+	case kindJSON:
+		var env envelope
+		if err := json.Unmarshal(body[1:], &env); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidMessage, err)
+		}
+		if p, err = unmarshalPayload(env.Type, env.Data); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidMessage, err)
+		}
+	default:
+		return nil, fmt.Errorf("%w: unsupported binary frame kind: %d", ErrInvalidMessage, body[0])
+	}
+
+	if d.err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidMessage, d.err)
+	}
+	return p, nil
+}
+
+// appendVitals appends the fields of a player's vitals to buf. The position is encoded as a 16-bit delta from the
+// previous position sent for the player if the delta fits, otherwise the absolute 32-bit position is sent.
+func (c *BinaryCodec) appendVitals(buf []byte, v Vitals) []byte {
+	pos := fixedPos{x: quantise(v.X, posScale), y: quantise(v.Y, posScale)}
+	prev, ok := c.encPos[v.Name]
+	c.encPos[v.Name] = pos
+
+	dx, dy := int64(pos.x)-int64(prev.x), int64(pos.y)-int64(prev.y)
+	delta := !c.absolute && ok && fitsInt16(dx) && fitsInt16(dy)
+
+	var flags byte
+	if delta {
+		flags |= flagDelta
+	}
+	buf = append(buf, flags)
+	buf = appendString(buf, v.Name)
+	if delta {
+		buf = appendUint16(buf, uint16(int16(dx)))
+		buf = appendUint16(buf, uint16(int16(dy)))
+	} else {
+		buf = appendUint32(buf, uint32(pos.x))
+		buf = appendUint32(buf, uint32(pos.y))
+	}
+	buf = appendUint16(buf, uint16(quantiseAngle(v.Rot)))
+	health := v.Health
+	if health > math.MaxUint16 {
+		health = math.MaxUint16
+	}
+	buf = appendUint16(buf, uint16(health))
+	return appendUint32(buf, v.Seq)
+}
+
+// readVitals reads the fields of a player's vitals, resolving delta-encoded positions against the previously received
+// position for the player.
+func (c *BinaryCodec) readVitals(d *frameReader) Vitals {
+	flags := d.u8()
+	name := d.str()
+
+	var pos fixedPos
+	if flags&flagDelta != 0 {
+		prev, ok := c.decPos[name]
+		if !ok {
+			d.fail(errors.New("vitals delta received without a previous position"))
+		}
+		pos.x = prev.x + int32(int16(d.u16()))
+		pos.y = prev.y + int32(int16(d.u16()))
+	} else {
+		pos.x = int32(d.u32())
+		pos.y = int32(d.u32())
+	}
+	rot := int16(d.u16())
+	health := d.u16()
+	seq := d.u32()
+	if d.err != nil {
+		return Vitals{}
+	}
+	c.decPos[name] = pos
+
+	return Vitals{
+		Name:   name,
+		X:      float64(pos.x) / posScale,
+		Y:      float64(pos.y) / posScale,
+		Rot:    float64(rot) / rotScale,
+		Health: uint64(health),
+		Seq:    seq,
+	}
+}
+
+// appendSnapshot appends a snapshot frame body to buf. Each list in the snapshot is prefixed with its 16-bit length,
+// so is limited to 65535 entries.
+func (c *BinaryCodec) appendSnapshot(buf []byte, s Snapshot) []byte {
+	buf = append(buf, kindSnapshot)
+	buf = appendUint32(buf, s.Tick)
+	buf = appendUint32(buf, s.Baseline)
+
+	n := capLength(len(s.Players))
+	buf = appendUint16(buf, uint16(n))
+	for _, v := range s.Players[:n] {
+		buf = c.appendVitals(buf, v)
+	}
+	n = capLength(len(s.RemovedPlayers))
+	buf = appendUint16(buf, uint16(n))
+	for _, name := range s.RemovedPlayers[:n] {
+		buf = appendString(buf, name)
+	}
+	n = capLength(len(s.Projectiles))
+	buf = appendUint16(buf, uint16(n))
+	for _, p := range s.Projectiles[:n] {
+		buf = appendProjectileFields(buf, p)
+	}
+	n = capLength(len(s.RemovedProjectiles))
+	buf = appendUint16(buf, uint16(n))
+	for _, id := range s.RemovedProjectiles[:n] {
+		buf = appendUint32(buf, id)
+	}
+	return buf
+}
+
+// readSnapshot reads a snapshot frame body.
+func (c *BinaryCodec) readSnapshot(d *frameReader) Snapshot {
+	s := Snapshot{
+		Tick:     d.u32(),
+		Baseline: d.u32(),
+	}
+	for i, n := 0, int(d.u16()); i < n && d.err == nil; i++ {
+		s.Players = append(s.Players, c.readVitals(d))
+	}
+	for i, n := 0, int(d.u16()); i < n && d.err == nil; i++ {
+		s.RemovedPlayers = append(s.RemovedPlayers, d.str())
+	}
+	for i, n := 0, int(d.u16()); i < n && d.err == nil; i++ {
+		s.Projectiles = append(s.Projectiles, readProjectile(d))
+	}
+	for i, n := 0, int(d.u16()); i < n && d.err == nil; i++ {
+		s.RemovedProjectiles = append(s.RemovedProjectiles, d.u32())
+	}
+	return s
+}
+
+// capLength caps a list length to the maximum which can be encoded in a 16-bit length prefix.
+func capLength(n int) int {
+	if n > math.MaxUint16 {
+		return math.MaxUint16
+	}
+	return n
+}
+
+// appendInput appends an input frame body to buf. The duration is encoded in microseconds.
+func appendInput(buf []byte, in Input) []byte {
+	var flags byte
+	if in.Fire {
+		flags |= flagFire
+	}
+	buf = append(buf, kindInput, flags)
+	buf = appendUint32(buf, in.Seq)
+	buf = append(buf, byte(in.MoveX), byte(in.MoveY))
+	buf = appendUint16(buf, uint16(quantiseAngle(in.Aim)))
+	return appendUint32(buf, uint32(in.Duration/time.Microsecond))
+}
+
+// readInput reads an input frame body.
+func readInput(d *frameReader) Input {
+	flags := d.u8()
+	return Input{
+		Seq:      d.u32(),
+		MoveX:    int8(d.u8()),
+		MoveY:    int8(d.u8()),
+		Aim:      float64(int16(d.u16())) / rotScale,
+		Fire:     flags&flagFire != 0,
+		Duration: time.Duration(d.u32()) * time.Microsecond,
+	}
+}
+
+// appendProjectileFields appends the fields of a projectile to buf.
+func appendProjectileFields(buf []byte, p Projectile) []byte {
+	buf = appendUint32(buf, p.ID)
+	buf = appendString(buf, p.Owner)
+	buf = appendString(buf, p.Weapon)
+	buf = appendUint64(buf, uint64(p.SpawnTime))
+	buf = appendUint32(buf, math.Float32bits(float32(p.StartX)))
+	buf = appendUint32(buf, math.Float32bits(float32(p.StartY)))
+	buf = appendUint32(buf, math.Float32bits(float32(p.VelX)))
+	buf = appendUint32(buf, math.Float32bits(float32(p.VelY)))
+	return appendUint32(buf, uint32(p.TTL/time.Millisecond))
+}
+
+// readProjectile reads the fields of a projectile.
+func readProjectile(d *frameReader) Projectile {
+	return Projectile{
+		ID:        d.u32(),
+		Owner:     d.str(),
+		Weapon:    d.str(),
+		SpawnTime: int64(d.u64()),
+		StartX:    float64(math.Float32frombits(d.u32())),
+		StartY:    float64(math.Float32frombits(d.u32())),
+		VelX:      float64(math.Float32frombits(d.u32())),
+		VelY:      float64(math.Float32frombits(d.u32())),
+		TTL:       time.Duration(d.u32()) * time.Millisecond,
+	}
+}
+
+// quantise converts a float into a fixed-point integer with the provided scale.
+func quantise(f, scale float64) int32 {
+	return int32(math.Round(f * scale))
+}
+
+// quantiseAngle converts an angle in radians into a 16-bit fixed-point integer, normalising it to [-π, π] first so
+// that equivalent angles outside that range aren't corrupted by overflowing.
+func quantiseAngle(a float64) int16 {
+	return int16(quantise(math.Remainder(a, 2*math.Pi), rotScale))
+}
+
+func fitsInt16(n int64) bool {
+	return n >= math.MinInt16 && n <= math.MaxInt16
+}
+
+// appendString appends a string prefixed with its 8-bit length. Strings longer than 255 bytes are truncated.
+func appendString(buf []byte, s string) []byte {
+	if len(s) > math.MaxUint8 {
+		s = s[:math.MaxUint8]
+	}
+	buf = append(buf, byte(len(s)))
+	return append(buf, s...)
+}
+
+func appendUint16(buf []byte, v uint16) []byte {
+	return append(buf, byte(v>>8), byte(v))
+}
+
+func appendUint32(buf []byte, v uint32) []byte {
+	return append(buf, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendUint64(buf []byte, v uint64) []byte {
+	return appendUint32(appendUint32(buf, uint32(v>>32)), uint32(v))
+}
+
+// frameReader reads fixed-width fields from a frame body. The first out of bounds read is recorded in err, after which
+// all reads return zero values.
+type frameReader struct {
+	buf []byte
+	err error
+}
+
+func (d *frameReader) fail(err error) {
+	if d.err == nil {
+		d.err = err
+	}
+}
+
+func (d *frameReader) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if len(d.buf) < n {
+		d.fail(errors.New("binary frame too short"))
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *frameReader) u8() byte {
+	if b := d.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (d *frameReader) u16() uint16 {
+	if b := d.next(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (d *frameReader) u32() uint32 {
+	if b := d.next(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (d *frameReader) u64() uint64 {
+	if b := d.next(8); b != nil {
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (d *frameReader) str() string {
+	n := int(d.u8())
+	if b := d.next(n); b != nil {
+		return string(b)
+	}
+	return ""
+}
@@ -0,0 +1,197 @@
+package protocol
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrInvalidMessage indicates that a message was received intact but could not be decoded. The connection remains
+// usable after this error.
+var ErrInvalidMessage = errors.New("invalid message")
+
+// Codec encodes payloads onto and decodes payloads from a connection's byte stream.
+type Codec interface {
+	// Encode writes a single framed payload to w.
+	Encode(w io.Writer, p Payload) error
+	// Decode reads a single framed payload from r.
+	Decode(r *bufio.Reader) (Payload, error)
+}
+
+// maxLineSize is the longest JSON line which will be accepted, which is the same as the largest binary frame.
+const maxLineSize = maxFrameSize
+
+// JSONCodec encodes each payload as a newline terminated JSON object.
+type JSONCodec struct{}
+
+// envelope is the JSON wire representation of a message.
+type envelope struct {
+	Type Type            `json:"t"`
+	Data json.RawMessage `json:"d,omitempty"`
+}
+
+// Encode marshals a payload into a JSON line and writes it to w.
+func (JSONCodec) Encode(w io.Writer, p Payload) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s payload: %s", p.Type(), err)
+	}
+	raw, err := json.Marshal(envelope{Type: p.Type(), Data: data})
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s message: %s", p.Type(), err)
+	}
+	// terminate with newline so the receiver can determine the end of the message
+	raw = append(raw, '\n')
+	_, err = w.Write(raw)
+	return err
+}
+
+// Decode reads a JSON line from r and unmarshals it into its typed payload.
+func (JSONCodec) Decode(r *bufio.Reader) (Payload, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var env envelope
+	if err := json.Unmarshal(line, &env); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidMessage, err)
+	}
+	p, err := unmarshalPayload(env.Type, env.Data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidMessage, err)
+	}
+	return p, nil
+}
+
+// readLine reads a newline terminated line from r. Lines longer than maxLineSize are rejected without being buffered,
+// and the stream can't be resynchronised afterwards.
+func readLine(r *bufio.Reader) ([]byte, error) {
+	var line []byte
+	for {
+		fragment, err := r.ReadSlice('\n')
+		if len(line)+len(fragment) > maxLineSize {
+			return nil, fmt.Errorf("JSON line exceeds %d bytes", maxLineSize)
+		}
+		line = append(line, fragment...)
+		if err != bufio.ErrBufferFull {
+			return line, err
+		}
+	}
+}
+
+// unmarshalPayload unmarshals JSON data into the payload corresponding with the provided message type.
+func unmarshalPayload(t Type, data json.RawMessage) (Payload, error) {
+	var (
+		p   Payload
+		err error
+	)
+	switch t {
+	case TypeConnect:
+		var v Connect
+		err = unmarshalData(data, &v)
+		p = v
+	case TypeRegisterSuccess:
+		var v RegisterSuccess
+		err = unmarshalData(data, &v)
+		p = v
+	case TypeRegisterFailure:
+		var v RegisterFailure
+		err = unmarshalData(data, &v)
+		p = v
+	case TypeConnectSuccess:
+		var v ConnectSuccess
+		err = unmarshalData(data, &v)
+		p = v
+	case TypeConnectFailure:
+		var v ConnectFailure
+		err = unmarshalData(data, &v)
+		p = v
+	case TypeUserJoined:
+		var v UserJoined
+		err = unmarshalData(data, &v)
+		p = v
+	case TypePlayerEntered:
+		var v PlayerEntered
+		err = unmarshalData(data, &v)
+		p = v
+	case TypePlayerLeft:
+		var v PlayerLeft
+		err = unmarshalData(data, &v)
+		p = v
+	case TypeVitals:
+		var v Vitals
+		err = unmarshalData(data, &v)
+		p = v
+	case TypeSnapshot:
+		var v Snapshot
+		err = unmarshalData(data, &v)
+		p = v
+	case TypeSnapshotAck:
+		var v SnapshotAck
+		err = unmarshalData(data, &v)
+		p = v
+	case TypeInput:
+		var v Input
+		err = unmarshalData(data, &v)
+		p = v
+	case TypeProjectile:
+		var v Projectile
+		err = unmarshalData(data, &v)
+		p = v
+	case TypeReload:
+		var v Reload
+		err = unmarshalData(data, &v)
+		p = v
+	case TypePlayerHit:
+		var v PlayerHit
+		err = unmarshalData(data, &v)
+		p = v
+	case TypePlayerDied:
+		var v PlayerDied
+		err = unmarshalData(data, &v)
+		p = v
+	case TypePlayerRespawned:
+		var v PlayerRespawned
+		err = unmarshalData(data, &v)
+		p = v
+	case TypeDisconnect:
+		var v Disconnect
+		err = unmarshalData(data, &v)
+		p = v
+	case TypeServerShutdown:
+		p = ServerShutdown{}
+	case TypeKick:
+		var v Kick
+		err = unmarshalData(data, &v)
+		p = v
+	case TypePing:
+		var v Ping
+		err = unmarshalData(data, &v)
+		p = v
+	case TypePong:
+		var v Pong
+		err = unmarshalData(data, &v)
+		p = v
+	case TypeChat:
+		var v Chat
+		err = unmarshalData(data, &v)
+		p = v
+	default:
+		return nil, fmt.Errorf("unsupported message type: %s", t)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal %s payload: %s", t, err)
+	}
+	return p, nil
+}
+
+// unmarshalData unmarshals a payload's JSON data, treating absent data as an empty payload.
+func unmarshalData(data json.RawMessage, v interface{}) error {
+	if len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, v)
+}
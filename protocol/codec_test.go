@@ -0,0 +1,266 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestCodecRoundTrip(t *testing.T) {
+	payloads := []Payload{
+		Connect{Username: "jemgunay", Version: Version, Codec: BinaryCodecName},
+		Vitals{Name: "jemgunay", X: 4021.37, Y: 120.5, Rot: -1.5707, Health: 100, Seq: 7},
+		// small movement is delta-encoded by the binary codec
+		Vitals{Name: "jemgunay", X: 4023.88, Y: 118.02, Rot: 0.25, Health: 90},
+		// large movement falls back to an absolute position
+		Vitals{Name: "jemgunay", X: 12.5, Y: 7999.99, Rot: 3.1415, Health: 90},
+		Snapshot{Tick: 3600, Players: []Vitals{
+			{Name: "jemgunay", X: 13.5, Y: 7998.5, Rot: 1, Health: 80, Seq: 8},
+			{Name: "willyG", X: 500.25, Y: 250.75, Rot: -2, Health: 100, Seq: 120},
+		}},
+		Snapshot{
+			Tick:               3602,
+			Baseline:           3600,
+			Players:            []Vitals{{Name: "jemgunay", X: 14, Y: 7998.5, Rot: 1, Health: 80, Seq: 9}},
+			RemovedPlayers:     []string{"willyG"},
+			Projectiles:        []Projectile{{ID: 12, Owner: "jemgunay", Weapon: "Deagle", SpawnTime: 1557000000123456789, StartX: 14, StartY: 7998.5, VelX: -50, TTL: 5 * time.Second}},
+			RemovedProjectiles: []uint32{3, 11},
+		},
+		SnapshotAck{Tick: 3602},
+		Input{Seq: 42, MoveX: -1, MoveY: 1, Aim: 2.5, Fire: true, Duration: 8333 * time.Microsecond},
+		Projectile{Owner: "jemgunay", Weapon: "M4A1", SpawnTime: 1557000000123456789, StartX: 10.5, StartY: -20.25, VelX: 100, VelY: -0.5, TTL: 5 * time.Second},
+		PlayerEntered{Player: Vitals{Name: "willyG", X: 1, Y: 2, Rot: 3, Health: 4}},
+		PlayerLeft{Name: "willyG"},
+		RegisterFailure{Reason: `name contains "quotes" | pipes / slashes`},
+		Reload{Weapon: "Deagle", Time: 1557000000987654321},
+		Kick{Reason: "too many invalid projectiles"},
+		Ping{Time: 1557000000987654321},
+		Pong{Time: 1557000000987654321},
+		Chat{Channel: ChatWhisper, From: "jemgunay", To: "willyG", Text: `gg "well" played | /w`},
+		ServerShutdown{},
+	}
+
+	codecs := map[string]func() Codec{
+		JSONCodecName:   func() Codec { return JSONCodec{} },
+		BinaryCodecName: func() Codec { return NewBinaryCodec() },
+	}
+
+	for name, newCodec := range codecs {
+		t.Run(name, func(t *testing.T) {
+			enc, dec := newCodec(), newCodec()
+			buf := &bytes.Buffer{}
+			for _, p := range payloads {
+				if err := enc.Encode(buf, p); err != nil {
+					t.Fatalf("failed to encode %s: %s", p.Type(), err)
+				}
+			}
+
+			r := bufio.NewReader(buf)
+			for _, want := range payloads {
+				got, err := dec.Decode(r)
+				if err != nil {
+					t.Fatalf("failed to decode %s: %s", want.Type(), err)
+				}
+				if !approxEqual(got, want) {
+					t.Fatalf("decoded payload mismatch:\ngot:  %+v\nwant: %+v", got, want)
+				}
+			}
+		})
+	}
+}
+
+// TestBinaryAngles checks that the binary codec preserves angles outside [-π, π] as the equivalent angle within it,
+// rather than corrupting them.
+func TestBinaryAngles(t *testing.T) {
+	for _, angle := range []float64{0, math.Pi, -math.Pi, 3.3, -3.3, 5, -7.5, 100} {
+		enc, dec := NewBinaryCodec(), NewBinaryCodec()
+		buf := &bytes.Buffer{}
+		for _, p := range []Payload{Vitals{Name: "jemgunay", Rot: angle}, Input{Aim: angle}} {
+			if err := enc.Encode(buf, p); err != nil {
+				t.Fatalf("failed to encode %s: %s", p.Type(), err)
+			}
+		}
+
+		r := bufio.NewReader(buf)
+		for _, field := range []string{"rotation", "aim"} {
+			p, err := dec.Decode(r)
+			if err != nil {
+				t.Fatalf("failed to decode %s: %s", field, err)
+			}
+			var got float64
+			switch p := p.(type) {
+			case Vitals:
+				got = p.Rot
+			case Input:
+				got = p.Aim
+			}
+			if math.Abs(math.Remainder(got-angle, 2*math.Pi)) > 1e-4 || math.Abs(got) > math.Pi+1e-4 {
+				t.Errorf("%s %f: decoded %f, want the equivalent angle in [-π, π]", field, angle, got)
+			}
+		}
+	}
+}
+
+// TestOversizedMessages checks that messages larger than the codecs accept are rejected rather than buffered, and that
+// the error isn't mistaken for an invalid message which the stream can recover from.
+func TestOversizedMessages(t *testing.T) {
+	line := append(bytes.Repeat([]byte{'a'}, maxLineSize), '\n')
+	if _, err := (JSONCodec{}).Decode(bufio.NewReader(bytes.NewReader(line))); err == nil ||
+		errors.Is(err, ErrInvalidMessage) {
+		t.Errorf("expected oversized JSON line to be rejected, got %v", err)
+	}
+
+	var frame [binary.MaxVarintLen32]byte
+	n := binary.PutUvarint(frame[:], maxFrameSize+1)
+	if _, err := NewBinaryCodec().Decode(bufio.NewReader(bytes.NewReader(frame[:n]))); err == nil ||
+		errors.Is(err, ErrInvalidMessage) {
+		t.Errorf("expected oversized binary frame to be rejected, got %v", err)
+	}
+
+	// lines within the limit are still accepted after a partial read fills the reader's buffer
+	var buf bytes.Buffer
+	chat := Chat{Channel: ChatGlobal, Text: string(bytes.Repeat([]byte{'a'}, 8192))}
+	if err := (JSONCodec{}).Encode(&buf, chat); err != nil {
+		t.Fatalf("failed to encode chat: %s", err)
+	}
+	if got, err := (JSONCodec{}).Decode(bufio.NewReaderSize(&buf, 16)); err != nil || got != chat {
+		t.Errorf("failed to decode long line: got %+v, %v", got, err)
+	}
+}
+
+// approxEqual compares payloads, allowing for the binary codec's fixed-point and float32 precision.
+func approxEqual(got, want Payload) bool {
+	near := func(a, b, tolerance float64) bool {
+		return math.Abs(a-b) <= tolerance
+	}
+
+	switch w := want.(type) {
+	case Vitals:
+		g, ok := got.(Vitals)
+		return ok && g.Name == w.Name && g.Health == w.Health && g.Seq == w.Seq &&
+			near(g.X, w.X, 0.005) && near(g.Y, w.Y, 0.005) && near(g.Rot, w.Rot, 0.00005)
+	case Snapshot:
+		g, ok := got.(Snapshot)
+		if !ok || g.Tick != w.Tick || g.Baseline != w.Baseline || len(g.Players) != len(w.Players) ||
+			len(g.Projectiles) != len(w.Projectiles) ||
+			fmt.Sprint(g.RemovedPlayers, g.RemovedProjectiles) != fmt.Sprint(w.RemovedPlayers, w.RemovedProjectiles) {
+			return false
+		}
+		for i := range w.Players {
+			if !approxEqual(g.Players[i], w.Players[i]) {
+				return false
+			}
+		}
+		for i := range w.Projectiles {
+			if !approxEqual(g.Projectiles[i], w.Projectiles[i]) {
+				return false
+			}
+		}
+		return true
+	case Input:
+		g, ok := got.(Input)
+		return ok && g.Seq == w.Seq && g.MoveX == w.MoveX && g.MoveY == w.MoveY && g.Fire == w.Fire &&
+			g.Duration == w.Duration && near(g.Aim, w.Aim, 0.00005)
+	case Projectile:
+		g, ok := got.(Projectile)
+		return ok && g.ID == w.ID && g.Owner == w.Owner && g.Weapon == w.Weapon && g.SpawnTime == w.SpawnTime && g.TTL == w.TTL &&
+			near(g.StartX, w.StartX, 0.01) && near(g.StartY, w.StartY, 0.01) &&
+			near(g.VelX, w.VelX, 0.01) && near(g.VelY, w.VelY, 0.01)
+	}
+	return fmt.Sprintf("%+v", got) == fmt.Sprintf("%+v", want)
+}
+
+// tickPlayers is the number of players whose vitals are broadcast per benchmarked server tick.
+const tickPlayers = 16
+
+// tick produces the vitals of every player for the nth tick, with each player moving a few pixels per tick.
+func tick(n int) []Payload {
+	payloads := make([]Payload, 0, tickPlayers+1)
+	for i := 0; i < tickPlayers; i++ {
+		payloads = append(payloads, Vitals{
+			Name:   fmt.Sprintf("player%d", i),
+			X:      1000 + float64(i*100) + float64(n)*2.5,
+			Y:      2000 - float64(i*100) + float64(n)*1.25,
+			Rot:    float64(n%628) / 100,
+			Health: 100,
+		})
+	}
+	return append(payloads, Projectile{
+		Owner:     "player0",
+		Weapon:    "M4A1",
+		SpawnTime: time.Now().UnixNano(),
+		StartX:    1000,
+		StartY:    2000,
+		VelX:      70.7,
+		VelY:      70.7,
+		TTL:       5 * time.Second,
+	})
+}
+
+func benchmarkEncode(b *testing.B, codec Codec) {
+	ticks := [][]Payload{tick(0), tick(1)}
+	var w bytes.Buffer
+	var bytesWritten int
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		w.Reset()
+		for _, p := range ticks[i%2] {
+			if err := codec.Encode(&w, p); err != nil {
+				b.Fatal(err)
+			}
+		}
+		bytesWritten += w.Len()
+	}
+	b.ReportMetric(float64(bytesWritten)/float64(b.N), "wire-B/tick")
+}
+
+func benchmarkDecode(b *testing.B, newCodec func() Codec) {
+	// encode a long run of ticks so that the binary codec's delta-encoding is exercised
+	const tickCount = 64
+	enc := newCodec()
+	var raw bytes.Buffer
+	for n := 0; n < tickCount; n++ {
+		for _, p := range tick(n) {
+			if err := enc.Encode(&raw, p); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	data := raw.Bytes()
+	msgsPerTick := tickPlayers + 1
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i += tickCount {
+		r := bufio.NewReader(bytes.NewReader(data))
+		dec := newCodec()
+		for j := 0; j < tickCount*msgsPerTick; j++ {
+			if _, err := dec.Decode(r); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkJSONCodecEncodeTick(b *testing.B) {
+	benchmarkEncode(b, JSONCodec{})
+}
+
+func BenchmarkBinaryCodecEncodeTick(b *testing.B) {
+	benchmarkEncode(b, NewBinaryCodec())
+}
+
+func BenchmarkJSONCodecDecodeTick(b *testing.B) {
+	benchmarkDecode(b, func() Codec { return JSONCodec{} })
+}
+
+func BenchmarkBinaryCodecDecodeTick(b *testing.B) {
+	benchmarkDecode(b, func() Codec { return NewBinaryCodec() })
+}
@@ -0,0 +1,195 @@
+package protocol
+
+import (
+	"bufio"
+	"crypto/tls"
+	"net"
+	"sync"
+	"time"
+)
+
+// Transport creates connections and listeners over a particular kind of network, allowing clients and servers to be
+// run over any of them.
+type Transport interface {
+	// Dial connects to the listener at addr.
+	Dial(addr string) (Conn, error)
+	// Listen listens for connections on addr.
+	Listen(addr string) (Listener, error)
+}
+
+// Conn is a connection which payloads are sent and received over. Every connection starts out using the JSONCodec
+// for the handshake, after which the codec agreed during the handshake can be switched to.
+type Conn interface {
+	// Send encodes and writes a payload to the connection.
+	Send(p Payload) error
+	// SendAndSwitch encodes and writes a payload to the connection using the current codec, then switches to the
+	// provided codec for all subsequent reads and writes. No other writes can occur between the two.
+	SendAndSwitch(p Payload, codec Codec) error
+	// Switch switches to the provided codec for all subsequent reads and writes.
+	Switch(codec Codec)
+	// Receive reads and decodes the next payload from the connection. It must only be called from a single goroutine.
+	Receive() (Payload, error)
+	// RemoteAddr returns the address of the remote end of the connection.
+	RemoteAddr() net.Addr
+	// Close closes the connection.
+	Close() error
+}
+
+// Listener accepts incoming connections.
+type Listener interface {
+	// Accept waits for and returns the next connection.
+	Accept() (Conn, error)
+	// Close stops listening.
+	Close() error
+	// Addr returns the address being listened on.
+	Addr() net.Addr
+}
+
+// StreamConn frames payloads onto a stream oriented network connection such as TCP, over which every payload is
+// delivered reliably and in order.
+type StreamConn struct {
+	net.Conn
+	r *bufio.Reader
+	// read counts the bytes read into r, and w counts the bytes written to the connection
+	read *countingReader
+	w    *countingWriter
+
+	// writeTimeout is how long each write can block for
+	writeTimeout time.Duration
+
+	// mu serialises writes so that frames are never interleaved
+	mu    sync.Mutex
+	codec Codec
+	meter Meter
+}
+
+// NewStreamConn wraps a stream oriented network connection in a StreamConn using the JSONCodec.
+func NewStreamConn(conn net.Conn) *StreamConn {
+	read := &countingReader{r: conn}
+	return &StreamConn{
+		Conn:         conn,
+		r:            bufio.NewReader(read),
+		read:         read,
+		w:            &countingWriter{w: conn},
+		writeTimeout: WriteTimeout,
+		codec:        JSONCodec{},
+	}
+}
+
+// SetMeter sets the meter which subsequent messages are reported to.
+func (c *StreamConn) SetMeter(m Meter) {
+	c.mu.Lock()
+	c.meter = m
+	c.mu.Unlock()
+}
+
+// Send encodes and writes a payload to the connection.
+func (c *StreamConn) Send(p Payload) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.send(p)
+}
+
+// SendAndSwitch encodes and writes a payload to the connection using the current codec, then switches to the
+// provided codec for all subsequent reads and writes. No other writes can occur between the two.
+func (c *StreamConn) SendAndSwitch(p Payload, codec Codec) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.send(p); err != nil {
+		return err
+	}
+	c.codec = codec
+	return nil
+}
+
+// send encodes and writes a payload to the connection, reporting it to the meter if it was written. The write fails if
+// it blocks for longer than WriteTimeout. c.mu must be locked.
+func (c *StreamConn) send(p Payload) error {
+	if err := c.Conn.SetWriteDeadline(time.Now().Add(c.writeTimeout)); err != nil {
+		return err
+	}
+	c.w.n = 0
+	if err := c.codec.Encode(c.w, p); err != nil {
+		return err
+	}
+	if c.meter != nil {
+		c.meter.MessageSent(p.Type(), c.w.n)
+	}
+	return nil
+}
+
+// Switch switches to the provided codec for all subsequent reads and writes.
+func (c *StreamConn) Switch(codec Codec) {
+	c.mu.Lock()
+	c.codec = codec
+	c.mu.Unlock()
+}
+
+// Receive reads and decodes the next payload from the connection. It must only be called from a single goroutine.
+func (c *StreamConn) Receive() (Payload, error) {
+	c.mu.Lock()
+	codec, meter := c.codec, c.meter
+	c.mu.Unlock()
+
+	// the size of the message is the number of bytes consumed from the stream, i.e. read but no longer buffered
+	start := c.read.n - c.r.Buffered()
+	p, err := codec.Decode(c.r)
+	if err == nil && meter != nil {
+		meter.MessageReceived(p.Type(), c.read.n-c.r.Buffered()-start)
+	}
+	return p, err
+}
+
+// streamListener accepts stream oriented network connections as StreamConns.
+type streamListener struct {
+	net.Listener
+}
+
+// NewStreamListener wraps a stream oriented network listener such as a TCP listener, so that accepted connections are
+// wrapped in StreamConns.
+func NewStreamListener(l net.Listener) Listener {
+	return streamListener{Listener: l}
+}
+
+// Accept waits for and returns the next connection.
+func (l streamListener) Accept() (Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return NewStreamConn(conn), nil
+}
+
+// TCPTransport is a Transport over TCP, which is encrypted with TLS if TLSConfig is set.
+type TCPTransport struct {
+	TLSConfig *tls.Config
+}
+
+// Dial connects to the listener at addr.
+func (t TCPTransport) Dial(addr string) (Conn, error) {
+	var (
+		conn net.Conn
+		err  error
+	)
+	if t.TLSConfig != nil {
+		conn, err = tls.Dial("tcp", addr, t.TLSConfig)
+	} else {
+		conn, err = net.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewStreamConn(conn), nil
+}
+
+// Listen listens for connections on addr.
+func (t TCPTransport) Listen(addr string) (Listener, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if t.TLSConfig != nil {
+		l = tls.NewListener(l, t.TLSConfig)
+	}
+	return NewStreamListener(l), nil
+}
@@ -0,0 +1,32 @@
+package protocol
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestWriteTimeout checks that sending to a peer which has stopped reading fails once the write timeout passes, rather
+// than blocking forever.
+func TestWriteTimeout(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	conn := NewStreamConn(a)
+	defer conn.Close()
+	conn.writeTimeout = time.Millisecond * 50
+
+	sent := make(chan error, 1)
+	go func() {
+		sent <- conn.Send(Chat{Text: "hello"})
+	}()
+	select {
+	case err := <-sent:
+		if !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Fatalf("expected send to time out, got %v", err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("send blocked past the write timeout")
+	}
+}
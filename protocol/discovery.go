@@ -0,0 +1,80 @@
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const (
+	// DiscoveryPort is the UDP port servers listen on for discovery queries broadcast by clients on the LAN.
+	DiscoveryPort = 9003
+	// maxDiscoverySize is the largest discovery datagram which is sent or accepted.
+	maxDiscoverySize = 1024
+	// discoveryMagic identifies discovery datagrams, so that unrelated traffic on the discovery port is ignored.
+	discoveryMagic = "procedural-game"
+)
+
+// ServerInfo describes a server to the clients browsing the LAN for games to join.
+type ServerInfo struct {
+	Name string `json:"name"`
+	// Port is the port the server accepts game connections on, at the address the server replied from.
+	Port int `json:"port"`
+	// TLS is set if connections must be encrypted, and UDP is set if connections can also be made over UDP.
+	TLS bool `json:"tls"`
+	UDP bool `json:"udp"`
+	// Players is the number of users connected.
+	Players int    `json:"players"`
+	Seed    string `json:"seed"`
+	// Version is the newest protocol version supported by the server.
+	Version uint `json:"version"`
+}
+
+// Discovery is a discovery datagram. Clients broadcast queries to find the servers on the LAN, which reply with a
+// description of themselves.
+type Discovery struct {
+	// Time is the client's Unix time in nanoseconds when the query was sent, which a server echoes in its reply so
+	// that the client can measure the round trip time to it.
+	Time int64 `json:"time"`
+	// Server describes the server replying to a query, or is nil if the datagram is a query.
+	Server *ServerInfo `json:"server,omitempty"`
+}
+
+// discoveryDatagram is the wire format of a Discovery.
+type discoveryDatagram struct {
+	Magic string `json:"magic"`
+	Discovery
+}
+
+// Sent returns the time the query was sent.
+func (d Discovery) Sent() time.Time {
+	return time.Unix(0, d.Time)
+}
+
+// Encode encodes the discovery datagram.
+func (d Discovery) Encode() ([]byte, error) {
+	b, err := json.Marshal(discoveryDatagram{Magic: discoveryMagic, Discovery: d})
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > maxDiscoverySize {
+		return nil, fmt.Errorf("discovery datagram of %d bytes exceeds the maximum of %d", len(b), maxDiscoverySize)
+	}
+	return b, nil
+}
+
+// DecodeDiscovery decodes a discovery datagram. An error wrapping ErrInvalidMessage is returned if it isn't one.
+func DecodeDiscovery(b []byte) (Discovery, error) {
+	if len(b) > maxDiscoverySize {
+		return Discovery{}, fmt.Errorf("%w: discovery datagram of %d bytes exceeds the maximum of %d",
+			ErrInvalidMessage, len(b), maxDiscoverySize)
+	}
+	var d discoveryDatagram
+	if err := json.Unmarshal(b, &d); err != nil {
+		return Discovery{}, fmt.Errorf("%w: %s", ErrInvalidMessage, err)
+	}
+	if d.Magic != discoveryMagic {
+		return Discovery{}, fmt.Errorf("%w: not a discovery datagram", ErrInvalidMessage)
+	}
+	return d.Discovery, nil
+}
@@ -0,0 +1,31 @@
+package protocol
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestDiscovery checks that discovery datagrams survive encoding, and that unrelated datagrams are rejected.
+func TestDiscovery(t *testing.T) {
+	info := ServerInfo{Name: "test server", Port: 9000, UDP: true, Players: 2, Seed: "test-seed", Version: Version}
+	b, err := Discovery{Time: 42, Server: &info}.Encode()
+	if err != nil {
+		t.Fatalf("failed to encode: %s", err)
+	}
+	d, err := DecodeDiscovery(b)
+	if err != nil {
+		t.Fatalf("failed to decode: %s", err)
+	}
+	if d.Time != 42 || d.Server == nil || *d.Server != info {
+		t.Errorf("got %+v, want %+v", d.Server, info)
+	}
+
+	for _, datagram := range []string{`{"time":42}`, `{"magic":"other","time":42}`, `not json`} {
+		if _, err := DecodeDiscovery([]byte(datagram)); !errors.Is(err, ErrInvalidMessage) {
+			t.Errorf("expected %s to be rejected, got %v", datagram, err)
+		}
+	}
+	if _, err := (Discovery{Server: &ServerInfo{Name: string(make([]byte, maxDiscoverySize))}}).Encode(); err == nil {
+		t.Error("expected an oversized datagram to be rejected")
+	}
+}
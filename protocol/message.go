@@ -0,0 +1,339 @@
+package protocol
+
+import "time"
+
+// Type identifies the kind of payload carried by a message.
+type Type string
+
+// Message type constants.
+const (
+	TypeConnect         Type = "connect"
+	TypeRegisterSuccess Type = "register_success"
+	TypeRegisterFailure Type = "register_failure"
+	TypeConnectSuccess  Type = "connect_success"
+	TypeConnectFailure  Type = "connect_failure"
+	TypeUserJoined      Type = "user_joined"
+	TypePlayerEntered   Type = "player_entered"
+	TypePlayerLeft      Type = "player_left"
+	TypeVitals          Type = "vitals"
+	TypeSnapshot        Type = "snapshot"
+	TypeSnapshotAck     Type = "snapshot_ack"
+	TypeInput           Type = "input"
+	TypeProjectile      Type = "create_projectile"
+	TypeReload          Type = "reload"
+	TypePlayerHit       Type = "player_hit"
+	TypePlayerDied      Type = "player_died"
+	TypePlayerRespawned Type = "player_respawned"
+	TypeDisconnect      Type = "disconnect"
+	TypeServerShutdown  Type = "server_shutdown"
+	TypeKick            Type = "kick"
+	TypePing            Type = "ping"
+	TypePong            Type = "pong"
+	TypeChat            Type = "chat"
+)
+
+// Payload is the typed body of a message sent between a client and a server.
+type Payload interface {
+	Type() Type
+}
+
+// Connect is sent by a client to register a new user or to log in as an existing user.
+type Connect struct {
+	Username string `json:"name"`
+	// Password is required to register, and to connect unless a session token is provided.
+	Password string `json:"pass,omitempty"`
+	// Token is a session token issued by the server on a previous connect, which is used instead of the password.
+	Token string `json:"token,omitempty"`
+	// Version is the newest protocol version supported by the client.
+	Version uint `json:"ver"`
+	// Codec is the name of the codec the client would like to switch to once the handshake succeeds.
+	Codec string `json:"codec,omitempty"`
+}
+
+// Type returns the Connect message type.
+func (Connect) Type() Type { return TypeConnect }
+
+// Welcome is the body of a successful register or connect response.
+type Welcome struct {
+	// Version is the protocol version negotiated for the connection.
+	Version uint `json:"ver"`
+	// Codec is the name of the codec both ends switch to after this message.
+	Codec string `json:"codec,omitempty"`
+	// Token is a session token which can be used to reconnect without the password.
+	Token string `json:"token,omitempty"`
+	// Resumed is set if the user reconnected with a session token soon enough after losing their connection for their
+	// previous session to be resumed, in which case their armoury is as they left it.
+	Resumed bool   `json:"resumed,omitempty"`
+	Seed    string `json:"seed"`
+	Player  Vitals `json:"player"`
+}
+
+// RegisterSuccess is sent by the server when a new user has been created.
+type RegisterSuccess struct {
+	Welcome
+}
+
+// Type returns the RegisterSuccess message type.
+func (RegisterSuccess) Type() Type { return TypeRegisterSuccess }
+
+// ConnectSuccess is sent by the server when an existing user has been logged in.
+type ConnectSuccess struct {
+	Welcome
+}
+
+// Type returns the ConnectSuccess message type.
+func (ConnectSuccess) Type() Type { return TypeConnectSuccess }
+
+// RegisterFailure is sent by the server when a new user could not be created.
+type RegisterFailure struct {
+	Reason string `json:"reason"`
+}
+
+// Type returns the RegisterFailure message type.
+func (RegisterFailure) Type() Type { return TypeRegisterFailure }
+
+// ConnectFailure is sent by the server when an existing user could not be logged in.
+type ConnectFailure struct {
+	Reason string `json:"reason"`
+}
+
+// Type returns the ConnectFailure message type.
+func (ConnectFailure) Type() Type { return TypeConnectFailure }
+
+// UserJoined is broadcast by the server when a user joins the game. The user's player is only described to those
+// they are near, by PlayerEntered.
+type UserJoined struct {
+	Name string `json:"name"`
+}
+
+// Type returns the UserJoined message type.
+func (UserJoined) Type() Type { return TypeUserJoined }
+
+// PlayerEntered is sent by the server when another player enters the area of interest around the recipient's player,
+// after which they are described by snapshots sent to the recipient.
+type PlayerEntered struct {
+	Player Vitals `json:"player"`
+}
+
+// Type returns the PlayerEntered message type.
+func (PlayerEntered) Type() Type { return TypePlayerEntered }
+
+// PlayerLeft is sent by the server when another player leaves the area of interest around the recipient's player,
+// after which they are no longer described by snapshots sent to the recipient.
+type PlayerLeft struct {
+	Name string `json:"name"`
+}
+
+// Type returns the PlayerLeft message type.
+func (PlayerLeft) Type() Type { return TypePlayerLeft }
+
+// Vitals is the authoritative core player data such as position, orientation and health, as simulated by the server.
+type Vitals struct {
+	Name   string  `json:"name"`
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Rot    float64 `json:"rot"`
+	Health uint64  `json:"health"`
+	// Seq is the sequence number of the last input command from the player which the server has processed.
+	Seq uint32 `json:"seq"`
+}
+
+// Type returns the Vitals message type.
+func (Vitals) Type() Type { return TypeVitals }
+
+// Snapshot is sent by the server after every simulation step and describes the state of the world within the area of
+// interest around the recipient's player: the vitals of every connected player and every live projectile within it.
+// Snapshots are numbered by the step which produced them. Unless Baseline is 0, the snapshot only describes the
+// changes since the Baseline snapshot, which the client has acknowledged receiving.
+type Snapshot struct {
+	Tick     uint32 `json:"tick"`
+	Baseline uint32 `json:"base,omitempty"`
+	// Players are the vitals of the players which have joined or changed since the baseline.
+	Players        []Vitals `json:"players,omitempty"`
+	RemovedPlayers []string `json:"removedPlayers,omitempty"`
+	// Projectiles are the projectiles fired since the baseline.
+	Projectiles        []Projectile `json:"projectiles,omitempty"`
+	RemovedProjectiles []uint32     `json:"removedProjectiles,omitempty"`
+}
+
+// Type returns the Snapshot message type.
+func (Snapshot) Type() Type { return TypeSnapshot }
+
+// SnapshotAck is sent by a client to acknowledge receiving a snapshot, allowing subsequent snapshots to be
+// delta-encoded against it.
+type SnapshotAck struct {
+	Tick uint32 `json:"tick"`
+}
+
+// Type returns the SnapshotAck message type.
+func (SnapshotAck) Type() Type { return TypeSnapshotAck }
+
+// Input is a sequenced player input command sent by a client for the server to simulate.
+type Input struct {
+	Seq uint32 `json:"seq"`
+	// MoveX and MoveY are the movement direction along each axis: -1, 0 or 1.
+	MoveX int8 `json:"mx"`
+	MoveY int8 `json:"my"`
+	// Aim is the orientation the player is aiming at in radians.
+	Aim  float64 `json:"aim"`
+	Fire bool    `json:"fire"`
+	// Duration is the period of time the movement is applied for.
+	Duration time.Duration `json:"dur"`
+}
+
+// Type returns the Input message type.
+func (Input) Type() Type { return TypeInput }
+
+// Projectile describes a projectile fired by a player. ID and Owner are assigned by the server, and are ignored when
+// sent by a client.
+type Projectile struct {
+	ID    uint32 `json:"id,omitempty"`
+	Owner string `json:"owner,omitempty"`
+	// Weapon is the name of the weapon which fired the projectile.
+	Weapon    string        `json:"weapon"`
+	SpawnTime int64         `json:"spawn"`
+	StartX    float64       `json:"startX"`
+	StartY    float64       `json:"startY"`
+	VelX      float64       `json:"velX"`
+	VelY      float64       `json:"velY"`
+	TTL       time.Duration `json:"ttl"`
+}
+
+// Type returns the Projectile message type.
+func (Projectile) Type() Type { return TypeProjectile }
+
+// Spawned returns the time at which the projectile was fired.
+func (p Projectile) Spawned() time.Time {
+	return time.Unix(0, p.SpawnTime).UTC()
+}
+
+// Reload is sent by a client when it starts reloading a weapon.
+type Reload struct {
+	Weapon string `json:"weapon"`
+	// Time is the client's Unix time in nanoseconds when reloading started.
+	Time int64 `json:"time"`
+}
+
+// Type returns the Reload message type.
+func (Reload) Type() Type { return TypeReload }
+
+// Started returns the time at which reloading started.
+func (r Reload) Started() time.Time {
+	return time.Unix(0, r.Time).UTC()
+}
+
+// PlayerHit is sent by the server to the players interested in either player when a player is damaged by another
+// player's projectile.
+type PlayerHit struct {
+	Name     string `json:"name"`
+	Attacker string `json:"attacker"`
+	Weapon   string `json:"weapon"`
+	Damage   uint64 `json:"damage"`
+	// Health is the player's remaining health.
+	Health uint64 `json:"health"`
+}
+
+// Type returns the PlayerHit message type.
+func (PlayerHit) Type() Type { return TypePlayerHit }
+
+// PlayerDied is sent by the server to the players interested in either player when a player's health reaches zero.
+type PlayerDied struct {
+	Name   string `json:"name"`
+	Killer string `json:"killer"`
+	// RespawnDelay is how long until the player respawns.
+	RespawnDelay time.Duration `json:"respawnDelay"`
+}
+
+// Type returns the PlayerDied message type.
+func (PlayerDied) Type() Type { return TypePlayerDied }
+
+// PlayerRespawned is sent by the server to the players interested in a dead player when they respawn.
+type PlayerRespawned struct {
+	Player Vitals `json:"player"`
+}
+
+// Type returns the PlayerRespawned message type.
+func (PlayerRespawned) Type() Type { return TypePlayerRespawned }
+
+// Disconnect is sent by a client when leaving the game, and is broadcast by the server with the leaving user's name.
+type Disconnect struct {
+	Name string `json:"name,omitempty"`
+}
+
+// Type returns the Disconnect message type.
+func (Disconnect) Type() Type { return TypeDisconnect }
+
+// ServerShutdown is broadcast by the server when it is shutting down.
+type ServerShutdown struct{}
+
+// Type returns the ServerShutdown message type.
+func (ServerShutdown) Type() Type { return TypeServerShutdown }
+
+// Kick is sent by the server to a client before it is forcibly disconnected.
+type Kick struct {
+	Reason string `json:"reason"`
+}
+
+// Type returns the Kick message type.
+func (Kick) Type() Type { return TypeKick }
+
+// Ping is sent periodically by both ends of a connection once the handshake has succeeded, to keep the connection
+// alive and to measure the round trip time. The receiver replies with a Pong.
+type Ping struct {
+	// Time is the sender's Unix time in nanoseconds when the ping was sent.
+	Time int64 `json:"time"`
+}
+
+// Type returns the Ping message type.
+func (Ping) Type() Type { return TypePing }
+
+// Sent returns the time at which the ping was sent.
+func (p Ping) Sent() time.Time {
+	return time.Unix(0, p.Time).UTC()
+}
+
+// Pong is sent in reply to a Ping.
+type Pong struct {
+	// Time is the time of the ping being replied to.
+	Time int64 `json:"time"`
+}
+
+// Type returns the Pong message type.
+func (Pong) Type() Type { return TypePong }
+
+// Sent returns the time at which the ping being replied to was sent.
+func (p Pong) Sent() time.Time {
+	return time.Unix(0, p.Time).UTC()
+}
+
+// ChatChannel determines which users a chat message is routed to.
+type ChatChannel string
+
+// Chat channels.
+const (
+	// ChatGlobal messages are sent to every connected user.
+	ChatGlobal ChatChannel = "global"
+	// ChatProximity messages are sent to the users near the sender.
+	ChatProximity ChatChannel = "proximity"
+	// ChatWhisper messages are sent to a single named user.
+	ChatWhisper ChatChannel = "whisper"
+	// ChatSystem messages are sent by the server, such as replies to commands.
+	ChatSystem ChatChannel = "system"
+)
+
+// MaxChatLength is the maximum length of a chat message's text in bytes.
+const MaxChatLength = 200
+
+// Chat is sent by a client to post a message to a chat channel, and is sent by the server to the users the message is
+// routed to. Text starting with a slash is a command for the server rather than a message.
+type Chat struct {
+	Channel ChatChannel `json:"ch"`
+	// From is the name of the sender, which is set by the server.
+	From string `json:"from,omitempty"`
+	// To is the name of the recipient of a whisper.
+	To   string `json:"to,omitempty"`
+	Text string `json:"text"`
+}
+
+// Type returns the Chat message type.
+func (Chat) Type() Type { return TypeChat }
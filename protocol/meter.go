@@ -0,0 +1,43 @@
+package protocol
+
+import "io"
+
+// Meter records the number and size of the messages sent and received over connections. Its methods may be called
+// concurrently from the goroutines reading from and writing to any number of connections.
+type Meter interface {
+	// MessageSent records a message written to a connection, which was size bytes on the wire.
+	MessageSent(t Type, size int)
+	// MessageReceived records a message read from a connection, which was size bytes on the wire.
+	MessageReceived(t Type, size int)
+}
+
+// Metered is implemented by connections which can report the messages sent and received over them to a Meter. All of
+// the connections created by the transports in this package are Metered.
+type Metered interface {
+	// SetMeter sets the meter which subsequent messages are reported to.
+	SetMeter(m Meter)
+}
+
+// countingWriter counts the bytes written to the underlying writer.
+type countingWriter struct {
+	w io.Writer
+	n int
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += n
+	return n, err
+}
+
+// countingReader counts the bytes read from the underlying reader.
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
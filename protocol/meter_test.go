@@ -0,0 +1,87 @@
+package protocol
+
+import (
+	"bytes"
+	"net"
+	"sync"
+	"testing"
+)
+
+// recordMeter records the messages reported to it.
+type recordMeter struct {
+	mu             sync.Mutex
+	sent, received map[Type]int
+}
+
+func newRecordMeter() *recordMeter {
+	return &recordMeter{sent: make(map[Type]int), received: make(map[Type]int)}
+}
+
+func (m *recordMeter) MessageSent(t Type, size int) {
+	m.mu.Lock()
+	m.sent[t] += size
+	m.mu.Unlock()
+}
+
+func (m *recordMeter) MessageReceived(t Type, size int) {
+	m.mu.Lock()
+	m.received[t] += size
+	m.mu.Unlock()
+}
+
+// TestMeter checks that metered connections report the wire size of every message sent and received over them.
+func TestMeter(t *testing.T) {
+	payloads := []Payload{
+		Chat{Channel: ChatGlobal, Text: "hello"},
+		Input{Seq: 1, MoveX: 1},
+		Chat{Channel: ChatGlobal, Text: string(bytes.Repeat([]byte{'a'}, 8192))},
+	}
+	// the expected sizes are those of the payloads encoded by the binary codec, which is switched to after the first
+	want := make(map[Type]int)
+	var buf bytes.Buffer
+	if err := (JSONCodec{}).Encode(&buf, payloads[0]); err != nil {
+		t.Fatalf("failed to encode: %s", err)
+	}
+	want[TypeChat] = buf.Len()
+	codec := NewBinaryCodec()
+	for _, p := range payloads[1:] {
+		buf.Reset()
+		if err := codec.Encode(&buf, p); err != nil {
+			t.Fatalf("failed to encode: %s", err)
+		}
+		want[p.Type()] += buf.Len()
+	}
+
+	a, b := net.Pipe()
+	client, server := NewStreamConn(a), NewStreamConn(b)
+	defer client.Close()
+	defer server.Close()
+	clientMeter, serverMeter := newRecordMeter(), newRecordMeter()
+	client.SetMeter(clientMeter)
+	server.SetMeter(serverMeter)
+
+	sent := make(chan struct{})
+	go func() {
+		defer close(sent)
+		client.SendAndSwitch(payloads[0], NewBinaryCodec())
+		for _, p := range payloads[1:] {
+			client.Send(p)
+		}
+	}()
+	for i := range payloads {
+		if _, err := server.Receive(); err != nil {
+			t.Fatalf("failed to receive: %s", err)
+		}
+		if i == 0 {
+			server.Switch(NewBinaryCodec())
+		}
+	}
+
+	<-sent
+	for typ, size := range want {
+		if clientMeter.sent[typ] != size || serverMeter.received[typ] != size {
+			t.Errorf("%s: sent %d bytes and received %d, want %d", typ, clientMeter.sent[typ],
+				serverMeter.received[typ], size)
+		}
+	}
+}
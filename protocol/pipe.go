@@ -0,0 +1,243 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+// pipeBufferSize is the number of frames which can be buffered in each direction of a pipe before sends block.
+const pipeBufferSize = 1024
+
+// PipeTransport is an in-memory Transport which connects clients and servers within a single process without
+// sockets, e.g. for testing. Addresses are arbitrary names which are only meaningful to the transport they were
+// listened on.
+type PipeTransport struct {
+	mu        sync.Mutex
+	listeners map[string]*pipeListener
+	dials     uint
+}
+
+// NewPipeTransport creates a PipeTransport with no listeners.
+func NewPipeTransport() *PipeTransport {
+	return &PipeTransport{
+		listeners: make(map[string]*pipeListener),
+	}
+}
+
+// Dial connects to the listener at addr.
+func (t *PipeTransport) Dial(addr string) (Conn, error) {
+	t.mu.Lock()
+	l, ok := t.listeners[addr]
+	t.dials++
+	local := pipeAddr(fmt.Sprintf("pipe-%d", t.dials))
+	t.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("no pipe listener on %s", addr)
+	}
+
+	client, server := newPipe(local, l.addr)
+	select {
+	case l.accept <- server:
+		return client, nil
+	case <-l.closed:
+		return nil, fmt.Errorf("no pipe listener on %s", addr)
+	}
+}
+
+// Listen listens for connections on addr.
+func (t *PipeTransport) Listen(addr string) (Listener, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.listeners[addr]; ok {
+		return nil, fmt.Errorf("pipe address %s already in use", addr)
+	}
+
+	l := &pipeListener{
+		addr:   pipeAddr(addr),
+		accept: make(chan *pipeConn),
+		closed: make(chan struct{}),
+	}
+	l.onClose = func() {
+		t.mu.Lock()
+		delete(t.listeners, addr)
+		t.mu.Unlock()
+	}
+	t.listeners[addr] = l
+	return l, nil
+}
+
+// pipeAddr is the address of one end of a pipe.
+type pipeAddr string
+
+// Network returns the name of the network.
+func (pipeAddr) Network() string {
+	return "pipe"
+}
+
+// String returns the address.
+func (a pipeAddr) String() string {
+	return string(a)
+}
+
+// pipe is the state shared between both ends of a pipe, which is closed when either end is closed.
+type pipe struct {
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// pipeConn is one end of an in-memory pipe. Payloads are framed by the current codec as they would be over a network
+// connection, so that codec state such as delta encoding is exercised.
+type pipeConn struct {
+	*pipe
+	local, remote pipeAddr
+	in            <-chan []byte
+	out           chan<- []byte
+
+	mu    sync.Mutex
+	codec Codec
+	meter Meter
+}
+
+// newPipe creates a connected pair of pipe ends, the first of which is at addr a and the second of which is at b.
+func newPipe(a, b pipeAddr) (*pipeConn, *pipeConn) {
+	p := &pipe{closed: make(chan struct{})}
+	aToB := make(chan []byte, pipeBufferSize)
+	bToA := make(chan []byte, pipeBufferSize)
+	return &pipeConn{pipe: p, local: a, remote: b, in: bToA, out: aToB, codec: JSONCodec{}},
+		&pipeConn{pipe: p, local: b, remote: a, in: aToB, out: bToA, codec: JSONCodec{}}
+}
+
+// SetMeter sets the meter which subsequent messages are reported to.
+func (c *pipeConn) SetMeter(m Meter) {
+	c.mu.Lock()
+	c.meter = m
+	c.mu.Unlock()
+}
+
+// Send encodes and writes a payload to the connection.
+func (c *pipeConn) Send(p Payload) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.send(p)
+}
+
+// SendAndSwitch encodes and writes a payload to the connection using the current codec, then switches to the
+// provided codec for all subsequent reads and writes. No other writes can occur between the two.
+func (c *pipeConn) SendAndSwitch(p Payload, codec Codec) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.send(p); err != nil {
+		return err
+	}
+	c.codec = codec
+	return nil
+}
+
+// send encodes a payload and writes it to the pipe, blocking while the pipe is full. c.mu must be locked.
+func (c *pipeConn) send(p Payload) error {
+	select {
+	case <-c.closed:
+		return net.ErrClosed
+	default:
+	}
+
+	var buf bytes.Buffer
+	if err := c.codec.Encode(&buf, p); err != nil {
+		return err
+	}
+	select {
+	case c.out <- buf.Bytes():
+	case <-c.closed:
+		return net.ErrClosed
+	}
+	if c.meter != nil {
+		c.meter.MessageSent(p.Type(), buf.Len())
+	}
+	return nil
+}
+
+// Switch switches to the provided codec for all subsequent reads and writes.
+func (c *pipeConn) Switch(codec Codec) {
+	c.mu.Lock()
+	c.codec = codec
+	c.mu.Unlock()
+}
+
+// Receive reads and decodes the next payload from the connection. It must only be called from a single goroutine.
+// Frames sent before the pipe was closed are still received.
+func (c *pipeConn) Receive() (Payload, error) {
+	var frame []byte
+	select {
+	case frame = <-c.in:
+	case <-c.closed:
+		select {
+		case frame = <-c.in:
+		default:
+			return nil, io.EOF
+		}
+	}
+
+	c.mu.Lock()
+	codec, meter := c.codec, c.meter
+	c.mu.Unlock()
+	p, err := codec.Decode(bufio.NewReader(bytes.NewReader(frame)))
+	if err != nil && !errors.Is(err, ErrInvalidMessage) {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidMessage, err)
+	}
+	if err == nil && meter != nil {
+		meter.MessageReceived(p.Type(), len(frame))
+	}
+	return p, err
+}
+
+// RemoteAddr returns the address of the remote end of the connection.
+func (c *pipeConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// Close closes both ends of the pipe.
+func (c *pipeConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+	})
+	return nil
+}
+
+// pipeListener accepts pipe connections dialled on a PipeTransport.
+type pipeListener struct {
+	addr    pipeAddr
+	accept  chan *pipeConn
+	onClose func()
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// Accept waits for and returns the next connection.
+func (l *pipeListener) Accept() (Conn, error) {
+	select {
+	case conn := <-l.accept:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close stops listening, freeing the address to be listened on again. Accepted connections are left open.
+func (l *pipeListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closed)
+		l.onClose()
+	})
+	return nil
+}
+
+// Addr returns the address being listened on.
+func (l *pipeListener) Addr() net.Addr {
+	return l.addr
+}
@@ -0,0 +1,46 @@
+// Package protocol defines the typed messages exchanged between game clients and servers, and the codecs used to
+// encode and decode them on the wire.
+package protocol
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// Version is the newest protocol version supported by this build.
+	Version uint = 1
+	// MinVersion is the oldest protocol version supported by this build, below which peers are rejected.
+	MinVersion uint = 1
+
+	// HeartbeatInterval is how often each end of a connection pings the other.
+	HeartbeatInterval = time.Second
+	// IdleTimeout is how long a connection can go without receiving any messages before it is considered dead.
+	IdleTimeout = time.Second * 10
+	// HandshakeTimeout is how long a connection has to establish a user before it is closed.
+	HandshakeTimeout = time.Second * 10
+	// WriteTimeout is how long a write to a stream connection can block for before it fails, so that a peer which
+	// has stopped reading can't block its writer forever.
+	WriteTimeout = time.Second * 10
+)
+
+// Negotiate determines the protocol version to use for a connection given the newest version supported by the peer.
+// The highest version supported by both ends is chosen.
+func Negotiate(peerVersion uint) (uint, error) {
+	if peerVersion < MinVersion {
+		return 0, fmt.Errorf("unsupported protocol version %d: minimum supported version is %d", peerVersion, MinVersion)
+	}
+	if peerVersion > Version {
+		return Version, nil
+	}
+	return peerVersion, nil
+}
+
+// SelectCodec determines the name of the codec to switch to once the handshake succeeds, given the codec requested by
+// the client. JSON is selected if the request can't be satisfied.
+func SelectCodec(requested string) string {
+	if _, err := NewCodec(requested); err != nil || requested == "" {
+		return JSONCodecName
+	}
+	return requested
+}
@@ -0,0 +1,135 @@
+package protocol
+
+import (
+	"fmt"
+	"sort"
+)
+
+// SnapshotHistorySize is the number of recent states retained by a StateHistory. Snapshots are only delta-encoded
+// against states which are still retained by both ends, so clients which haven't acknowledged a snapshot within this
+// many steps are sent a full snapshot.
+const SnapshotHistorySize = 64
+
+// State is the state of the world at a server simulation step, as described by a snapshot.
+type State struct {
+	Tick        uint32
+	Players     map[string]Vitals
+	Projectiles map[uint32]Projectile
+}
+
+// NewState creates an empty state for the provided step.
+func NewState(tick uint32) State {
+	return State{
+		Tick:        tick,
+		Players:     make(map[string]Vitals),
+		Projectiles: make(map[uint32]Projectile),
+	}
+}
+
+// Delta creates a snapshot which describes the state as changes since baseline. A full snapshot describing the entire
+// state is created if baseline is nil.
+func (s State) Delta(baseline *State) Snapshot {
+	snapshot := Snapshot{Tick: s.Tick}
+	if baseline == nil {
+		baseline = &State{}
+	} else {
+		snapshot.Baseline = baseline.Tick
+	}
+
+	for name, vitals := range s.Players {
+		if prev, ok := baseline.Players[name]; !ok || prev != vitals {
+			snapshot.Players = append(snapshot.Players, vitals)
+		}
+	}
+	for name := range baseline.Players {
+		if _, ok := s.Players[name]; !ok {
+			snapshot.RemovedPlayers = append(snapshot.RemovedPlayers, name)
+		}
+	}
+
+	// projectiles don't change once fired, so only new projectiles are sent
+	for id, p := range s.Projectiles {
+		if _, ok := baseline.Projectiles[id]; !ok {
+			snapshot.Projectiles = append(snapshot.Projectiles, p)
+		}
+	}
+	for id := range baseline.Projectiles {
+		if _, ok := s.Projectiles[id]; !ok {
+			snapshot.RemovedProjectiles = append(snapshot.RemovedProjectiles, id)
+		}
+	}
+
+	// sort for deterministic snapshots
+	sort.Slice(snapshot.Players, func(i, j int) bool {
+		return snapshot.Players[i].Name < snapshot.Players[j].Name
+	})
+	sort.Strings(snapshot.RemovedPlayers)
+	sort.Slice(snapshot.Projectiles, func(i, j int) bool {
+		return snapshot.Projectiles[i].ID < snapshot.Projectiles[j].ID
+	})
+	sort.Slice(snapshot.RemovedProjectiles, func(i, j int) bool {
+		return snapshot.RemovedProjectiles[i] < snapshot.RemovedProjectiles[j]
+	})
+	return snapshot
+}
+
+// apply creates the state described by a snapshot which is delta-encoded against s.
+func (s State) apply(snapshot Snapshot) State {
+	state := NewState(snapshot.Tick)
+	for name, vitals := range s.Players {
+		state.Players[name] = vitals
+	}
+	for id, p := range s.Projectiles {
+		state.Projectiles[id] = p
+	}
+
+	for _, vitals := range snapshot.Players {
+		state.Players[vitals.Name] = vitals
+	}
+	for _, name := range snapshot.RemovedPlayers {
+		delete(state.Players, name)
+	}
+	for _, p := range snapshot.Projectiles {
+		state.Projectiles[p.ID] = p
+	}
+	for _, id := range snapshot.RemovedProjectiles {
+		delete(state.Projectiles, id)
+	}
+	return state
+}
+
+// StateHistory retains the most recent states so that snapshots can be delta-encoded against them. Steps are
+// numbered from 1, so tick 0 never refers to a retained state.
+type StateHistory struct {
+	states [SnapshotHistorySize]State
+}
+
+// Add retains a state, replacing the state retained SnapshotHistorySize steps earlier.
+func (h *StateHistory) Add(state State) {
+	h.states[state.Tick%SnapshotHistorySize] = state
+}
+
+// Get retrieves the retained state for a step. false is returned if it isn't retained.
+func (h *StateHistory) Get(tick uint32) (*State, bool) {
+	state := &h.states[tick%SnapshotHistorySize]
+	if tick == 0 || state.Tick != tick {
+		return nil, false
+	}
+	return state, true
+}
+
+// Apply creates the state described by a received snapshot and retains it. An error is returned if the snapshot is
+// delta-encoded against a state which isn't retained.
+func (h *StateHistory) Apply(snapshot Snapshot) (State, error) {
+	baseline := &State{}
+	if snapshot.Baseline != 0 {
+		var ok bool
+		if baseline, ok = h.Get(snapshot.Baseline); !ok {
+			return State{}, fmt.Errorf("snapshot %d baseline %d is not retained", snapshot.Tick, snapshot.Baseline)
+		}
+	}
+
+	state := baseline.apply(snapshot)
+	h.Add(state)
+	return state, nil
+}
@@ -0,0 +1,59 @@
+package protocol
+
+import (
+	"fmt"
+	"testing"
+)
+
+// TestSnapshotDelta delta-encodes a sequence of states against the client's acknowledged states, checking that the
+// client reconstructs every state, that unchanged entities aren't sent and that full snapshots are sent when no
+// acknowledged state is retained.
+func TestSnapshotDelta(t *testing.T) {
+	var server, client StateHistory
+	var acked uint32
+
+	for tick := uint32(1); tick <= SnapshotHistorySize*3; tick++ {
+		state := NewState(tick)
+		// one player moves every step while the other stands still, and leaves after a while
+		state.Players["mover"] = Vitals{Name: "mover", X: float64(tick), Health: 100, Seq: tick}
+		if tick < 100 {
+			state.Players["idler"] = Vitals{Name: "idler", X: 50, Y: 50, Health: 100}
+		}
+		// a projectile is fired every 10 steps and lives for 30 steps
+		for id := uint32(1); id <= tick; id += 10 {
+			if tick-id < 30 {
+				state.Projectiles[id] = Projectile{ID: id, Owner: "mover", StartX: float64(id), TTL: 30}
+			}
+		}
+		server.Add(state)
+
+		baseline, ok := server.Get(acked)
+		snapshot := state.Delta(baseline)
+		switch {
+		case !ok && snapshot.Baseline != 0:
+			t.Fatalf("tick %d: expected a full snapshot without an acknowledged state, got baseline %d", tick, snapshot.Baseline)
+		case ok && len(snapshot.Players) != 1:
+			t.Fatalf("tick %d: expected only the changed player in the delta, got %+v", tick, snapshot.Players)
+		}
+
+		// the client loses a long run of snapshots, after which the server falls back to a full snapshot
+		if tick > 120 && tick <= 120+SnapshotHistorySize {
+			continue
+		}
+		got, err := client.Apply(snapshot)
+		if err != nil {
+			t.Fatalf("tick %d: failed to apply snapshot: %s", tick, err)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(state) {
+			t.Fatalf("tick %d: reconstructed state mismatch:\ngot:  %+v\nwant: %+v", tick, got, state)
+		}
+		// the client only acknowledges every other snapshot
+		if tick%2 == 0 {
+			acked = tick
+		}
+	}
+
+	if _, err := client.Apply(Snapshot{Tick: 1000, Baseline: 999}); err == nil {
+		t.Error("expected a snapshot against an unknown baseline to be rejected")
+	}
+}
@@ -0,0 +1,14 @@
+package protocol
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+)
+
+// Fingerprint returns the hex encoded SHA-256 fingerprint of a server's TLS certificate, which clients pin on first
+// use.
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
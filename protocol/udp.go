@@ -0,0 +1,621 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// maxDatagramSize is the largest UDP datagram which can be sent or received.
+	maxDatagramSize = 65507
+	// datagramHeaderSize is the size of the header prefixing every datagram: the packet kind, the sequence number and
+	// the sequence number of the last reliable message delivered to the sender.
+	datagramHeaderSize = 9
+	// reliableWindow is the maximum number of reliable messages which can be awaiting acknowledgement, or buffered
+	// while waiting for earlier messages to arrive.
+	reliableWindow = 1024
+	// initialResendTimeout is how long unacknowledged reliable messages wait before being resent until the round trip
+	// time has been measured.
+	initialResendTimeout = time.Millisecond * 200
+	// minResendTimeout and maxResendTimeout bound the time unacknowledged reliable messages wait before being resent.
+	minResendTimeout = time.Millisecond * 30
+	maxResendTimeout = time.Second
+	// udpTickInterval is how often resends, keepalives and timeouts are processed.
+	udpTickInterval = time.Millisecond * 10
+	// keepaliveInterval is how long a connection can go without sending before an acknowledgement is sent to keep it
+	// alive.
+	keepaliveInterval = time.Second
+	// udpIdleTimeout is how long a connection can go without receiving before it is closed.
+	udpIdleTimeout = time.Second * 10
+	// incomingQueueSize is the number of received messages which can be queued awaiting Receive.
+	incomingQueueSize = 256
+	// acceptQueueSize is the number of new connections which can be queued awaiting Accept.
+	acceptQueueSize = 16
+)
+
+// datagram packet kinds
+const (
+	packetReliable byte = iota + 1
+	packetUnreliable
+	packetAck
+	packetClose
+)
+
+// channel determines how a payload is delivered over transports which support unreliable delivery.
+type channel byte
+
+// Channel constants.
+const (
+	// reliableOrdered payloads are resent until acknowledged and are delivered in the order they were sent.
+	reliableOrdered channel = iota
+	// unreliableSequenced payloads are sent once, and are dropped if they arrive after a newer payload of the same
+	// type.
+	unreliableSequenced
+)
+
+// channelOf determines the channel a payload is delivered over. High frequency real-time state is superseded so
+// quickly that it isn't worth resending, whereas everything else must arrive. Projectiles are discrete events rather
+// than state, so they're delivered reliably as a lost or overtaken shot would never be fired.
+func channelOf(p Payload) channel {
+	switch p.(type) {
+	case Vitals, Snapshot, SnapshotAck, Ping, Pong:
+		return unreliableSequenced
+	}
+	return reliableOrdered
+}
+
+// independent returns a codec which encodes each frame independently of previous frames, for frames which may be lost
+// or reordered.
+func independent(codec Codec) Codec {
+	if _, ok := codec.(*BinaryCodec); ok {
+		c := NewBinaryCodec()
+		c.absolute = true
+		return c
+	}
+	return codec
+}
+
+// pendingMessage is a sent reliable message awaiting acknowledgement.
+type pendingMessage struct {
+	seq      uint32
+	packet   []byte
+	sent     time.Time
+	lastSent time.Time
+	resent   bool
+}
+
+// received is a received message awaiting decoding by Receive.
+type received struct {
+	reliable bool
+	seq      uint32
+	body     []byte
+}
+
+// UDPConn is a Conn over UDP. Payloads are delivered over either an unreliable sequenced channel or a reliable ordered
+// channel depending on their type, so that lost real-time state doesn't hold up later state as it would over TCP.
+type UDPConn struct {
+	pc     net.PacketConn
+	remote net.Addr
+	// onClose is called once the connection has been closed, e.g. to release the socket or remove the connection from
+	// its listener
+	onClose func()
+
+	mu sync.Mutex
+	// codec is used for the reliable channel and unreliable for the unreliable channel
+	codec      Codec
+	unreliable Codec
+	meter      Meter
+
+	// send state
+	reliableSeq   uint32
+	unreliableSeq uint32
+	unacked       []pendingMessage
+	srtt          time.Duration
+	lastSend      time.Time
+
+	// receive state
+	delivered   uint32
+	outOfOrder  map[uint32][]byte
+	lastReceive time.Time
+	idleTimeout time.Duration
+	// lastUnreliable is the sequence number of the latest unreliable message received of each type. Types are tracked
+	// separately so that a message isn't dropped for arriving after a newer message of another type. It's only
+	// accessed by Receive.
+	lastUnreliable map[Type]uint32
+
+	incoming  chan received
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// newUDPConn creates a UDPConn to the remote address, sending from pc.
+func newUDPConn(pc net.PacketConn, remote net.Addr, onClose func()) *UDPConn {
+	now := time.Now()
+	return &UDPConn{
+		pc:             pc,
+		remote:         remote,
+		onClose:        onClose,
+		codec:          JSONCodec{},
+		unreliable:     JSONCodec{},
+		lastSend:       now,
+		lastReceive:    now,
+		idleTimeout:    udpIdleTimeout,
+		outOfOrder:     make(map[uint32][]byte),
+		lastUnreliable: make(map[Type]uint32),
+		incoming:       make(chan received, incomingQueueSize),
+		closed:         make(chan struct{}),
+	}
+}
+
+// UDPTransport is a Transport over UDP.
+type UDPTransport struct{}
+
+// Dial connects to the listener at addr.
+func (UDPTransport) Dial(addr string) (Conn, error) {
+	conn, err := DialUDP(addr)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// Listen listens for connections on addr.
+func (UDPTransport) Listen(addr string) (Listener, error) {
+	l, err := ListenUDP(addr)
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// DialUDP creates a UDPConn to the server at addr.
+func DialUDP(addr string) (*UDPConn, error) {
+	remote, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	// an unspecified host refers to the local machine, which replies from the loopback address
+	if remote.IP == nil || remote.IP.IsUnspecified() {
+		remote.IP = net.IPv4(127, 0, 0, 1)
+	}
+
+	pc, err := net.ListenPacket("udp", ":0")
+	if err != nil {
+		return nil, err
+	}
+	c := newUDPConn(pc, remote, func() {
+		pc.Close()
+	})
+	go c.serve(pc)
+	go c.maintain()
+	return c, nil
+}
+
+// serve reads datagrams sent from the remote address to pc, which is owned by the connection, until it is closed.
+func (c *UDPConn) serve(pc net.PacketConn) {
+	remote, _ := c.remote.(*net.UDPAddr)
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, from, err := pc.ReadFrom(buf)
+		if err != nil {
+			c.Close()
+			return
+		}
+		udpFrom, ok := from.(*net.UDPAddr)
+		if !ok || remote == nil || !udpFrom.IP.Equal(remote.IP) || udpFrom.Port != remote.Port {
+			continue
+		}
+		c.handle(append([]byte(nil), buf[:n]...))
+	}
+}
+
+// SetMeter sets the meter which subsequent messages are reported to. Retransmissions of reliable messages aren't
+// reported.
+func (c *UDPConn) SetMeter(m Meter) {
+	c.mu.Lock()
+	c.meter = m
+	c.mu.Unlock()
+}
+
+// Send encodes and writes a payload to the connection.
+func (c *UDPConn) Send(p Payload) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.send(p)
+}
+
+// SendAndSwitch encodes and writes a payload to the connection using the current codec, then switches to the
+// provided codec for all subsequent reads and writes. No other writes can occur between the two.
+func (c *UDPConn) SendAndSwitch(p Payload, codec Codec) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.send(p); err != nil {
+		return err
+	}
+	c.codec, c.unreliable = codec, independent(codec)
+	return nil
+}
+
+// Switch switches to the provided codec for all subsequent reads and writes.
+func (c *UDPConn) Switch(codec Codec) {
+	c.mu.Lock()
+	c.codec, c.unreliable = codec, independent(codec)
+	c.mu.Unlock()
+}
+
+// Receive reads and decodes the next payload from the connection. It must only be called from a single goroutine.
+// Malformed datagrams don't affect later ones, so decoding errors are always ErrInvalidMessage.
+func (c *UDPConn) Receive() (Payload, error) {
+	for {
+		var msg received
+		select {
+		case msg = <-c.incoming:
+		case <-c.closed:
+			return nil, io.EOF
+		}
+
+		c.mu.Lock()
+		codec, meter := c.unreliable, c.meter
+		if msg.reliable {
+			codec = c.codec
+		}
+		c.mu.Unlock()
+
+		p, err := codec.Decode(bufio.NewReader(bytes.NewReader(msg.body)))
+		if err != nil {
+			if !errors.Is(err, ErrInvalidMessage) {
+				err = fmt.Errorf("%w: %s", ErrInvalidMessage, err)
+			}
+			return nil, err
+		}
+
+		// drop stale messages which have been overtaken by newer ones of the same type
+		if !msg.reliable {
+			if msg.seq <= c.lastUnreliable[p.Type()] {
+				continue
+			}
+			c.lastUnreliable[p.Type()] = msg.seq
+		}
+		if meter != nil {
+			meter.MessageReceived(p.Type(), datagramHeaderSize+len(msg.body))
+		}
+		return p, nil
+	}
+}
+
+// RemoteAddr returns the address of the remote end of the connection.
+func (c *UDPConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// Close notifies the remote end that the connection is closing and closes it. Unacknowledged reliable messages are
+// discarded.
+func (c *UDPConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.mu.Lock()
+		c.write(make([]byte, datagramHeaderSize), packetClose, 0)
+		c.mu.Unlock()
+
+		close(c.closed)
+		if c.onClose != nil {
+			c.onClose()
+		}
+	})
+	return nil
+}
+
+// send encodes a payload into a datagram and sends it over the payload's channel. c.mu must be locked.
+func (c *UDPConn) send(p Payload) error {
+	select {
+	case <-c.closed:
+		return net.ErrClosed
+	default:
+	}
+
+	if channelOf(p) == unreliableSequenced {
+		packet, err := encodeDatagram(c.unreliable, p)
+		if err != nil {
+			return err
+		}
+		c.unreliableSeq++
+		if err := c.write(packet, packetUnreliable, c.unreliableSeq); err != nil {
+			return err
+		}
+		c.metered(p, packet)
+		return nil
+	}
+
+	if len(c.unacked) >= reliableWindow {
+		return errors.New("too many unacknowledged messages")
+	}
+	packet, err := encodeDatagram(c.codec, p)
+	if err != nil {
+		return err
+	}
+	c.reliableSeq++
+	now := time.Now()
+	c.unacked = append(c.unacked, pendingMessage{
+		seq:      c.reliableSeq,
+		packet:   packet,
+		sent:     now,
+		lastSent: now,
+	})
+	if err := c.write(packet, packetReliable, c.reliableSeq); err != nil {
+		return err
+	}
+	c.metered(p, packet)
+	return nil
+}
+
+// metered reports a payload sent in a datagram to the meter. c.mu must be locked.
+func (c *UDPConn) metered(p Payload, packet []byte) {
+	if c.meter != nil {
+		c.meter.MessageSent(p.Type(), len(packet))
+	}
+}
+
+// encodeDatagram encodes a payload into a datagram body, leaving space for the header.
+func encodeDatagram(codec Codec, p Payload) ([]byte, error) {
+	buf := bytes.NewBuffer(make([]byte, datagramHeaderSize, 128))
+	if err := codec.Encode(buf, p); err != nil {
+		return nil, err
+	}
+	if buf.Len() > maxDatagramSize {
+		return nil, fmt.Errorf("%s message exceeds maximum datagram size", p.Type())
+	}
+	return buf.Bytes(), nil
+}
+
+// write fills in a datagram's header, acknowledging the latest reliable message delivered, and sends it. c.mu must be
+// locked.
+func (c *UDPConn) write(packet []byte, kind byte, seq uint32) error {
+	packet[0] = kind
+	binary.BigEndian.PutUint32(packet[1:5], seq)
+	binary.BigEndian.PutUint32(packet[5:9], c.delivered)
+	c.lastSend = time.Now()
+	_, err := c.pc.WriteTo(packet, c.remote)
+	return err
+}
+
+// handle processes a datagram received from the remote end of the connection.
+func (c *UDPConn) handle(packet []byte) {
+	if len(packet) < datagramHeaderSize {
+		return
+	}
+	kind := packet[0]
+	seq := binary.BigEndian.Uint32(packet[1:5])
+	ack := binary.BigEndian.Uint32(packet[5:9])
+	body := packet[datagramHeaderSize:]
+
+	if kind == packetClose {
+		c.Close()
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	c.lastReceive = now
+	c.acknowledge(ack, now)
+
+	switch kind {
+	case packetReliable:
+		c.receiveReliable(seq, body)
+		// acknowledge immediately so that the remote end stops resending, including duplicates in case the previous
+		// acknowledgement was lost
+		c.write(make([]byte, datagramHeaderSize), packetAck, 0)
+
+	case packetUnreliable:
+		// stale messages are dropped by Receive, as their type isn't known until they're decoded
+		select {
+		case c.incoming <- received{seq: seq, body: body}:
+		default:
+		}
+	}
+}
+
+// acknowledge discards the sent reliable messages which the remote end has received, measuring the round trip time
+// from those which weren't resent. c.mu must be locked.
+func (c *UDPConn) acknowledge(ack uint32, now time.Time) {
+	n := 0
+	for n < len(c.unacked) && c.unacked[n].seq <= ack {
+		if !c.unacked[n].resent {
+			rtt := now.Sub(c.unacked[n].sent)
+			if c.srtt == 0 {
+				c.srtt = rtt
+			} else {
+				c.srtt += (rtt - c.srtt) / 8
+			}
+		}
+		n++
+	}
+	c.unacked = c.unacked[n:]
+}
+
+// receiveReliable buffers a reliable message and delivers every buffered message which is next in order. Messages are
+// left undelivered if the incoming queue is full, and are redelivered by the remote end as they won't be
+// acknowledged. c.mu must be locked.
+func (c *UDPConn) receiveReliable(seq uint32, body []byte) {
+	if seq <= c.delivered || seq > c.delivered+reliableWindow {
+		return
+	}
+	c.outOfOrder[seq] = body
+
+	for {
+		next, ok := c.outOfOrder[c.delivered+1]
+		if !ok {
+			return
+		}
+		select {
+		case c.incoming <- received{reliable: true, body: next}:
+		default:
+			return
+		}
+		delete(c.outOfOrder, c.delivered+1)
+		c.delivered++
+	}
+}
+
+// resendTimeout determines how long an unacknowledged reliable message waits before being resent. c.mu must be locked.
+func (c *UDPConn) resendTimeout() time.Duration {
+	if c.srtt == 0 {
+		return initialResendTimeout
+	}
+	timeout := c.srtt * 2
+	switch {
+	case timeout < minResendTimeout:
+		return minResendTimeout
+	case timeout > maxResendTimeout:
+		return maxResendTimeout
+	}
+	return timeout
+}
+
+// maintain periodically resends unacknowledged reliable messages and keeps the connection alive, closing it if the
+// remote end stops responding.
+func (c *UDPConn) maintain() {
+	ticker := time.NewTicker(udpTickInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.closed:
+			return
+		case now := <-ticker.C:
+			c.mu.Lock()
+			if now.Sub(c.lastReceive) > c.idleTimeout {
+				c.mu.Unlock()
+				c.Close()
+				return
+			}
+
+			timeout := c.resendTimeout()
+			for i := range c.unacked {
+				msg := &c.unacked[i]
+				if now.Sub(msg.lastSent) < timeout {
+					continue
+				}
+				msg.lastSent = now
+				msg.resent = true
+				c.write(msg.packet, packetReliable, msg.seq)
+			}
+			if now.Sub(c.lastSend) >= keepaliveInterval {
+				c.write(make([]byte, datagramHeaderSize), packetAck, 0)
+			}
+			c.mu.Unlock()
+		}
+	}
+}
+
+// UDPListener accepts UDPConns from a single UDP socket, demultiplexing datagrams by their source address. A
+// connection is established by the first reliable message received from an address.
+type UDPListener struct {
+	pc net.PacketConn
+
+	mu    sync.Mutex
+	conns map[string]*UDPConn
+
+	accept    chan *UDPConn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// ListenUDP listens for UDP connections on addr.
+func ListenUDP(addr string) (*UDPListener, error) {
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	l := &UDPListener{
+		pc:     pc,
+		conns:  make(map[string]*UDPConn),
+		accept: make(chan *UDPConn, acceptQueueSize),
+		closed: make(chan struct{}),
+	}
+	go l.serve()
+	return l, nil
+}
+
+// serve reads datagrams from the socket and passes them to their connections until the listener is closed.
+func (l *UDPListener) serve() {
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, from, err := l.pc.ReadFrom(buf)
+		if err != nil {
+			l.Close()
+			return
+		}
+		packet := append([]byte(nil), buf[:n]...)
+
+		key := from.String()
+		l.mu.Lock()
+		conn, ok := l.conns[key]
+		if !ok {
+			// only the first reliable message can establish a connection
+			if n < datagramHeaderSize || packet[0] != packetReliable || binary.BigEndian.Uint32(packet[1:5]) != 1 {
+				l.mu.Unlock()
+				continue
+			}
+			conn = newUDPConn(l.pc, from, func() {
+				l.mu.Lock()
+				delete(l.conns, key)
+				l.mu.Unlock()
+			})
+			select {
+			case l.accept <- conn:
+				l.conns[key] = conn
+				go conn.maintain()
+			default:
+				// too many pending connections - the client will retry
+				l.mu.Unlock()
+				continue
+			}
+		}
+		l.mu.Unlock()
+
+		conn.handle(packet)
+	}
+}
+
+// Accept waits for and returns the next connection.
+func (l *UDPListener) Accept() (Conn, error) {
+	select {
+	case conn := <-l.accept:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close stops listening and closes the socket, closing all connections accepted from it.
+func (l *UDPListener) Close() error {
+	var err error
+	l.closeOnce.Do(func() {
+		close(l.closed)
+
+		l.mu.Lock()
+		conns := make([]*UDPConn, 0, len(l.conns))
+		for _, conn := range l.conns {
+			conns = append(conns, conn)
+		}
+		l.mu.Unlock()
+
+		for _, conn := range conns {
+			conn.Close()
+		}
+		err = l.pc.Close()
+	})
+	return err
+}
+
+// Addr returns the address being listened on.
+func (l *UDPListener) Addr() net.Addr {
+	return l.pc.LocalAddr()
+}
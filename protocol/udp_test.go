@@ -0,0 +1,276 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// faultyPacketConn is a net.PacketConn which drops or holds back the datagrams written to it, simulating an unreliable
+// network.
+type faultyPacketConn struct {
+	net.PacketConn
+
+	mu sync.Mutex
+	// drop determines whether a datagram is dropped.
+	drop func(packet []byte) bool
+	// holding causes datagrams to be held back until they're released.
+	holding bool
+	held    [][]byte
+	heldTo  []net.Addr
+}
+
+// WriteTo writes a datagram unless it is dropped or held back.
+func (c *faultyPacketConn) WriteTo(packet []byte, addr net.Addr) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.drop != nil && c.drop(packet) {
+		return len(packet), nil
+	}
+	if c.holding {
+		c.held = append(c.held, append([]byte(nil), packet...))
+		c.heldTo = append(c.heldTo, addr)
+		return len(packet), nil
+	}
+	return c.PacketConn.WriteTo(packet, addr)
+}
+
+// setDrop sets the function which determines whether datagrams are dropped.
+func (c *faultyPacketConn) setDrop(drop func(packet []byte) bool) {
+	c.mu.Lock()
+	c.drop = drop
+	c.mu.Unlock()
+}
+
+// hold holds back subsequent datagrams until release is called.
+func (c *faultyPacketConn) hold() {
+	c.mu.Lock()
+	c.holding = true
+	c.mu.Unlock()
+}
+
+// release stops holding back datagrams and writes the held datagrams in reverse order.
+func (c *faultyPacketConn) release() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.holding = false
+	for i := len(c.held) - 1; i >= 0; i-- {
+		c.PacketConn.WriteTo(c.held[i], c.heldTo[i])
+	}
+	c.held, c.heldTo = nil, nil
+}
+
+// newUDPPair creates a pair of UDPConns connected to each other over loopback, whose datagrams can be dropped or
+// reordered.
+func newUDPPair(t *testing.T) (*UDPConn, *faultyPacketConn, *UDPConn, *faultyPacketConn) {
+	t.Helper()
+	listen := func() *faultyPacketConn {
+		pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("failed to listen: %s", err)
+		}
+		return &faultyPacketConn{PacketConn: pc}
+	}
+	pcA, pcB := listen(), listen()
+
+	a := newUDPConn(pcA, pcB.LocalAddr(), func() { pcA.Close() })
+	b := newUDPConn(pcB, pcA.LocalAddr(), func() { pcB.Close() })
+	for _, c := range []*UDPConn{a, b} {
+		go c.serve(c.pc)
+		go c.maintain()
+		t.Cleanup(func() { c.Close() })
+	}
+	return a, pcA, b, pcB
+}
+
+// receive receives the next payload from a connection, failing the test if it errors or doesn't arrive in time.
+func receive(t *testing.T, c *UDPConn) Payload {
+	t.Helper()
+	type result struct {
+		p   Payload
+		err error
+	}
+	results := make(chan result, 1)
+	go func() {
+		p, err := c.Receive()
+		results <- result{p, err}
+	}()
+
+	select {
+	case r := <-results:
+		if r.err != nil {
+			t.Fatalf("failed to receive: %s", r.err)
+		}
+		return r.p
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting to receive")
+		return nil
+	}
+}
+
+// waitForClose waits for a connection to close, failing the test if it doesn't close in time.
+func waitForClose(t *testing.T, c *UDPConn) {
+	t.Helper()
+	select {
+	case <-c.closed:
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for connection to close")
+	}
+}
+
+// firstSend returns a drop function which drops the first transmission of each reliable message, so that every one
+// must be resent.
+func firstSend() func(packet []byte) bool {
+	sent := make(map[uint32]bool)
+	return func(packet []byte) bool {
+		if packet[0] != packetReliable {
+			return false
+		}
+		seq := binary.BigEndian.Uint32(packet[1:5])
+		if sent[seq] {
+			return false
+		}
+		sent[seq] = true
+		return true
+	}
+}
+
+// TestUDPLoss checks that lost reliable messages are resent until they're acknowledged, and are delivered once and in
+// order.
+func TestUDPLoss(t *testing.T) {
+	a, pcA, b, _ := newUDPPair(t)
+	pcA.setDrop(firstSend())
+
+	payloads := []Payload{
+		Chat{Channel: ChatGlobal, Text: "one"},
+		Projectile{Weapon: "Deagle", SpawnTime: 1557000000123456789, VelX: 100, TTL: time.Second},
+		Chat{Channel: ChatGlobal, Text: "two"},
+		Projectile{Weapon: "M4A1", SpawnTime: 1557000000987654321, VelY: -100, TTL: time.Second},
+	}
+	for _, p := range payloads {
+		if err := a.Send(p); err != nil {
+			t.Fatalf("failed to send %s: %s", p.Type(), err)
+		}
+	}
+	for _, want := range payloads {
+		if got := receive(t, b); !reflect.DeepEqual(got, want) {
+			t.Errorf("got %#v, want %#v", got, want)
+		}
+	}
+
+	// once acknowledged, messages are no longer resent
+	deadline := time.Now().Add(time.Second * 5)
+	for {
+		a.mu.Lock()
+		unacked := len(a.unacked)
+		a.mu.Unlock()
+		if unacked == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d messages were never acknowledged", unacked)
+		}
+		time.Sleep(udpTickInterval)
+	}
+
+	// nothing is delivered twice, despite the resends
+	sentinel := Chat{Channel: ChatGlobal, Text: "sentinel"}
+	if err := a.Send(sentinel); err != nil {
+		t.Fatalf("failed to send: %s", err)
+	}
+	if got := receive(t, b); !reflect.DeepEqual(got, sentinel) {
+		t.Errorf("got %#v, want %#v", got, sentinel)
+	}
+}
+
+// TestUDPReordering checks that reordered reliable messages are delivered in the order they were sent, and that
+// reordered unreliable messages are only dropped if they've been overtaken by a newer message of the same type.
+func TestUDPReordering(t *testing.T) {
+	a, pcA, b, _ := newUDPPair(t)
+
+	pcA.hold()
+	reliable := []Payload{
+		Chat{Channel: ChatGlobal, Text: "one"},
+		Chat{Channel: ChatGlobal, Text: "two"},
+		Chat{Channel: ChatGlobal, Text: "three"},
+	}
+	for _, p := range reliable {
+		if err := a.Send(p); err != nil {
+			t.Fatalf("failed to send %s: %s", p.Type(), err)
+		}
+	}
+	pcA.release()
+	for _, want := range reliable {
+		if got := receive(t, b); !reflect.DeepEqual(got, want) {
+			t.Errorf("got %#v, want %#v", got, want)
+		}
+	}
+
+	// the older snapshot is dropped, but the ping is delivered despite arriving after the newer snapshot
+	pcA.hold()
+	for _, p := range []Payload{Snapshot{Tick: 1}, Ping{Time: 1}, Snapshot{Tick: 2}} {
+		if err := a.Send(p); err != nil {
+			t.Fatalf("failed to send %s: %s", p.Type(), err)
+		}
+	}
+	pcA.release()
+	sentinel := Chat{Channel: ChatGlobal, Text: "sentinel"}
+	if err := a.Send(sentinel); err != nil {
+		t.Fatalf("failed to send: %s", err)
+	}
+
+	var got []Payload
+	for len(got) == 0 || got[len(got)-1] != Payload(sentinel) {
+		got = append(got, receive(t, b))
+	}
+	want := []Payload{Snapshot{Tick: 2}, Ping{Time: 1}, sentinel}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+}
+
+// TestUDPIdleTimeout checks that a connection is closed once the remote end stops responding, but is kept alive by
+// keepalives while it is idle.
+func TestUDPIdleTimeout(t *testing.T) {
+	a, _, _, pcB := newUDPPair(t)
+	a.mu.Lock()
+	a.idleTimeout = keepaliveInterval * 2
+	a.mu.Unlock()
+
+	select {
+	case <-a.closed:
+		t.Fatal("expected keepalives to keep the idle connection open")
+	case <-time.After(a.idleTimeout + keepaliveInterval/2):
+	}
+
+	pcB.setDrop(func([]byte) bool { return true })
+	waitForClose(t, a)
+	if _, err := a.Receive(); err != io.EOF {
+		t.Errorf("expected receiving from a timed out connection to return EOF, got %v", err)
+	}
+}
+
+// TestUDPClose checks that closing a connection closes the remote end too, and that it can't be used afterwards.
+func TestUDPClose(t *testing.T) {
+	a, _, b, _ := newUDPPair(t)
+	if err := a.Close(); err != nil {
+		t.Fatalf("failed to close: %s", err)
+	}
+	// closing is idempotent
+	if err := a.Close(); err != nil {
+		t.Fatalf("failed to close twice: %s", err)
+	}
+
+	waitForClose(t, b)
+	if _, err := b.Receive(); err != io.EOF {
+		t.Errorf("expected receiving from a closed connection to return EOF, got %v", err)
+	}
+	if err := a.Send(Chat{Text: "hello"}); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("expected sending on a closed connection to fail, got %v", err)
+	}
+}
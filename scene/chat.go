@@ -0,0 +1,235 @@
+package scene
+
+import (
+	"fmt"
+	"image/color"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/faiface/pixel"
+	"github.com/faiface/pixel/imdraw"
+	"github.com/faiface/pixel/pixelgl"
+	"github.com/faiface/pixel/text"
+	"golang.org/x/image/colornames"
+	"golang.org/x/image/font/basicfont"
+
+	"github.com/jemgunay/procedural-game/client"
+	"github.com/jemgunay/procedural-game/protocol"
+	"github.com/jemgunay/procedural-game/scene/ui"
+)
+
+const (
+	// chatScrollback is the number of chat lines retained in the log.
+	chatScrollback = 100
+	// chatVisibleLines is the number of lines shown while typing, and chatRecentLines is the number of recent lines
+	// shown for chatFadeAfter after they're received while not typing.
+	chatVisibleLines = 16
+	chatRecentLines  = 6
+	chatFadeAfter    = time.Second * 10
+)
+
+var chatFontAtlas = text.NewAtlas(basicfont.Face7x13, text.ASCII)
+
+// chatColours are the colours of the lines in the chat log for each channel.
+var chatColours = map[protocol.ChatChannel]color.Color{
+	protocol.ChatGlobal:    colornames.White,
+	protocol.ChatProximity: colornames.Palegreen,
+	protocol.ChatWhisper:   colornames.Violet,
+	protocol.ChatSystem:    colornames.Yellow,
+}
+
+// chatLine is a line of the chat log.
+type chatLine struct {
+	text     string
+	colour   color.Color
+	received time.Time
+}
+
+// ChatOverlay is the chat layer which is drawn over the main game layer. It shows recently received messages, and
+// takes over the keyboard while the player types a message once opened with enter. Messages are sent to the global
+// channel unless switched to the proximity channel with tab, and text starting with a slash is a command for the
+// server.
+type ChatOverlay struct {
+	client   *client.Client
+	username string
+	input    *ui.TextBox
+	channel  protocol.ChatChannel
+
+	// opening is set for the update after the chat is opened, so that the key press opening it isn't typed into it
+	opening bool
+	open    bool
+	// scroll is the number of lines scrolled back through the log
+	scroll int
+
+	// mu guards lines, which are added by the goroutine processing server updates
+	mu    sync.Mutex
+	lines []chatLine
+}
+
+// NewChatOverlay creates and initialises a new chat overlay layer for the named player.
+func NewChatOverlay(c *client.Client, username string) *ChatOverlay {
+	chat := &ChatOverlay{
+		client:   c,
+		username: username,
+		input:    ui.NewTextBox("", colornames.Black, colornames.White),
+	}
+	chat.input.SetMaxLength(protocol.MaxChatLength)
+	chat.setChannel(protocol.ChatGlobal)
+	return chat
+}
+
+// setChannel sets the channel messages are sent to.
+func (c *ChatOverlay) setChannel(channel protocol.ChatChannel) {
+	c.channel = channel
+	c.input.SetLabel(fmt.Sprintf("Chat (%s) - tab to switch channel, /help for commands", channel))
+}
+
+// Typing indicates whether the player is typing a message, in which case the keyboard shouldn't control the game.
+func (c *ChatOverlay) Typing() bool {
+	return c.open || c.opening
+}
+
+// Add adds a message received from the server to the chat log.
+func (c *ChatOverlay) Add(msg protocol.Chat) {
+	var line string
+	switch msg.Channel {
+	case protocol.ChatGlobal:
+		line = msg.From + ": " + msg.Text
+	case protocol.ChatProximity:
+		line = "[nearby] " + msg.From + ": " + msg.Text
+	case protocol.ChatWhisper:
+		if msg.From == c.username {
+			line = "[to " + msg.To + "] " + msg.Text
+		} else {
+			line = "[from " + msg.From + "] " + msg.Text
+		}
+	default:
+		line = "* " + msg.Text
+	}
+	colour, ok := chatColours[msg.Channel]
+	if !ok {
+		colour = colornames.White
+	}
+
+	c.mu.Lock()
+	c.lines = append(c.lines, chatLine{text: line, colour: colour, received: time.Now()})
+	if len(c.lines) > chatScrollback {
+		c.lines = c.lines[len(c.lines)-chatScrollback:]
+	}
+	c.mu.Unlock()
+}
+
+// Update updates the chat overlay layer logic.
+func (c *ChatOverlay) Update(dt float64) {
+	switch {
+	case c.input.Submitted():
+		if text := strings.TrimSpace(c.input.Text()); text != "" {
+			c.client.Send(protocol.Chat{Channel: c.channel, Text: text})
+		}
+		c.close()
+
+	// clicking outside of the input also closes the chat
+	case c.open && (win.JustPressed(pixelgl.KeyEscape) || !c.input.Focused()):
+		c.close()
+
+	case c.opening:
+		c.opening = false
+		c.open = true
+		c.input.SetFocus(true)
+
+	case !c.open && win.JustPressed(pixelgl.KeyEnter):
+		c.opening = true
+
+	// open the chat with a command started
+	case !c.open && win.JustPressed(pixelgl.KeySlash):
+		c.opening = true
+		c.input.SetText("/")
+	}
+
+	if !c.open {
+		return
+	}
+	if win.JustPressed(pixelgl.KeyTab) {
+		if c.channel == protocol.ChatGlobal {
+			c.setChannel(protocol.ChatProximity)
+		} else {
+			c.setChannel(protocol.ChatGlobal)
+		}
+	}
+
+	// scroll back through the log
+	c.mu.Lock()
+	c.scroll += int(win.MouseScroll().Y)
+	if max := len(c.lines) - chatVisibleLines; c.scroll > max {
+		c.scroll = max
+	}
+	if c.scroll < 0 {
+		c.scroll = 0
+	}
+	c.mu.Unlock()
+}
+
+// close closes the chat input, discarding any unsent text.
+func (c *ChatOverlay) close() {
+	c.open = false
+	c.opening = false
+	c.scroll = 0
+	c.input.SetFocus(false)
+	c.input.SetText("")
+}
+
+// Draw draws the chat overlay layer to the window.
+func (c *ChatOverlay) Draw() {
+	win.SetMatrix(pixel.IM)
+
+	// select the lines to show - the log is only shown in full while typing
+	c.mu.Lock()
+	end := len(c.lines) - c.scroll
+	start := end - chatVisibleLines
+	if !c.open {
+		start = end - chatRecentLines
+		for start < end && (start < 0 || time.Since(c.lines[start].received) > chatFadeAfter) {
+			start++
+		}
+	}
+	if start < 0 {
+		start = 0
+	}
+	lines := append([]chatLine(nil), c.lines[start:end]...)
+	c.mu.Unlock()
+
+	const (
+		margin     = 10.0
+		inputH     = 40.0
+		lineHeight = 16.0
+	)
+	logMin := pixel.V(margin, margin+inputH+margin)
+
+	// darken the background behind the log and input so that they're readable over the world
+	if len(lines) > 0 || c.open {
+		logH := lineHeight * float64(len(lines))
+		if c.open {
+			logH = lineHeight * chatVisibleLines
+		}
+		bg := imdraw.New(nil)
+		bg.Color = pixel.Alpha(0.4)
+		bg.Push(pixel.V(margin/2, margin/2), pixel.V(margin*2+win.Bounds().W()*0.5, logMin.Y+logH+margin/2))
+		bg.Rectangle(0)
+		bg.Draw(win)
+	}
+
+	// the most recent line is drawn at the bottom
+	txt := text.New(pixel.ZV, chatFontAtlas)
+	for i := len(lines) - 1; i >= 0; i-- {
+		txt.Clear()
+		txt.Color = lines[i].colour
+		txt.WriteString(lines[i].text)
+		pos := logMin.Add(pixel.V(0, lineHeight*float64(len(lines)-1-i)))
+		txt.Draw(win, pixel.IM.Moved(pos))
+	}
+
+	if c.open {
+		c.input.Draw(win, pixel.R(margin, margin, margin+win.Bounds().W()*0.5, margin+inputH))
+	}
+}
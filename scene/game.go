@@ -3,8 +3,9 @@ package scene
 import (
 	"errors"
 	"fmt"
-	"strconv"
-	"strings"
+	"log/slog"
+	"sync"
+	"time"
 
 	"github.com/faiface/pixel"
 	"github.com/faiface/pixel/pixelgl"
@@ -12,8 +13,10 @@ import (
 
 	"github.com/jemgunay/procedural-game/client"
 	"github.com/jemgunay/procedural-game/player"
+	"github.com/jemgunay/procedural-game/protocol"
 	"github.com/jemgunay/procedural-game/scene/world"
 	"github.com/jemgunay/procedural-game/server"
+	"github.com/jemgunay/procedural-game/sim"
 )
 
 // Game is the main interactive game functionality layer.
@@ -31,6 +34,28 @@ type Game struct {
 	locked        bool
 	overlayResult chan LayerResult
 	exitCh        chan struct{}
+
+	// inputSeq is the sequence number of the last input command sent to the server
+	inputSeq  uint32
+	lastInput protocol.Input
+	// predictor predicts the main player's movement ahead of the server
+	predictor *sim.Predictor
+	// teleport is the position the main player is moved to by the next update, or nil if they haven't been teleported.
+	// Server updates are processed on another goroutine, so the player and camera are only moved by Update.
+	teleportMu sync.Mutex
+	teleport   *pixel.Vec
+	// state is the state of the world described by the latest snapshot received from the server, and states are the
+	// recent states which subsequent snapshots can be delta-encoded against
+	state  protocol.State
+	states protocol.StateHistory
+
+	// chat is the chat overlay, which is updated and drawn over the game
+	chat   *ChatOverlay
+	client *client.Client
+	// addr is the address of the server, which session tokens are saved against
+	addr string
+	// server is the hosted server instance, or nil if the game is a client of a remote server
+	server *server.Server
 }
 
 // GameType is used to differentiate between a client and server game instance.
@@ -42,28 +67,37 @@ const (
 	Server GameType = "server"
 )
 
-// NewGame creates and initialises a new Game layer.
-func NewGame(gameType GameType, addr string, playerName string) (game *Game, err error) {
+// NewGame creates and initialises a new Game layer. If a server is provided, the game hosts it and is responsible for
+// shutting it down. If no password is provided, the session token saved from the player's last
+// connection to the server is used instead.
+func NewGame(host *server.Server, addr, playerName, password string) (game *Game, err error) {
+	gameType := Client
+	if host != nil {
+		gameType = Server
+	}
+
 	// connect to server
-	if err = client.Start(addr); err != nil {
+	c, err := client.Start(addr)
+	if err != nil {
 		return nil, fmt.Errorf("client failed to start: %s", err)
 	}
 
-	client.Send(server.Message{
-		Type:  "connect",
-		Value: playerName,
-	})
+	req := protocol.Connect{
+		Username: playerName,
+		Password: password,
+		Version:  protocol.Version,
+		Codec:    protocol.BinaryCodecName,
+	}
+	if password == "" {
+		req.Token = client.SessionToken(addr, playerName)
+	}
+	c.Send(req)
 
 	// wait for register success
-	var (
-		seed, name string
-		pos        pixel.Vec
-		rot        float64
-		health     uint64
-	)
+	var welcome protocol.Welcome
 	// TODO: add a connect timeout
 	for {
-		msg, err := client.Poll()
+		msg, err := c.Poll()
 		if err != nil {
 			if err == client.ErrQueueClosed {
 				return nil, fmt.Errorf("failed to handshake with server: %s", err)
@@ -71,23 +105,20 @@ func NewGame(gameType GameType, addr string, playerName string) (game *Game, err
 			continue
 		}
 
-		switch msg.Type {
-		case "register_success", "connect_success":
-			data, err := msg.Unpack()
-			if err != nil {
-				return nil, fmt.Errorf("failed to unpack register_success message: %s", err)
+		switch data := msg.(type) {
+		case protocol.RegisterSuccess:
+			welcome = data.Welcome
+		case protocol.ConnectSuccess:
+			welcome = data.Welcome
+
+		case protocol.RegisterFailure:
+			return nil, errors.New(data.Reason)
+		case protocol.ConnectFailure:
+			// forget a rejected session token so that the password is used next time
+			if req.Token != "" {
+				client.SaveSessionToken(addr, playerName, "")
 			}
-
-			seed = data.GetString("seed")
-			name = data.GetString("name")
-			pos = data.Get("pos").(pixel.Vec)
-			rot = data.GetFloat("rot")
-			health = data.GetUInt("health")
-
-			fmt.Printf("new user with username: %s\n", name)
-
-		case "register_failure", "connect_failure":
-			return nil, errors.New(msg.Value)
+			return nil, errors.New(data.Reason)
 
 		default:
 			continue
@@ -95,6 +126,17 @@ func NewGame(gameType GameType, addr string, playerName string) (game *Game, err
 		break
 	}
 
+	// ensure the server agreed on a protocol version supported by this client
+	if _, err = protocol.Negotiate(welcome.Version); err != nil {
+		c.Disconnect()
+		return nil, fmt.Errorf("failed to handshake with server: %s", err)
+	}
+	slog.Info("joined game", "user", welcome.Player.Name)
+	if welcome.Token != "" {
+		client.SaveSessionToken(addr, playerName, welcome.Token)
+	}
+	seed := welcome.Seed
+
 	// parse seed into integer
 	var seedNum int64
 	for _, c := range seed {
@@ -102,7 +144,7 @@ func NewGame(gameType GameType, addr string, playerName string) (game *Game, err
 	}
 
 	// generate world
-	fmt.Printf("generating new world with a seed of \"%s\" (%d)\n", seed, seedNum)
+	slog.Info("generating world", "seed", seed, "seed_num", seedNum)
 	tileGrid := world.NewTileGrid(seedNum)
 	if err = tileGrid.GenerateChunk(); err != nil {
 		return nil, fmt.Errorf("failed to generate world: %s", err)
@@ -114,11 +156,11 @@ func NewGame(gameType GameType, addr string, playerName string) (game *Game, err
 	if err != nil {
 		return nil, fmt.Errorf("failed to create player: %s", err)
 	}
-	mainPlayer.SetPos(pos)
-	mainPlayer.SetOrientation(rot)
-	mainPlayer.SetHealth(health)
+	mainPlayer.SetPos(pixel.V(welcome.Player.X, welcome.Player.Y))
+	mainPlayer.SetOrientation(welcome.Player.Rot)
+	mainPlayer.SetHealth(welcome.Player.Health)
 
-	player.InitArmoury()
+	player.InitArmoury(c)
 
 	// create new game instance
 	game = &Game{
@@ -130,6 +172,11 @@ func NewGame(gameType GameType, addr string, playerName string) (game *Game, err
 		camPos:     mainPlayer.Pos(),
 		camScale:   0.5,
 		exitCh:     make(chan struct{}, 1),
+		predictor:  sim.NewPredictor(welcome.Player.X, welcome.Player.Y),
+		chat:       NewChatOverlay(c, playerName),
+		client:     c,
+		addr:       addr,
+		server:     host,
 	}
 
 	// receive and process incoming requests from the server
@@ -150,7 +197,7 @@ func (g *Game) processServerUpdates() {
 		}
 
 		// poll for updates from the server
-		msg, err := client.Poll()
+		msg, err := g.client.Poll()
 		if err != nil {
 			if err == client.ErrQueueClosed {
 				g.Disconnect()
@@ -158,118 +205,202 @@ func (g *Game) processServerUpdates() {
 			continue
 		}
 
-		switch msg.Type {
-		// update a player's position and orientation
-		case "vitals_server":
-			data, err := msg.Unpack()
+		switch data := msg.(type) {
+		// update every player's position and orientation, and the projectiles fired by other players
+		case protocol.Snapshot:
+			// discard snapshots which arrive after a newer one
+			if data.Tick <= g.state.Tick {
+				break
+			}
+			state, err := g.states.Apply(data)
 			if err != nil {
-				fmt.Printf("failed to split vitals request: %s\n", err)
+				slog.Warn("failed to apply snapshot", "tick", data.Tick, "err", err)
 				break
 			}
+			g.client.Send(protocol.SnapshotAck{Tick: state.Tick})
+			g.applyState(state)
 
-			// find player
-			p, err := g.players.Find(data.GetString("name"))
+		// player has been damaged by another player's projectile
+		case protocol.PlayerHit:
+			p, err := g.players.Find(data.Name)
 			if err != nil {
-				fmt.Printf("player doesn't exist: %s\n", err)
+				slog.Warn("player doesn't exist", "err", err)
 				break
 			}
-			p.SetPos(data.Get("pos").(pixel.Vec))
-			p.SetOrientation(data.GetFloat("rot"))
-			p.SetHealth(data.GetUInt("health"))
+			p.SetHealth(data.Health)
+			slog.Debug("player hit", "player", data.Name, "attacker", data.Attacker, "weapon", data.Weapon, "damage",
+				data.Damage)
 
-		// player has fired a projectile
-		case "create_projectile":
-			data, err := msg.Unpack()
+		// player has been killed and will respawn after a delay
+		case protocol.PlayerDied:
+			p, err := g.players.Find(data.Name)
 			if err != nil {
-				fmt.Printf("create_projectile message incorrectly formatted: %s\n", err)
+				slog.Warn("player doesn't exist", "err", err)
+				break
 			}
+			p.SetHealth(0)
+			if p == g.mainPlayer {
+				player.StopAttack()
+				slog.Info("killed, respawning", "killer", data.Killer, "delay", data.RespawnDelay)
+				break
+			}
+			slog.Info("player killed", "player", data.Name, "killer", data.Killer)
 
-			startPos := pixel.V(data.GetFloat("startX"), data.GetFloat("startY"))
-			vel := pixel.V(data.GetFloat("velX"), data.GetFloat("velY"))
-			player.NewProjectile(startPos, vel, data.GetTime("spawnTime"), data.GetDuration("ttl"))
-
-		// new player joined the game
-		case "user_joined":
-			if _, err := g.players.Add(msg.Value); err != nil {
-				fmt.Printf("failed to create user: %s", err)
+		// dead player has respawned elsewhere
+		case protocol.PlayerRespawned:
+			p, err := g.players.Find(data.Player.Name)
+			if err != nil {
+				slog.Warn("player doesn't exist", "err", err)
 				break
 			}
-			fmt.Println(msg.Value + " joined the game!")
-
-		// initialise world and already existing players after joining a new game
-		case "init_world":
-			// TODO: migrate into msg.Unpack()
-			fmt.Printf("init world request: %s\n", msg.Value)
-			items := strings.Split(msg.Value, "/")
-			for _, item := range items {
-				name, pos, rot, health, err := splitPosReq(item)
-				if err != nil {
-					fmt.Printf("failed to split vitals request: %s\n", err)
-					continue
-				}
+			pos := pixel.V(data.Player.X, data.Player.Y)
+			if p == g.mainPlayer {
+				// inputs sent while dead were never simulated, so restart prediction from the spawn point
+				g.teleportMainPlayer(pos)
+			} else {
+				p.Teleport(time.Now(), pos, data.Player.Rot)
+			}
+			p.SetHealth(data.Player.Health)
+			slog.Info("player respawned", "player", data.Player.Name)
+
+		// the client has reconnected after losing its connection
+		case protocol.ConnectSuccess:
+			g.resume(data.Welcome)
 
-				// add new player
-				p, err := g.players.Add(name)
-				if err != nil {
-					fmt.Printf("failed to add player \"%s\": %s\n", name, err)
-					continue
+		// the client failed to reconnect, e.g. because its session token has been replaced
+		case protocol.ConnectFailure:
+			slog.Warn("failed to reconnect to server", "reason", data.Reason)
+			client.SaveSessionToken(g.addr, g.mainPlayer.Name(), "")
+			g.Disconnect()
+
+		// chat message or reply to a chat command
+		case protocol.Chat:
+			g.chat.Add(data)
+
+		// new player joined the game
+		case protocol.UserJoined:
+			slog.Info("player joined the game", "player", data.Name)
+
+		// player has come within range and will be described by subsequent snapshots
+		case protocol.PlayerEntered:
+			// the player may already have been added if the client reconnected
+			p, err := g.players.Find(data.Player.Name)
+			if err != nil {
+				if p, err = g.players.Add(data.Player.Name); err != nil {
+					slog.Warn("failed to add player", "player", data.Player.Name, "err", err)
+					break
 				}
-				p.SetPos(pos)
-				p.SetOrientation(rot)
-				p.SetHealth(health)
 			}
+			p.AddSnapshot(time.Now(), pixel.V(data.Player.X, data.Player.Y), data.Player.Rot)
+			p.SetHealth(data.Player.Health)
+
+		// player has gone out of range
+		case protocol.PlayerLeft:
+			g.players.Remove(data.Name)
 
 		// remove a player from the game
-		case "disconnect":
-			fmt.Println(msg.Value + " left the game!")
-			g.players.Remove(msg.Value)
+		case protocol.Disconnect:
+			slog.Info("player left the game", "player", data.Name)
+			g.players.Remove(data.Name)
 
 		// server has initiated shutdown
-		case "server_shutdown":
+		case protocol.ServerShutdown:
+			g.Disconnect()
+
+		// server is about to disconnect us
+		case protocol.Kick:
+			slog.Warn("kicked from server", "reason", data.Reason)
 			g.Disconnect()
 		}
 	}
 }
 
-// process a vitals message from the server into its separate components
-func splitPosReq(val string) (name string, pos pixel.Vec, rot float64, health uint64, err error) {
-	components := strings.Split(val, "|")
-	fmt.Println(components)
-	if len(components) != 5 {
-		err = fmt.Errorf("incorrect vitals component count")
-		return
+// resume restores the game after the client has reconnected to the server. The server tells the client about the
+// players around it again, and restarts the player's armoury unless their previous session was resumed.
+func (g *Game) resume(welcome protocol.Welcome) {
+	slog.Info("reconnected to server", "resumed", welcome.Resumed)
+	if welcome.Token != "" {
+		client.SaveSessionToken(g.addr, g.mainPlayer.Name(), welcome.Token)
 	}
-	x, err := strconv.ParseFloat(components[1], 64)
-	if err != nil {
-		err = fmt.Errorf("failed to parse X: %s", err)
-		return
+
+	// inputs sent while disconnected were never simulated, so restart prediction from the server's position
+	g.teleportMainPlayer(pixel.V(welcome.Player.X, welcome.Player.Y))
+	g.mainPlayer.SetHealth(welcome.Player.Health)
+
+	g.players.RemoveOthers(g.mainPlayer.Name())
+	if !welcome.Resumed {
+		player.InitArmoury(g.client)
 	}
-	y, err := strconv.ParseFloat(components[2], 64)
-	if err != nil {
-		err = fmt.Errorf("failed to parse Y: %s", err)
+}
+
+// teleportMainPlayer restarts the main player's prediction from a position set by the server, and moves the player and
+// camera there on the next update.
+func (g *Game) teleportMainPlayer(pos pixel.Vec) {
+	g.predictor.Reset(pos.X, pos.Y)
+	g.teleportMu.Lock()
+	g.teleport = &pos
+	g.teleportMu.Unlock()
+}
+
+// applyTeleport moves the main player and camera to the position they were last teleported to, if they've been
+// teleported since the last update. It must only be called by Update.
+func (g *Game) applyTeleport() {
+	g.teleportMu.Lock()
+	pos := g.teleport
+	g.teleport = nil
+	g.teleportMu.Unlock()
+	if pos == nil {
 		return
 	}
-	rot, err = strconv.ParseFloat(components[3], 64)
+	g.mainPlayer.SetPos(*pos)
+	g.camPos = *pos
+}
+
+// applyState updates the game from the state of the world described by the latest snapshot.
+func (g *Game) applyState(state protocol.State) {
+	for _, vitals := range state.Players {
+		g.applyVitals(vitals)
+	}
+
+	// the main player's projectiles are predicted locally when fired
+	for id, p := range state.Projectiles {
+		if _, ok := g.state.Projectiles[id]; ok || p.Owner == g.mainPlayer.Name() {
+			continue
+		}
+		player.NewProjectile(id, pixel.V(p.StartX, p.StartY), pixel.V(p.VelX, p.VelY), p.Spawned(), p.TTL)
+	}
+	for id := range g.state.Projectiles {
+		if _, ok := state.Projectiles[id]; !ok {
+			player.RemoveProjectile(id)
+		}
+	}
+	g.state = state
+}
+
+// applyVitals updates a player's position, orientation and health from the authoritative state received from the
+// server.
+func (g *Game) applyVitals(vitals protocol.Vitals) {
+	// players are added when they enter the area of interest, which may be announced after the first snapshot
+	// containing them
+	p, err := g.players.Find(vitals.Name)
 	if err != nil {
-		err = fmt.Errorf("failed to parse rot: %s", err)
 		return
 	}
-	health, err = strconv.ParseUint(components[4], 10, 64)
-	if err != nil {
-		err = fmt.Errorf("failed to parse health: %s", err)
+	p.SetHealth(vitals.Health)
+	// the main player's position is predicted and orientation is controlled locally so that they're responsive
+	if p == g.mainPlayer {
+		p.SetPos(pixel.V(g.predictor.Reconcile(vitals)))
 		return
 	}
-	name = components[0]
-	pos = pixel.V(x, y)
-	return
+	// remote players are rendered by interpolating between received states
+	p.AddSnapshot(time.Now(), pixel.V(vitals.X, vitals.Y), vitals.Rot)
 }
 
 // Update updates the game layer logic.
 func (g *Game) Update(dt float64) {
-	if g.gameType == Server {
-		server.Update()
-	}
+	g.applyTeleport()
 	g.mainPlayer.Update(dt)
+	g.players.Interpolate(time.Now())
 
 	// things that shouldn't update when the overview menu is up should occur here
 	if g.locked {
@@ -291,18 +422,29 @@ func (g *Game) Update(dt float64) {
 		}
 	}
 
-	// handle keyboard input
+	// the chat takes over the keyboard while the player is typing, including on the update it is closed
+	typing := g.chat.Typing()
+	g.chat.Update(dt)
+	if typing || g.chat.Typing() {
+		return
+	}
+
+	// handle keyboard input - movement is predicted locally and simulated by the server
+	in := protocol.Input{
+		Fire:     win.Pressed(pixelgl.MouseButton1),
+		Duration: sim.InputDuration(dt),
+	}
 	if win.Pressed(pixelgl.KeyW) {
-		g.mainPlayer.Up(dt)
+		in.MoveY++
 	}
 	if win.Pressed(pixelgl.KeyS) {
-		g.mainPlayer.Down(dt)
+		in.MoveY--
 	}
 	if win.Pressed(pixelgl.KeyA) {
-		g.mainPlayer.Left(dt)
+		in.MoveX--
 	}
 	if win.Pressed(pixelgl.KeyD) {
-		g.mainPlayer.Right(dt)
+		in.MoveX++
 	}
 	if win.Pressed(pixelgl.KeyUp) {
 		if g.camScale < 1.2 {
@@ -347,13 +489,21 @@ func (g *Game) Update(dt float64) {
 	}
 	g.prevMousePos = win.MousePosition()
 
-	// send pos & orientation update to server
-	if g.mainPlayer.HasMoved() {
-		pos := g.mainPlayer.Pos()
-		client.Send(server.Message{
-			Type:  "vitals_client",
-			Value: server.ConcatVitals(pos.X, pos.Y, g.mainPlayer.Orientation(), g.mainPlayer.Health()),
-		})
+	// send input command to the server if moving or if the movement/aim/trigger has changed - the input after stopping
+	// is also sent so that other clients receive the final resting position
+	in.Aim = g.mainPlayer.Orientation()
+	moving := in.MoveX != 0 || in.MoveY != 0
+	changed := in.MoveX != g.lastInput.MoveX || in.MoveY != g.lastInput.MoveY || in.Aim != g.lastInput.Aim ||
+		in.Fire != g.lastInput.Fire
+	// dead players can't act until they respawn
+	if !g.mainPlayer.Dead() && (moving || changed) {
+		g.inputSeq++
+		in.Seq = g.inputSeq
+		g.client.Send(in)
+		g.lastInput = in
+
+		// apply the input locally rather than waiting a round-trip for the server state
+		g.mainPlayer.SetPos(pixel.V(g.predictor.Apply(in)))
 	}
 
 	switch {
@@ -389,17 +539,19 @@ func (g *Game) Draw() {
 	g.players.Draw(win)
 	// draw projectiles
 	player.DrawProjectiles(win)
+	// draw chat over the world
+	g.chat.Draw()
 }
 
 // Disconnect triggers a client disconnect, followed by a server shutdown if a server is being hosted. The main menu is
 // then displayed.
 func (g *Game) Disconnect() {
 	// disconnect local client before shutting down server
-	client.Disconnect()
+	g.client.Disconnect()
 	g.exitCh <- struct{}{}
 
-	if g.gameType == Server {
-		server.Shutdown()
+	if g.server != nil {
+		g.server.Shutdown()
 	}
 	Pop(Default)
 	Push(NewMainMenu())
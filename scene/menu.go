@@ -1,13 +1,17 @@
 package scene
 
 import (
+	"crypto/tls"
 	"fmt"
+	"log/slog"
 	"strconv"
 
 	"github.com/faiface/pixel"
 	"github.com/faiface/pixel/pixelgl"
 	"golang.org/x/image/colornames"
 
+	"github.com/jemgunay/procedural-game/client"
+	"github.com/jemgunay/procedural-game/protocol"
 	"github.com/jemgunay/procedural-game/scene/ui"
 	"github.com/jemgunay/procedural-game/server"
 )
@@ -80,7 +84,11 @@ type CreateGameMenu struct {
 	seedTextInput       *ui.TextBox
 	portTextInput       *ui.TextBox
 	playerNameTextInput *ui.TextBox
+	passwordTextInput   *ui.TextBox
+	tlsBtn              *ui.Button
 	startBtn            *ui.Button
+
+	useTLS bool
 }
 
 // NewCreateGameMenu creates and initialises a new CreateGameMenu layer.
@@ -94,13 +102,17 @@ func NewCreateGameMenu() *CreateGameMenu {
 		seedTextInput:       ui.NewTextBox("World Seed", colornames.White, colornames.Black),
 		portTextInput:       ui.NewTextBox("Port", colornames.White, colornames.Black),
 		playerNameTextInput: ui.NewTextBox("Player Name", colornames.White, colornames.Black),
+		passwordTextInput:   ui.NewTextBox("Password", colornames.White, colornames.Black),
+		tlsBtn:              ui.NewButton("TLS: Off", ui.Red, colornames.White),
 		startBtn:            ui.NewButton("Start", ui.Green, colornames.White),
 	}
 	menu.portTextInput.SetText("9000")
 	menu.portTextInput.SetMaxLength(5)
 	menu.playerNameTextInput.SetMaxLength(server.MaxUsernameLength)
+	menu.passwordTextInput.SetMasked(true)
 
-	container.AddElement(menu.backBtn, menu.seedTextInput, menu.portTextInput, menu.playerNameTextInput, menu.startBtn)
+	container.AddElement(menu.backBtn, menu.seedTextInput, menu.portTextInput, menu.playerNameTextInput,
+		menu.passwordTextInput, menu.tlsBtn, menu.startBtn)
 	return menu
 }
 
@@ -111,26 +123,61 @@ func (m *CreateGameMenu) Update(dt float64) {
 		Pop(Default)
 		Push(NewMainMenu())
 
+	case m.tlsBtn.Clicked():
+		m.useTLS = !m.useTLS
+		if m.useTLS {
+			m.tlsBtn.SetLabel("TLS: On")
+		} else {
+			m.tlsBtn.SetLabel("TLS: Off")
+		}
+
 	case m.startBtn.Clicked():
 		// parse seed into integer
 		seedInput := m.seedTextInput.Text()
 		portInput, err := strconv.ParseUint(m.portTextInput.Text(), 10, 64)
 		if err != nil {
-			fmt.Println("invalid port provided")
+			slog.Warn("invalid port provided", "port", m.portTextInput.Text())
 			return
 		}
+		addr := fmt.Sprintf(":%d", portInput)
+
+		// use a self-signed certificate for LAN play - joining players pin it on first use
+		var tlsConfig *tls.Config
+		if m.useTLS {
+			if tlsConfig, err = server.TLSConfig(server.DefaultCertFile, server.DefaultKeyFile); err != nil {
+				slog.Error("failed to configure TLS", "err", err)
+				return
+			}
+		}
 
 		// start server
-		if err = server.Start(fmt.Sprintf(":%d", portInput), seedInput); err != nil {
-			fmt.Printf("server failed to start: %s\n", err)
+		srv, err := server.New(seedInput, server.DefaultStatePath, server.DefaultTickRate)
+		if err != nil {
+			slog.Error("server failed to start", "err", err)
 			return
 		}
+		if err = srv.Listen(addr, tlsConfig); err != nil {
+			slog.Error("server failed to start", "err", err)
+			srv.Shutdown()
+			return
+		}
+		// the host administers their own server, which is advertised to players browsing the LAN
+		srv.SetAdmins(m.playerNameTextInput.Text())
+		discoveryAddr := fmt.Sprintf(":%d", protocol.DiscoveryPort)
+		if err = srv.ListenDiscovery(discoveryAddr, m.playerNameTextInput.Text()+"'s game"); err != nil {
+			slog.Warn("game won't be discoverable on the LAN", "err", err)
+		}
+		srv.Start()
+		if m.useTLS {
+			addr = client.TLSScheme + addr
+		}
 
 		// create a new game layer
-		gameLayer, err := NewGame(Server, fmt.Sprintf(":%d", portInput), m.playerNameTextInput.Text())
+		gameLayer, err := NewGame(srv, addr, m.playerNameTextInput.Text(),
+			m.passwordTextInput.Text())
 		if err != nil {
-			fmt.Printf("failed to create game layer: %s\n", err)
-			server.Shutdown()
+			slog.Error("failed to create game layer", "err", err)
+			srv.Shutdown()
 			return
 		}
 
@@ -148,13 +195,25 @@ func (m *CreateGameMenu) Draw() {
 	m.uiContainer.Draw(win)
 }
 
-// JoinGameMenu is the menu layer for joining an existing game.
+// serverListInterval is how often, in seconds, the list of servers discovered on the LAN is redrawn.
+const serverListInterval = 0.25
+
+// JoinGameMenu is the menu layer for joining an existing game, either by address or from the list of servers
+// discovered on the LAN.
 type JoinGameMenu struct {
 	uiContainer         *ui.ScrollContainer
 	backBtn             *ui.Button
 	hostAddrTextInput   *ui.TextBox
 	playerNameTextInput *ui.TextBox
+	passwordTextInput   *ui.TextBox
 	joinBtn             *ui.Button
+	refreshBtn          *ui.Button
+
+	// browser is nil if browsing the LAN failed, and serverBtns are the buttons joining the servers it has discovered,
+	// by address
+	browser         *client.Browser
+	serverBtns      map[string]*ui.Button
+	sinceServerList float64
 }
 
 // NewJoinGameMenu creates and initialises a new JoinGameMenu layer.
@@ -165,35 +224,113 @@ func NewJoinGameMenu() *JoinGameMenu {
 	menu := &JoinGameMenu{
 		uiContainer:         container,
 		backBtn:             ui.NewButton("Back", ui.Blue, colornames.White),
-		hostAddrTextInput:   ui.NewTextBox("Server Address", colornames.White, colornames.Black),
+		hostAddrTextInput:   ui.NewTextBox("Server Address (prefix tls:// or udp://)", colornames.White, colornames.Black),
 		playerNameTextInput: ui.NewTextBox("Player Name", colornames.White, colornames.Black),
+		passwordTextInput:   ui.NewTextBox("Password (blank to reuse session)", colornames.White, colornames.Black),
 		joinBtn:             ui.NewButton("Join", ui.Green, colornames.White),
+		refreshBtn:          ui.NewButton("Refresh LAN Games", ui.Orange, colornames.White),
+		serverBtns:          make(map[string]*ui.Button),
 	}
 	menu.hostAddrTextInput.SetText("localhost:9000")
 	menu.playerNameTextInput.SetMaxLength(server.MaxUsernameLength)
+	menu.passwordTextInput.SetMasked(true)
+
+	var err error
+	if menu.browser, err = client.Browse(); err != nil {
+		slog.Warn("failed to browse for LAN games", "err", err)
+	}
 
-	container.AddElement(menu.backBtn, menu.hostAddrTextInput, menu.playerNameTextInput, menu.joinBtn)
+	container.AddElement(menu.backBtn, menu.hostAddrTextInput, menu.playerNameTextInput, menu.passwordTextInput,
+		menu.joinBtn, menu.refreshBtn)
 	return menu
 }
 
 // Update updates the game join menu layer logic.
 func (m *JoinGameMenu) Update(dt float64) {
+	m.sinceServerList += dt
+	if m.sinceServerList >= serverListInterval {
+		m.sinceServerList = 0
+		m.listServers()
+	}
+
 	switch {
 	case win.JustPressed(pixelgl.KeyEscape), m.backBtn.Clicked():
+		m.closeBrowser()
 		Pop(Default)
 		Push(NewMainMenu())
 
 	case m.joinBtn.Clicked():
-		// create a new game layer
-		gameLayer, err := NewGame(Client, m.hostAddrTextInput.Text(), m.playerNameTextInput.Text())
-		if err != nil {
-			fmt.Printf("failed to create game layer: %s\n", err)
-			return
+		m.join()
+
+	case m.refreshBtn.Clicked():
+		if m.browser != nil {
+			m.browser.Refresh()
 		}
 
-		// pop main menu and push game layer
-		Pop(Default)
-		Push(gameLayer)
+	default:
+		// join a discovered server on click
+		for addr, btn := range m.serverBtns {
+			if btn.Clicked() {
+				m.hostAddrTextInput.SetText(addr)
+				m.join()
+				return
+			}
+		}
+	}
+}
+
+// join joins the game at the address entered.
+func (m *JoinGameMenu) join() {
+	// create a new game layer
+	gameLayer, err := NewGame(nil, m.hostAddrTextInput.Text(), m.playerNameTextInput.Text(),
+		m.passwordTextInput.Text())
+	if err != nil {
+		slog.Error("failed to create game layer", "err", err)
+		return
+	}
+
+	// pop main menu and push game layer
+	m.closeBrowser()
+	Pop(Default)
+	Push(gameLayer)
+}
+
+// listServers updates the list of buttons joining the servers discovered on the LAN. Servers which don't support this
+// client's protocol version are listed, but can't be joined.
+func (m *JoinGameMenu) listServers() {
+	if m.browser == nil {
+		return
+	}
+
+	elements := []ui.Drawer{m.backBtn, m.hostAddrTextInput, m.playerNameTextInput, m.passwordTextInput, m.joinBtn,
+		m.refreshBtn}
+	serverBtns := make(map[string]*ui.Button)
+	for _, discovered := range m.browser.Servers() {
+		btn, ok := m.serverBtns[discovered.Addr]
+		if !ok {
+			btn = ui.NewButton("", ui.Blue, colornames.White)
+		}
+		label := fmt.Sprintf("%s (%s) - %d players - %dms - seed %q", discovered.Name, discovered.Addr,
+			discovered.Players, discovered.Ping.Milliseconds(), discovered.Seed)
+		// a server's version can change between responses, e.g. if it restarts after being upgraded
+		compatible := discovered.Compatible()
+		if !compatible {
+			label += " - incompatible version"
+		}
+		btn.SetEnabled(compatible)
+		btn.SetLabel(label)
+
+		serverBtns[discovered.Addr] = btn
+		elements = append(elements, btn)
+	}
+	m.serverBtns = serverBtns
+	m.uiContainer.SetElements(elements...)
+}
+
+// closeBrowser stops browsing for servers once the menu is left.
+func (m *JoinGameMenu) closeBrowser() {
+	if m.browser != nil {
+		m.browser.Close()
 	}
 }
 
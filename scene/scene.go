@@ -2,7 +2,7 @@
 package scene
 
 import (
-	"fmt"
+	"log/slog"
 	"time"
 
 	"github.com/faiface/pixel"
@@ -37,19 +37,19 @@ func Start() {
 	var err error
 	win, err = pixelgl.NewWindow(cfg)
 	if err != nil {
-		fmt.Printf("failed create new window: %s\n", err)
+		slog.Error("failed to create window", "err", err)
 		return
 	}
 
 	// create shaders
 	world.DefaultShader, err = file.NewDefaultFragShader()
 	if err != nil {
-		fmt.Printf("failed create new window: %s\n", err)
+		slog.Error("failed to create default shader", "err", err)
 		return
 	}
 	world.WavyShader, err = file.NewWavyFragShader(5)
 	if err != nil {
-		fmt.Printf("failed create new window: %s\n", err)
+		slog.Error("failed to create wavy shader", "err", err)
 		return
 	}
 
@@ -3,6 +3,7 @@ package ui
 
 import (
 	"image/color"
+	"strings"
 	"time"
 
 	"github.com/faiface/pixel"
@@ -119,6 +120,11 @@ func (c *ScrollContainer) AddElement(element ...Drawer) {
 	c.elements = append(element, c.elements...)
 }
 
+// SetElements replaces the ScrollContainer elements stack, e.g. to update a list of elements which changes over time.
+func (c *ScrollContainer) SetElements(element ...Drawer) {
+	c.elements = element
+}
+
 // Draw draws the ScrollContainer and its contents.
 func (c *ScrollContainer) Draw(win *pixelgl.Window) {
 	bounds := c.boundsFunc()
@@ -272,11 +278,21 @@ func (b *Button) Enabled() bool {
 	return b.enabled
 }
 
+// SetLabel sets the button's label.
+func (b *Button) SetLabel(label string) {
+	b.label = label
+}
+
 // ToggleEnabled toggles the button's enabled state.
 func (b *Button) ToggleEnabled() {
 	b.enabled = !b.enabled
 }
 
+// SetEnabled sets the button's enabled state.
+func (b *Button) SetEnabled(enabled bool) {
+	b.enabled = enabled
+}
+
 // Clicked can be used to poll a button to determine if it has been clicked since the last check.
 func (b *Button) Clicked() bool {
 	if !b.enabled {
@@ -344,7 +360,9 @@ const textBoxTickerDuration = time.Millisecond * 600
 // TextBox is a text input box UI element.
 type TextBox struct {
 	hasFocus  bool
+	submitted bool
 	maxLength int
+	masked    bool
 
 	bgColour      pixel.RGBA
 	label         string
@@ -413,6 +431,7 @@ func (t *TextBox) Draw(win *pixelgl.Window, bounds pixel.Rect) {
 		// lose text input focus on enter key press
 		case win.JustPressed(pixelgl.KeyEnter), win.Repeated(pixelgl.KeyEnter):
 			t.hasFocus = false
+			t.submitted = true
 			t.setCursorState("")
 
 		// delete character on backspace key press
@@ -438,7 +457,11 @@ func (t *TextBox) Draw(win *pixelgl.Window, bounds pixel.Rect) {
 	// text
 	inputText := text.New(pixel.ZV, basicFontAtlas)
 	inputText.Color = t.textColour
-	inputText.WriteString(t.text)
+	if t.masked {
+		inputText.WriteString(strings.Repeat("*", len(t.text)))
+	} else {
+		inputText.WriteString(t.text)
+	}
 	inputText.WriteString(t.cursorChar)
 
 	labelHeight = bounds.H() * 0.7
@@ -468,6 +491,37 @@ func (t *TextBox) SetText(text string) {
 	t.text = text
 }
 
+// SetLabel sets the text box's label.
+func (t *TextBox) SetLabel(label string) {
+	t.label = label
+}
+
+// Focused indicates whether the text box has input focus.
+func (t *TextBox) Focused() bool {
+	return t.hasFocus
+}
+
+// SetFocus gives or takes away the text box's input focus.
+func (t *TextBox) SetFocus(focus bool) {
+	t.hasFocus = focus
+	if focus {
+		t.setCursorState("|")
+	} else {
+		t.setCursorState("")
+	}
+}
+
+// Submitted can be used to poll a text box to determine if enter has been pressed to submit its input since the last
+// check.
+func (t *TextBox) Submitted() bool {
+	if t.submitted {
+		// reset submitted value once polled
+		t.submitted = false
+		return true
+	}
+	return false
+}
+
 // MaxLength returns the maximum number of characters that can be typed into the text field.
 func (t *TextBox) MaxLength() int {
 	return t.maxLength
@@ -478,6 +532,11 @@ func (t *TextBox) SetMaxLength(length int) {
 	t.maxLength = length
 }
 
+// SetMasked sets whether the text field's input is hidden, e.g. for passwords.
+func (t *TextBox) SetMasked(masked bool) {
+	t.masked = masked
+}
+
 // applies an alpha value to the provided colour
 func fadeColour(colour color.Color, alpha float64) pixel.RGBA {
 	c := pixel.ToRGBA(colour)
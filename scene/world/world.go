@@ -4,6 +4,7 @@ package world
 import (
 	"fmt"
 	"image/color"
+	"log/slog"
 	"math/rand"
 	"sort"
 	"sync"
@@ -12,11 +13,11 @@ import (
 	"github.com/faiface/pixel"
 	"github.com/faiface/pixel/pixelgl"
 	"github.com/jemgunay/procedural-game/file"
+	"github.com/jemgunay/procedural-game/sim"
 )
 
 const (
-	// just greater than 200 to overlap, preventing stitching glitch
-	tileSize            = 201
+	tileSize            = sim.TileSize
 	tileSizeSpriteScale = 2.0
 	chunkSize           = 50
 
@@ -181,7 +182,7 @@ func (g *TileGrid) GenerateChunk() error {
 			}
 		}
 	}
-	fmt.Printf("Min Z: %v\nMax Z: %v\n", minZ, maxZ)
+	slog.Debug("generated tiles", "min_z", minZ, "max_z", maxZ)
 
 	// find points at the grass peaks (tiles where all neighbours have a smaller Z value)
 	var peakTiles []*Tile
@@ -0,0 +1,268 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/jemgunay/procedural-game/sim"
+)
+
+// UserInfo describes a user to the server's administrators.
+type UserInfo struct {
+	Name   string `json:"name"`
+	Online bool   `json:"online"`
+	// IP is the IP address the user is connected from, or empty if they're offline.
+	IP     string  `json:"ip,omitempty"`
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Health uint64  `json:"health"`
+	// RTT is the round trip time of the user's connection, or zero if they're offline or it hasn't been measured yet.
+	RTT    time.Duration `json:"rtt,omitempty"`
+	Admin  bool          `json:"admin,omitempty"`
+	Banned bool          `json:"banned,omitempty"`
+}
+
+// callErr runs f on the simulation goroutine like call, returning the error returned by f.
+func (s *Server) callErr(f func() error) error {
+	var err error
+	if callErr := s.call(func() { err = f() }); callErr != nil {
+		return callErr
+	}
+	return err
+}
+
+// onlineUser gets a connected user by name.
+func (s *Server) onlineUser(name string) (User, error) {
+	user, ok := s.userDB.Get(name)
+	if !ok {
+		return User{}, fmt.Errorf("no user named %s", name)
+	}
+	if user.conn == nil {
+		return User{}, fmt.Errorf("%s isn't online", name)
+	}
+	return user, nil
+}
+
+// Users returns a description of every user, sorted by name.
+func (s *Server) Users() ([]UserInfo, error) {
+	var users []UserInfo
+	err := s.call(func() {
+		for _, user := range s.userDB.users {
+			_, admin := s.admins[user.name]
+			_, banned := s.bans.nameBanned(user.name)
+			info := UserInfo{
+				Name:   user.name,
+				Online: user.conn != nil,
+				X:      user.x,
+				Y:      user.y,
+				Health: user.health,
+				Admin:  admin,
+				Banned: banned,
+			}
+			if info.Online {
+				info.IP = remoteIP(user.conn.RemoteAddr())
+				info.RTT = user.rtt
+			}
+			users = append(users, info)
+		}
+	})
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Name < users[j].Name
+	})
+	return users, err
+}
+
+// Kick kicks a connected user from the server, telling them the reason.
+func (s *Server) Kick(name, reason string) error {
+	return s.callErr(func() error {
+		return s.kickUser(name, reason, time.Now().UTC())
+	})
+}
+
+// kickUser kicks a connected user at time now.
+func (s *Server) kickUser(name, reason string, now time.Time) error {
+	user, err := s.onlineUser(name)
+	if err != nil {
+		return err
+	}
+	slog.Info("kicking user", "user", name, "reason", reason)
+	s.kick(user, reason, now)
+	return nil
+}
+
+// Ban bans a username from the server, kicking the user if they're connected. Usernames which haven't been
+// registered yet can also be banned.
+func (s *Server) Ban(name, reason string) error {
+	return s.callErr(func() error {
+		if name == "" {
+			return errors.New("no username provided")
+		}
+		s.bans.Names[name] = reason
+		slog.Info("banned user", "user", name, "reason", reason)
+		if user, err := s.onlineUser(name); err == nil {
+			s.kick(user, "banned: "+reason, time.Now().UTC())
+		}
+		return nil
+	})
+}
+
+// Unban lifts the ban on a username.
+func (s *Server) Unban(name string) error {
+	return s.callErr(func() error {
+		if _, ok := s.bans.nameBanned(name); !ok {
+			return fmt.Errorf("%s isn't banned", name)
+		}
+		delete(s.bans.Names, name)
+		slog.Info("unbanned user", "user", name)
+		return nil
+	})
+}
+
+// BanIP bans an IP address from the server, kicking any users connected from it.
+func (s *Server) BanIP(ip, reason string) error {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return fmt.Errorf("invalid IP address: %s", ip)
+	}
+	ip = parsed.String()
+	return s.call(func() {
+		s.bans.IPs[ip] = reason
+		slog.Info("banned IP address", "ip", ip, "reason", reason)
+		now := time.Now().UTC()
+		for _, user := range s.userDB.users {
+			if user.conn != nil && remoteIP(user.conn.RemoteAddr()) == ip {
+				s.kick(user, "banned: "+reason, now)
+			}
+		}
+	})
+}
+
+// UnbanIP lifts the ban on an IP address.
+func (s *Server) UnbanIP(ip string) error {
+	if parsed := net.ParseIP(ip); parsed != nil {
+		ip = parsed.String()
+	}
+	return s.callErr(func() error {
+		if _, ok := s.bans.ipBanned(ip); !ok {
+			return fmt.Errorf("%s isn't banned", ip)
+		}
+		delete(s.bans.IPs, ip)
+		slog.Info("unbanned IP address", "ip", ip)
+		return nil
+	})
+}
+
+// Bans returns the banned usernames and IP addresses, along with the reasons they were banned.
+func (s *Server) Bans() (names, ips map[string]string, err error) {
+	var bans banList
+	err = s.call(func() {
+		bans = s.bans.save()
+	})
+	return bans.Names, bans.IPs, err
+}
+
+// Teleport moves a connected user to a position.
+func (s *Server) Teleport(name string, x, y float64) error {
+	return s.callErr(func() error {
+		return s.teleportUser(name, x, y)
+	})
+}
+
+// teleportUser moves a connected user to a position, which must be finite.
+func (s *Server) teleportUser(name string, x, y float64) error {
+	if math.IsNaN(x) || math.IsInf(x, 0) || math.IsNaN(y) || math.IsInf(y, 0) {
+		return errors.New("position must be finite")
+	}
+	if _, err := s.onlineUser(name); err != nil {
+		return err
+	}
+	slog.Info("teleporting user", "user", name, "x", x, "y", y)
+	s.userDB.Teleport(name, x, y)
+	return nil
+}
+
+// SetHealth sets the health of a connected user who is alive, which is sent to clients in the next snapshot.
+func (s *Server) SetHealth(name string, health uint64) error {
+	return s.callErr(func() error {
+		if health == 0 || health > sim.MaxHealth {
+			return fmt.Errorf("health must be between 1 and %d", sim.MaxHealth)
+		}
+		user, err := s.onlineUser(name)
+		if err != nil {
+			return err
+		}
+		if user.dead() {
+			return fmt.Errorf("%s is dead", name)
+		}
+		slog.Info("setting user health", "user", name, "health", health)
+		user.health = health
+		s.userDB.Update(user)
+		return nil
+	})
+}
+
+// SetPassword sets a user's password, revoking their session token. This is the only way for users without a
+// password, such as those restored from state saved before passwords were required, to connect again.
+func (s *Server) SetPassword(name, password string) error {
+	// hashing is deliberately slow, so it isn't done on the simulation goroutine
+	cred, err := newCredential(password)
+	if err != nil {
+		return err
+	}
+	return s.callErr(func() error {
+		if err := s.userDB.SetPassword(name, cred); err != nil {
+			return err
+		}
+		slog.Info("set user password", "user", name)
+		return nil
+	})
+}
+
+// Announce broadcasts an announcement to every connected user's chat.
+func (s *Server) Announce(text string) error {
+	text = sanitiseChat(text)
+	if text == "" {
+		return errors.New("no announcement provided")
+	}
+	return s.call(func() {
+		slog.Info("announcement", "text", text)
+		s.userDB.Broadcast(systemChat("announcement: %s", text))
+	})
+}
+
+// SetTickRate changes the number of simulation steps per second, which must be between 1 and MaxTickRate. It takes
+// effect from the next step.
+func (s *Server) SetTickRate(tickRate uint) error {
+	interval, err := tickInterval(tickRate)
+	if err != nil {
+		return err
+	}
+	return s.call(func() {
+		slog.Info("changing tick rate", "hertz", tickRate)
+		s.tickInterval = interval
+		if s.ticker != nil {
+			s.ticker.Reset(s.tickInterval)
+		}
+	})
+}
+
+// Save saves the server state immediately rather than waiting for the next periodic save.
+func (s *Server) Save() error {
+	if s.statePath == "" {
+		return errors.New("persistence is disabled")
+	}
+	var state savedState
+	if err := s.call(func() { state = s.savedState() }); err != nil {
+		return err
+	}
+	if err := s.saveState(state); err != nil {
+		return err
+	}
+	slog.Info("saved server state", "path", s.statePath)
+	return nil
+}
@@ -0,0 +1,190 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jemgunay/procedural-game/client"
+	"github.com/jemgunay/procedural-game/protocol"
+)
+
+// TestAdmin checks that users can be managed through the admin API and console, and that banned users can't connect.
+func TestAdmin(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	srv, err := New("test-seed", statePath, DefaultTickRate)
+	if err != nil {
+		t.Fatalf("failed to create server: %s", err)
+	}
+	transport := protocol.NewPipeTransport()
+	l, err := transport.Listen("server")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+	srv.Serve(l)
+	defer srv.Shutdown()
+	const token = "admin-token"
+	api := httptest.NewServer(loopbackOnly(srv.AdminHandler(token)))
+	defer api.Close()
+
+	if err := srv.ListenAdmin("0.0.0.0:0", token); err == nil {
+		t.Error("expected admin API to refuse to listen on a non-loopback address")
+	}
+	if err := srv.ListenAdmin("127.0.0.1:0", ""); err == nil {
+		t.Error("expected admin API to refuse to listen without a token")
+	}
+
+	// connect connects alice, returning the reply to her connection request
+	connect := func() (*client.Client, protocol.Payload) {
+		t.Helper()
+		c, err := client.Connect(transport, "server")
+		if err != nil {
+			t.Fatalf("failed to connect: %s", err)
+		}
+		c.Send(protocol.Connect{Username: "alice", Password: "password", Version: protocol.Version})
+		return c, waitFor(t, c, func(p protocol.Payload) bool {
+			switch p.(type) {
+			case protocol.RegisterSuccess, protocol.RegisterFailure, protocol.ConnectSuccess, protocol.ConnectFailure:
+				return true
+			}
+			return false
+		})
+	}
+	c, reply := connect()
+	defer c.Disconnect()
+	if _, ok := reply.(protocol.RegisterSuccess); !ok {
+		t.Fatalf("failed to register: %+v", reply)
+	}
+
+	// do makes an API request with the provided headers, checking the response status
+	do := func(method, path string, body []byte, header http.Header, wantStatus int) *http.Response {
+		t.Helper()
+		r, err := http.NewRequest(method, api.URL+path, bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("failed to create request: %s", err)
+		}
+		r.Header = header
+		if host := header.Get("Host"); host != "" {
+			r.Host = host
+		}
+		resp, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatalf("%s %s failed: %s", method, path, err)
+		}
+		if resp.StatusCode != wantStatus {
+			var apiErr adminError
+			json.NewDecoder(resp.Body).Decode(&apiErr)
+			t.Errorf("%s %s: got status %d (%s), want %d", method, path, resp.StatusCode, apiErr.Error,
+				wantStatus)
+		}
+		return resp
+	}
+	authorised := func() http.Header {
+		return http.Header{"Authorization": {"Bearer " + token}, "Content-Type": {"application/json"}}
+	}
+	post := func(path string, req adminRequest, wantStatus int) {
+		t.Helper()
+		body, _ := json.Marshal(req)
+		do(http.MethodPost, path, body, authorised(), wantStatus).Body.Close()
+	}
+	getAlice := func() UserInfo {
+		t.Helper()
+		resp := do(http.MethodGet, "/users", nil, authorised(), http.StatusOK)
+		defer resp.Body.Close()
+		var users []UserInfo
+		if err := json.NewDecoder(resp.Body).Decode(&users); err != nil {
+			t.Fatalf("failed to decode users: %s", err)
+		}
+		if len(users) != 1 || users[0].Name != "alice" {
+			t.Fatalf("unexpected users: %+v", users)
+		}
+		return users[0]
+	}
+
+	// requests which a web page could make are refused, i.e. those without the token, those which aren't JSON and
+	// those for another host name which has been rebound to a loopback address
+	refuseKick := func(header http.Header, wantStatus int) {
+		t.Helper()
+		body, _ := json.Marshal(adminRequest{Name: "alice"})
+		do(http.MethodPost, "/kick", body, header, wantStatus).Body.Close()
+	}
+	refuseKick(http.Header{"Content-Type": {"application/json"}}, http.StatusUnauthorized)
+	refuseKick(http.Header{"Authorization": {"Bearer wrong"}, "Content-Type": {"application/json"}},
+		http.StatusUnauthorized)
+	form := authorised()
+	form.Set("Content-Type", "application/x-www-form-urlencoded")
+	refuseKick(form, http.StatusUnsupportedMediaType)
+	rebound := authorised()
+	rebound.Set("Host", "attacker.example.com:9001")
+	refuseKick(rebound, http.StatusForbidden)
+	if alice := getAlice(); !alice.Online {
+		t.Fatal("expected alice to still be online after refused kicks")
+	}
+
+	post("/teleport", adminRequest{Name: "alice", X: 100, Y: -200}, http.StatusNoContent)
+	post("/health", adminRequest{Name: "alice", Health: 50}, http.StatusNoContent)
+	post("/health", adminRequest{Name: "alice", Health: 0}, http.StatusBadRequest)
+	post("/teleport", adminRequest{Name: "bobby"}, http.StatusBadRequest)
+	if alice := getAlice(); !alice.Online || alice.X != 100 || alice.Y != -200 || alice.Health != 50 {
+		t.Errorf("unexpected user after teleport and set health: %+v", alice)
+	}
+
+	post("/announce", adminRequest{Text: "restarting soon"}, http.StatusNoContent)
+	waitFor(t, c, func(p protocol.Payload) bool {
+		return p == systemChat("announcement: restarting soon")
+	})
+
+	post("/tick-rate", adminRequest{TickRate: 30}, http.StatusNoContent)
+	post("/tick-rate", adminRequest{TickRate: 2e9}, http.StatusBadRequest)
+	srv.call(func() {
+		if srv.tickInterval != time.Second/30 {
+			t.Errorf("got tick interval %s after changing the tick rate to 30 Hz", srv.tickInterval)
+		}
+	})
+
+	// banned users are kicked and can't connect again until they're unbanned
+	post("/ban", adminRequest{Name: "alice", Reason: "cheating"}, http.StatusNoContent)
+	waitFor(t, c, func(p protocol.Payload) bool {
+		return p == protocol.Kick{Reason: "banned: cheating"}
+	})
+	banned, reply := connect()
+	defer banned.Disconnect()
+	if failure, ok := reply.(protocol.ConnectFailure); !ok || failure.Reason != "banned: cheating" {
+		t.Errorf("expected banned user to fail to connect, got %+v", reply)
+	}
+	if alice := getAlice(); alice.Online || !alice.Banned {
+		t.Errorf("expected alice to be offline and banned: %+v", alice)
+	}
+
+	post("/save", adminRequest{}, http.StatusNoContent)
+	state, _, err := readState(statePath)
+	if err != nil {
+		t.Fatalf("failed to read saved state: %s", err)
+	}
+	if _, ok := state.Bans.Names["alice"]; !ok {
+		t.Error("expected ban to be saved")
+	}
+
+	// the console stops reading commands once told to shut down
+	var out bytes.Buffer
+	in := strings.NewReader("unban alice\nusers\nfly\ntickrate 5000\nshutdown\nunban alice\n")
+	if !srv.RunConsole(in, &out) {
+		t.Error("expected console to request shutdown")
+	}
+	want := "alice at (100, -200) with 50 health (offline)\n1 users\nerror: unknown command fly - try help\n" +
+		"error: tick rate must be between 1 and 1000\n"
+	if out.String() != want {
+		t.Errorf("got console output %q, want %q", out.String(), want)
+	}
+	if alice := getAlice(); alice.Banned {
+		t.Error("expected alice to be unbanned")
+	}
+
+	// the server can be shut down more than once, e.g. by both the console and a signal handler
+	srv.Shutdown()
+}
@@ -0,0 +1,255 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/jemgunay/procedural-game/protocol"
+	"github.com/jemgunay/procedural-game/sim"
+)
+
+const (
+	// maxClockAhead is how far a client timestamp may be ahead of the server's clock, once corrected by the client's
+	// estimated clock offset.
+	maxClockAhead = time.Millisecond * 500
+	// maxClockBehind is how far a corrected client timestamp may be behind the server's clock, which includes transit
+	// time.
+	maxClockBehind = time.Second
+	// clockSmoothing is the number of samples over which a client's estimated clock offset adapts to changes, so that
+	// a single delayed message doesn't throw it off.
+	clockSmoothing = 8
+	// shotPositionTolerance is the distance beyond a weapon's barrel length at which a shot may be fired from the
+	// shooter's position on the server. It accounts for the barrel offset and movement the server hasn't simulated yet.
+	shotPositionTolerance = 100.0
+	// speedTolerance is the fraction by which a projectile's speed may differ from sim.ProjectileSpeed.
+	speedTolerance = 0.01
+	// fireDelayTolerance is how much sooner than a weapon's fire delay a shot may follow the previous one.
+	fireDelayTolerance = time.Millisecond
+	// fireBurst is the number of shots from a weapon which may be received in quick succession, e.g. when delayed
+	// shots arrive together. Beyond that, shots can only be received at the weapon's fire rate.
+	fireBurst = 3
+	// maxOffences is the number of invalid messages a user can send within offenceWindow before being kicked.
+	maxOffences = 5
+	// offenceWindow is the period after a user's last offence at which their offences are forgiven.
+	offenceWindow = time.Minute
+)
+
+// magazine is the server's record of a weapon's loaded rounds and timing. All times are client times, except for
+// those of fireLimit.
+type magazine struct {
+	rounds uint
+	// lastShot is the time of the last valid shot fired
+	lastShot time.Time
+	// reloadStart is the time a pending reload started, or zero if the weapon isn't reloading
+	reloadStart time.Time
+	// fireLimit limits the rate at which shots are received by the server, so that a client can't send a burst of
+	// shots whose timestamps are spaced out across the range of plausible client times
+	fireLimit tokenBucket
+}
+
+// armoury is the server's record of a user's weapons and ammo, mirroring the client's so that projectiles can be
+// validated.
+type armoury struct {
+	ammo    map[sim.Ammo]int
+	weapons map[sim.WeaponName]*magazine
+}
+
+// newArmoury creates an armoury containing the starting weapons and ammo.
+func newArmoury() armoury {
+	a := armoury{
+		ammo:    make(map[sim.Ammo]int, len(sim.StartingAmmo)),
+		weapons: make(map[sim.WeaponName]*magazine, len(sim.StartingWeapons)),
+	}
+	for ammo, count := range sim.StartingAmmo {
+		a.ammo[ammo] = count
+	}
+	for _, name := range sim.StartingWeapons {
+		a.weapons[name] = &magazine{rounds: sim.Weapons[name].MaxAmmoCapacity}
+	}
+	return a
+}
+
+// weapon finds a weapon held in the armoury.
+func (a armoury) weapon(name string) (sim.Weapon, *magazine, error) {
+	weapon, ok := sim.Weapons[sim.WeaponName(name)]
+	if !ok {
+		return sim.Weapon{}, nil, fmt.Errorf("unsupported weapon: %s", name)
+	}
+	mag, ok := a.weapons[weapon.Name]
+	if !ok {
+		return sim.Weapon{}, nil, fmt.Errorf("weapon not collected: %s", name)
+	}
+	return weapon, mag, nil
+}
+
+// finishReload completes a weapon's pending reload if it has finished by time t, moving rounds from the ammo store
+// into the magazine.
+func (a armoury) finishReload(weapon sim.Weapon, mag *magazine, t time.Time) {
+	if mag.reloadStart.IsZero() || t.Before(mag.reloadStart.Add(weapon.ReloadDelay)) {
+		return
+	}
+
+	required := int(weapon.MaxAmmoCapacity - mag.rounds)
+	if available := a.ammo[weapon.Ammo]; available < required {
+		required = available
+	}
+	a.ammo[weapon.Ammo] -= required
+	mag.rounds += uint(required)
+	mag.reloadStart = time.Time{}
+}
+
+// syncClock updates the estimated offset of a user's client clock from the server's clock, given a timestamp from the
+// client which was received at server time received. Messages are assumed to take half the round trip time to arrive.
+func (u *User) syncClock(clientTime, received time.Time) {
+	sample := clientTime.Sub(received.Add(-u.rtt / 2))
+	if !u.clockSynced {
+		u.clockOffset, u.clockSynced = sample, true
+		return
+	}
+	u.clockOffset += (sample - u.clockOffset) / clockSmoothing
+}
+
+// SyncClock updates the estimated offset of a user's client clock from a heartbeat ping sent by the client at
+// clientTime, which was received at server time received.
+func (d *UserDB) SyncClock(username string, clientTime, received time.Time) {
+	user, ok := d.users[username]
+	if !ok {
+		return
+	}
+	user.syncClock(clientTime, received)
+	d.users[username] = user
+}
+
+// serverTime converts a timestamp from a user's client to server time using the client's estimated clock offset.
+func (u *User) serverTime(t time.Time) time.Time {
+	return t.Add(-u.clockOffset)
+}
+
+// checkClientTime checks that a timestamp from a user's client is plausible given the server time now, once corrected
+// by the client's estimated clock offset. Honest clients can therefore have clocks which are skewed from the server's,
+// but their timestamps must be consistent with it. If the offset hasn't been estimated yet, it is estimated from the
+// timestamp.
+func (u *User) checkClientTime(t, now time.Time) error {
+	if !u.clockSynced {
+		u.syncClock(t, now)
+	}
+	t = u.serverTime(t)
+	switch {
+	case t.After(now.Add(maxClockAhead)):
+		return fmt.Errorf("timestamp is %s ahead of server", t.Sub(now))
+	case t.Before(now.Add(-maxClockBehind)):
+		return fmt.Errorf("timestamp is %s behind server", now.Sub(t))
+	}
+	return nil
+}
+
+// ValidateShot checks a projectile fired by a user and received at server time now against their position on the
+// server, the weapon's characteristics and the rounds remaining in it. If the shot is valid, a round is consumed and
+// the weapon fired is returned.
+func (d *UserDB) ValidateShot(username string, p protocol.Projectile, now time.Time) (sim.Weapon, error) {
+	user, ok := d.users[username]
+	if !ok {
+		return sim.Weapon{}, errors.New("user not found in DB")
+	}
+	weapon, mag, err := user.armoury.weapon(p.Weapon)
+	if err != nil {
+		return sim.Weapon{}, err
+	}
+
+	spawned := p.Spawned()
+	err = user.checkClientTime(spawned, now)
+	d.users[username] = user
+	if err != nil {
+		return sim.Weapon{}, err
+	}
+	if p.TTL <= 0 || p.TTL > sim.ProjectileTTL {
+		return sim.Weapon{}, fmt.Errorf("invalid TTL: %s", p.TTL)
+	}
+	// comparisons are negated so that NaNs are rejected
+	speed := math.Hypot(p.VelX, p.VelY)
+	if !(math.Abs(speed-sim.ProjectileSpeed) <= sim.ProjectileSpeed*speedTolerance) {
+		return sim.Weapon{}, fmt.Errorf("invalid speed: %f", speed)
+	}
+	maxDist := weapon.BarrelLength + shotPositionTolerance
+	if dist := math.Hypot(p.StartX-user.x, p.StartY-user.y); !(dist <= maxDist) {
+		return sim.Weapon{}, fmt.Errorf("fired %.0f from shooter, exceeding %.0f", dist, maxDist)
+	}
+	if !mag.lastShot.IsZero() && spawned.Sub(mag.lastShot) < weapon.FireDelay-fireDelayTolerance {
+		return sim.Weapon{}, fmt.Errorf("fired %s after previous shot, within fire delay of %s",
+			spawned.Sub(mag.lastShot), weapon.FireDelay)
+	}
+
+	user.armoury.finishReload(weapon, mag, spawned)
+	switch {
+	case !mag.reloadStart.IsZero():
+		return sim.Weapon{}, errors.New("fired while reloading")
+	case mag.rounds == 0:
+		return sim.Weapon{}, errors.New("fired without ammo")
+	case !mag.fireLimit.take(now, fireBurst, weapon.FireDelay):
+		return sim.Weapon{}, errors.New("shots received faster than fire rate")
+	}
+
+	// switching weapon cancels any other pending reloads
+	for name, other := range user.armoury.weapons {
+		if name == weapon.Name {
+			continue
+		}
+		user.armoury.finishReload(sim.Weapons[name], other, spawned)
+		other.reloadStart = time.Time{}
+	}
+
+	mag.rounds--
+	mag.lastShot = spawned
+	return weapon, nil
+}
+
+// Reload starts reloading one of a user's weapons.
+func (d *UserDB) Reload(username string, r protocol.Reload, now time.Time) error {
+	user, ok := d.users[username]
+	if !ok {
+		return errors.New("user not found in DB")
+	}
+	weapon, mag, err := user.armoury.weapon(r.Weapon)
+	if err != nil {
+		return err
+	}
+
+	started := r.Started()
+	err = user.checkClientTime(started, now)
+	d.users[username] = user
+	if err != nil {
+		return err
+	}
+	user.armoury.finishReload(weapon, mag, started)
+	switch {
+	case !mag.reloadStart.IsZero():
+		return errors.New("already reloading")
+	case !mag.lastShot.IsZero() && started.Sub(mag.lastShot) < weapon.FireDelay-fireDelayTolerance:
+		return errors.New("reloaded within fire delay")
+	case mag.rounds >= weapon.MaxAmmoCapacity:
+		return errors.New("reloaded a full weapon")
+	case user.armoury.ammo[weapon.Ammo] <= 0:
+		return errors.New("reloaded without spare ammo")
+	}
+
+	mag.reloadStart = started
+	return nil
+}
+
+// Offend records an invalid message from a user at time now. It reports whether the user has sent maxOffences
+// invalid messages without a break of offenceWindow, in which case they should be kicked.
+func (d *UserDB) Offend(username string, now time.Time) bool {
+	user, ok := d.users[username]
+	if !ok {
+		return false
+	}
+	if now.Sub(user.lastOffence) > offenceWindow {
+		user.offences = 0
+	}
+	user.offences++
+	user.lastOffence = now
+	d.users[username] = user
+	return user.offences >= maxOffences
+}
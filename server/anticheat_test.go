@@ -0,0 +1,107 @@
+package server
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/jemgunay/procedural-game/protocol"
+	"github.com/jemgunay/procedural-game/sim"
+)
+
+// TestClockSkew checks that the shots and reloads of clients whose clocks are skewed from the server's are accepted
+// once their clock offset has been estimated, while timestamps inconsistent with their clock are rejected.
+func TestClockSkew(t *testing.T) {
+	const rtt = time.Millisecond * 100
+	now := time.Unix(1000, 0).UTC()
+	shot := func(spawned time.Time) protocol.Projectile {
+		return protocol.Projectile{Weapon: string(sim.Deagle), SpawnTime: spawned.UnixNano(), VelX: sim.ProjectileSpeed,
+			TTL: sim.ProjectileTTL}
+	}
+
+	for _, skew := range []time.Duration{0, time.Second * 2, -time.Second * 5, time.Hour} {
+		db := UserDB{users: make(map[string]User), rand: rand.New(rand.NewSource(1))}
+		db.users["alice"] = User{name: "alice", health: sim.MaxHealth, armoury: newArmoury(), rtt: rtt}
+		client := func(t time.Time) time.Time {
+			return t.Add(skew)
+		}
+
+		// the client's heartbeat pings are sent half a round trip before they're received
+		for i := 0; i < 5; i++ {
+			received := now.Add(protocol.HeartbeatInterval * time.Duration(i))
+			db.SyncClock("alice", client(received.Add(-rtt/2)), received)
+		}
+		if offset := db.users["alice"].clockOffset; offset != skew {
+			t.Errorf("%s skew: estimated clock offset %s", skew, offset)
+		}
+
+		now := now.Add(protocol.HeartbeatInterval * 5)
+		if _, err := db.ValidateShot("alice", shot(client(now.Add(-rtt/2))), now); err != nil {
+			t.Errorf("%s skew: shot rejected: %s", skew, err)
+		}
+		reload := protocol.Reload{Weapon: string(sim.Deagle), Time: client(now.Add(time.Second)).UnixNano()}
+		if err := db.Reload("alice", reload, now.Add(time.Second+rtt/2)); err != nil {
+			t.Errorf("%s skew: reload rejected: %s", skew, err)
+		}
+
+		// timestamps which are inconsistent with the client's clock are still rejected
+		if _, err := db.ValidateShot("alice", shot(client(now.Add(time.Second*3))), now.Add(time.Second)); err == nil {
+			t.Errorf("%s skew: expected a shot from the future to be rejected", skew)
+		}
+		if _, err := db.ValidateShot("alice", shot(client(now)), now.Add(time.Second*3)); err == nil {
+			t.Errorf("%s skew: expected a shot from the past to be rejected", skew)
+		}
+	}
+}
+
+// TestClockSyncFromShot checks that a client's clock offset is estimated from its first timestamp if it hasn't sent a
+// heartbeat yet.
+func TestClockSyncFromShot(t *testing.T) {
+	db := UserDB{users: make(map[string]User), rand: rand.New(rand.NewSource(1))}
+	db.users["alice"] = User{name: "alice", health: sim.MaxHealth, armoury: newArmoury()}
+
+	now := time.Unix(1000, 0).UTC()
+	skewed := now.Add(-time.Second * 3)
+	p := protocol.Projectile{Weapon: string(sim.Deagle), SpawnTime: skewed.UnixNano(), VelX: sim.ProjectileSpeed,
+		TTL: sim.ProjectileTTL}
+	if _, err := db.ValidateShot("alice", p, now); err != nil {
+		t.Fatalf("first shot rejected: %s", err)
+	}
+	if user := db.users["alice"]; !user.clockSynced || user.serverTime(skewed) != now {
+		t.Errorf("expected clock offset to be estimated from the first shot, got %s", user.clockOffset)
+	}
+}
+
+// TestFireRate checks that a burst of shots spaced out across the range of plausible client times is limited by the
+// weapon's fire rate as received by the server.
+func TestFireRate(t *testing.T) {
+	db := UserDB{users: make(map[string]User), rand: rand.New(rand.NewSource(1))}
+	// the client's clock is in sync with the server's
+	db.users["alice"] = User{name: "alice", health: sim.MaxHealth, armoury: newArmoury(), clockSynced: true}
+	weapon := sim.Weapons[sim.M4A1]
+
+	// every shot arrives at once, but is spaced exactly one fire delay apart in client time
+	now := time.Unix(1000, 0).UTC()
+	first := now.Add(-maxClockBehind + time.Millisecond*100)
+	var accepted int
+	for i := 0; i < 10; i++ {
+		spawned := first.Add(weapon.FireDelay * time.Duration(i))
+		p := protocol.Projectile{Weapon: string(sim.M4A1), SpawnTime: spawned.UnixNano(), VelX: sim.ProjectileSpeed,
+			TTL: sim.ProjectileTTL}
+		if _, err := db.ValidateShot("alice", p, now); err == nil {
+			accepted++
+		}
+	}
+	if accepted != fireBurst {
+		t.Fatalf("got %d shots accepted at once, want %d", accepted, fireBurst)
+	}
+
+	// shots are accepted again once the weapon's fire delay has passed on the server
+	now = now.Add(weapon.FireDelay)
+	spawned := first.Add(weapon.FireDelay * 10)
+	p := protocol.Projectile{Weapon: string(sim.M4A1), SpawnTime: spawned.UnixNano(), VelX: sim.ProjectileSpeed,
+		TTL: sim.ProjectileTTL}
+	if _, err := db.ValidateShot("alice", p, now); err != nil {
+		t.Fatalf("expected shot after the fire delay to be accepted: %s", err)
+	}
+}
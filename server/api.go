@@ -0,0 +1,278 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxAdminRequestSize is the maximum size of an admin API request body.
+const maxAdminRequestSize = 1 << 16
+
+// adminRequest is the body of an admin API request. Each endpoint only uses the fields relevant to it.
+type adminRequest struct {
+	Name     string  `json:"name"`
+	IP       string  `json:"ip"`
+	Reason   string  `json:"reason"`
+	X        float64 `json:"x"`
+	Y        float64 `json:"y"`
+	Health   uint64  `json:"health"`
+	Text     string  `json:"text"`
+	TickRate uint    `json:"tick_rate"`
+	Password string  `json:"password"`
+}
+
+// adminError is the body of an admin API response to a failed request.
+type adminError struct {
+	Error string `json:"error"`
+}
+
+// ListenAdmin serves the admin HTTP/JSON API in the background until the server is shut down. addr must be a loopback
+// address, e.g. localhost:9001, and requests must present token as a bearer token.
+func (s *Server) ListenAdmin(addr, token string) error {
+	if token == "" {
+		return errors.New("no admin API token provided")
+	}
+	return s.listenLocal("admin API", addr, s.AdminHandler(token))
+}
+
+// listenLocal serves an HTTP handler on a loopback address in the background until the server is shut down. name
+// describes what is being served. Requests for hosts other than loopback addresses are refused, so that web pages
+// can't reach the handler by rebinding their own domain names to a loopback address.
+func (s *Server) listenLocal(name, addr string, handler http.Handler) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid %s address %s: %s", name, addr, err)
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return fmt.Errorf("%s address %s isn't a loopback address", name, addr)
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to bind %s on %s: %s", name, addr, err)
+	}
+	srv := &http.Server{
+		Handler:      loopbackOnly(handler),
+		ReadTimeout:  time.Second * 10,
+		WriteTimeout: time.Second * 10,
+	}
+
+	s.listenersMu.Lock()
+	select {
+	case <-s.stopChan:
+		s.listenersMu.Unlock()
+		l.Close()
+		return errShutdown
+	default:
+	}
+	s.httpServers = append(s.httpServers, srv)
+	s.listenersMu.Unlock()
+
+	slog.Info("HTTP server listening", "serving", name, "addr", l.Addr().String())
+	go func() {
+		if err := srv.Serve(l); err != nil && err != http.ErrServerClosed {
+			slog.Error("HTTP server failed", "serving", name, "err", err)
+		}
+	}()
+	return nil
+}
+
+// closeHTTP shuts down the admin API and metrics servers, waiting briefly for in-flight requests to complete.
+func (s *Server) closeHTTP() {
+	s.listenersMu.Lock()
+	servers := s.httpServers
+	s.httpServers = nil
+	s.listenersMu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for _, srv := range servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			srv.Close()
+		}
+	}
+}
+
+// loopbackOnly wraps a handler, refusing requests whose Host header isn't localhost or a loopback address.
+func loopbackOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			writeAdminError(w, http.StatusForbidden, fmt.Errorf("host %s isn't a loopback address", r.Host))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// AdminHandler returns the handler serving the admin HTTP/JSON API, which requires every request to present token in
+// an "Authorization: Bearer <token>" header:
+//
+//	GET  /users       list every user
+//	GET  /conn-stats  get the connection stats of every connected user
+//	GET  /bans        list the banned usernames and IP addresses
+//	GET  /metrics     get the server's metrics in the Prometheus text format
+//	POST /kick        {"name", "reason"}
+//	POST /ban         {"name", "reason"}
+//	POST /unban       {"name"}
+//	POST /ban-ip      {"ip", "reason"}
+//	POST /unban-ip    {"ip"}
+//	POST /teleport    {"name", "x", "y"}
+//	POST /health      {"name", "health"}
+//	POST /password    {"name", "password"}
+//	POST /announce    {"text"}
+//	POST /tick-rate   {"tick_rate"}
+//	POST /save
+//
+// POST requests must have a Content-Type of application/json, which browsers won't send cross-origin without the
+// server's permission. Successful POST requests are answered with 204 No Content, and failed requests with a JSON
+// {"error"} body.
+func (s *Server) AdminHandler(token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/users", adminGet(func() (interface{}, error) {
+		return s.Users()
+	}))
+	mux.HandleFunc("/conn-stats", adminGet(func() (interface{}, error) {
+		return s.ConnStats(), nil
+	}))
+	mux.Handle("/metrics", s.MetricsHandler())
+	mux.HandleFunc("/bans", adminGet(func() (interface{}, error) {
+		names, ips, err := s.Bans()
+		return banList{Names: names, IPs: ips}, err
+	}))
+	mux.HandleFunc("/kick", adminPost(func(req adminRequest) error {
+		if req.Reason == "" {
+			req.Reason = "kicked by an admin"
+		}
+		return s.Kick(req.Name, req.Reason)
+	}))
+	mux.HandleFunc("/ban", adminPost(func(req adminRequest) error {
+		if req.Reason == "" {
+			req.Reason = "banned by an admin"
+		}
+		return s.Ban(req.Name, req.Reason)
+	}))
+	mux.HandleFunc("/unban", adminPost(func(req adminRequest) error {
+		return s.Unban(req.Name)
+	}))
+	mux.HandleFunc("/ban-ip", adminPost(func(req adminRequest) error {
+		if req.Reason == "" {
+			req.Reason = "banned by an admin"
+		}
+		return s.BanIP(req.IP, req.Reason)
+	}))
+	mux.HandleFunc("/unban-ip", adminPost(func(req adminRequest) error {
+		return s.UnbanIP(req.IP)
+	}))
+	mux.HandleFunc("/teleport", adminPost(func(req adminRequest) error {
+		return s.Teleport(req.Name, req.X, req.Y)
+	}))
+	mux.HandleFunc("/health", adminPost(func(req adminRequest) error {
+		return s.SetHealth(req.Name, req.Health)
+	}))
+	mux.HandleFunc("/password", adminPost(func(req adminRequest) error {
+		return s.SetPassword(req.Name, req.Password)
+	}))
+	mux.HandleFunc("/announce", adminPost(func(req adminRequest) error {
+		return s.Announce(req.Text)
+	}))
+	mux.HandleFunc("/tick-rate", adminPost(func(req adminRequest) error {
+		return s.SetTickRate(req.TickRate)
+	}))
+	mux.HandleFunc("/save", adminPost(func(adminRequest) error {
+		return s.Save()
+	}))
+	return adminAuth(token, mux)
+}
+
+// adminAuth wraps a handler, refusing requests which don't present token as a bearer token. An empty token refuses
+// every request.
+func adminAuth(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		presented, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" || subtle.ConstantTimeCompare([]byte(presented), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeAdminError(w, http.StatusUnauthorized, errors.New("invalid or missing admin API token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// adminGet creates a handler for a GET endpoint of the admin API, which responds with the JSON encoded result of get.
+func adminGet(get func() (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			writeAdminError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+			return
+		}
+		result, err := get()
+		if err != nil {
+			writeAdminError(w, adminErrorStatus(err), err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(result); err != nil {
+			slog.Warn("failed to write admin API response", "err", err)
+		}
+	}
+}
+
+// adminPost creates a handler for a POST endpoint of the admin API, which decodes the JSON request body and passes it
+// to do. An empty body is treated as an empty request.
+func adminPost(do func(req adminRequest) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeAdminError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+			return
+		}
+		if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil ||
+			mediaType != "application/json" {
+			writeAdminError(w, http.StatusUnsupportedMediaType, errors.New("content type must be application/json"))
+			return
+		}
+		var req adminRequest
+		err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAdminRequestSize)).Decode(&req)
+		if err != nil && err != io.EOF {
+			writeAdminError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %s", err))
+			return
+		}
+		if err := do(req); err != nil {
+			writeAdminError(w, adminErrorStatus(err), err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// adminErrorStatus gets the HTTP status code for an error returned by an admin action.
+func adminErrorStatus(err error) int {
+	if err == errShutdown {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusBadRequest
+}
+
+// writeAdminError writes a failed admin API response.
+func writeAdminError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(adminError{Error: err.Error()}); err != nil {
+		slog.Warn("failed to write admin API response", "err", err)
+	}
+}
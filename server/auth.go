@@ -0,0 +1,146 @@
+package server
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// MinPasswordLength is the minimum password length.
+	MinPasswordLength = 6
+	// hashIterations is the number of PBKDF2 iterations used to hash passwords, making brute forcing a leaked hash
+	// expensive.
+	hashIterations = 100000
+	// saltLength is the length in bytes of the random salt hashed with each password.
+	saltLength = 16
+	// tokenLength is the length in bytes of a session token.
+	tokenLength = 32
+	// sessionTTL is how long a session token can be used to reconnect for after it is issued.
+	sessionTTL = time.Hour * 24 * 7
+)
+
+// errInvalidCredentials is returned when a password or session token is incorrect. It doesn't reveal which was
+// incorrect.
+var errInvalidCredentials = errors.New("invalid credentials")
+
+// errNoPassword is returned when connecting as a user who has no password, such as one restored from state saved
+// before passwords were required. An admin must set their password before they can connect.
+var errNoPassword = errors.New("account has no password, ask an admin to set one")
+
+// credential is a salted password hash.
+type credential struct {
+	salt []byte
+	hash []byte
+}
+
+// newCredential validates a password and hashes it with a new random salt.
+func newCredential(password string) (credential, error) {
+	if len(password) < MinPasswordLength {
+		return credential{}, fmt.Errorf("password must have a minimum length of %d characters", MinPasswordLength)
+	}
+
+	salt := make([]byte, saltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return credential{}, fmt.Errorf("failed to generate salt: %s", err)
+	}
+	hash, err := pbkdf2.Key(sha256.New, password, salt, hashIterations, sha256.Size)
+	if err != nil {
+		return credential{}, fmt.Errorf("failed to hash password: %s", err)
+	}
+	return credential{salt: salt, hash: hash}, nil
+}
+
+// set determines whether a password has been set.
+func (c credential) set() bool {
+	return len(c.hash) > 0
+}
+
+// verify determines whether a password matches the credential.
+func (c credential) verify(password string) bool {
+	if !c.set() {
+		return false
+	}
+	hash, err := pbkdf2.Key(sha256.New, password, c.salt, hashIterations, len(c.hash))
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(hash, c.hash) == 1
+}
+
+// session is a session token issued to a user. Only the token's hash is stored, so that tokens can't be recovered
+// from persisted state.
+type session struct {
+	hash    []byte
+	expires time.Time
+}
+
+// newSession generates a random session token which expires sessionTTL after now.
+func newSession(now time.Time) (string, session, error) {
+	token := make([]byte, tokenLength)
+	if _, err := rand.Read(token); err != nil {
+		return "", session{}, fmt.Errorf("failed to generate session token: %s", err)
+	}
+	encoded := hex.EncodeToString(token)
+	hash := sha256.Sum256([]byte(encoded))
+	return encoded, session{hash: hash[:], expires: now.Add(sessionTTL)}, nil
+}
+
+// verify determines whether a token matches the session and the session hasn't expired at time now.
+func (s session) verify(token string, now time.Time) bool {
+	if len(s.hash) == 0 || now.After(s.expires) {
+		return false
+	}
+	hash := sha256.Sum256([]byte(token))
+	return subtle.ConstantTimeCompare(hash[:], s.hash) == 1
+}
+
+// authenticate verifies a user's password or session token at time now. Users without a password can't authenticate
+// with one until an admin sets it. Hashing is deliberately slow, so it shouldn't be called on the simulation goroutine.
+func (u *User) authenticate(password, token string, now time.Time) error {
+	switch {
+	case token != "":
+		if !u.session.verify(token, now) {
+			return errInvalidCredentials
+		}
+	case !u.credential.set():
+		return errNoPassword
+	case !u.credential.verify(password):
+		return errInvalidCredentials
+	}
+	return nil
+}
+
+// IssueSession issues a new session token to a user at time now, replacing any previously issued token.
+func (d *UserDB) IssueSession(username string, now time.Time) (string, error) {
+	token, s, err := newSession(now)
+	if err != nil {
+		return "", err
+	}
+
+	user, ok := d.users[username]
+	if !ok {
+		return "", errors.New("user not found in DB")
+	}
+	user.session = s
+	d.users[username] = user
+	return token, nil
+}
+
+// SetPassword sets a user's password, revoking their session token so that only the password can be used to connect
+// as them from then on.
+func (d *UserDB) SetPassword(username string, cred credential) error {
+	user, ok := d.users[username]
+	if !ok {
+		return fmt.Errorf("no user named %s", username)
+	}
+	user.credential = cred
+	user.session = session{}
+	d.users[username] = user
+	return nil
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// TestCredential checks that passwords are validated, salted and verified.
+func TestCredential(t *testing.T) {
+	if _, err := newCredential("short"); err == nil {
+		t.Error("expected a password shorter than the minimum length to be rejected")
+	}
+
+	cred, err := newCredential("password")
+	if err != nil {
+		t.Fatalf("failed to create credential: %s", err)
+	}
+	if !cred.verify("password") {
+		t.Error("expected the correct password to be verified")
+	}
+	if cred.verify("Password") || cred.verify("") {
+		t.Error("expected an incorrect password to be rejected")
+	}
+	if (credential{}).verify("") {
+		t.Error("expected an unset credential to reject every password")
+	}
+
+	// the same password is hashed differently with each salt
+	other, err := newCredential("password")
+	if err != nil {
+		t.Fatalf("failed to create credential: %s", err)
+	}
+	if bytes.Equal(cred.salt, other.salt) || bytes.Equal(cred.hash, other.hash) {
+		t.Error("expected credentials for the same password to have different salts and hashes")
+	}
+}
+
+// TestSession checks that session tokens are only accepted until they expire, and that only their hashes are stored.
+func TestSession(t *testing.T) {
+	now := time.Now()
+	token, s, err := newSession(now)
+	if err != nil {
+		t.Fatalf("failed to create session: %s", err)
+	}
+	if bytes.Contains(s.hash, []byte(token)) {
+		t.Error("expected the session to only store the token's hash")
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		now   time.Time
+		want  bool
+	}{
+		{"valid", token, now, true},
+		{"before expiry", token, now.Add(sessionTTL), true},
+		{"expired", token, now.Add(sessionTTL + time.Second), false},
+		{"wrong token", token[1:] + "0", now, false},
+		{"empty token", "", now, false},
+	}
+	for _, test := range tests {
+		if got := s.verify(test.token, test.now); got != test.want {
+			t.Errorf("%s: got %t, want %t", test.name, got, test.want)
+		}
+	}
+	if (session{}).verify("", now) {
+		t.Error("expected a user without a session to reject every token")
+	}
+}
+
+// TestAuthenticate checks that users authenticate with either their password or session token, and that users without
+// a password can't connect until an admin sets one.
+func TestAuthenticate(t *testing.T) {
+	db := UserDB{users: make(map[string]User), rand: rand.New(rand.NewSource(1))}
+	now := time.Now()
+	cred, err := newCredential("password")
+	if err != nil {
+		t.Fatalf("failed to create credential: %s", err)
+	}
+	if _, err := db.Create("alice", cred, &recordConn{}, now); err != nil {
+		t.Fatalf("failed to create user: %s", err)
+	}
+	token, err := db.IssueSession("alice", now)
+	if err != nil {
+		t.Fatalf("failed to issue session: %s", err)
+	}
+	// a user restored from state saved before passwords were required
+	db.users["legacy"] = User{name: "legacy"}
+
+	tests := []struct {
+		name     string
+		user     string
+		password string
+		token    string
+		want     error
+	}{
+		{"password", "alice", "password", "", nil},
+		{"wrong password", "alice", "hunter2", "", errInvalidCredentials},
+		{"token", "alice", "", token, nil},
+		{"token takes precedence over password", "alice", "hunter2", token, nil},
+		{"wrong token", "alice", "password", "not-the-token", errInvalidCredentials},
+		{"no password set", "legacy", "password", "", errNoPassword},
+		{"no session issued", "legacy", "", token, errInvalidCredentials},
+	}
+	for _, test := range tests {
+		user, _ := db.Get(test.user)
+		if err := user.authenticate(test.password, test.token, now); err != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, err, test.want)
+		}
+	}
+
+	// once an admin sets the user's password, they can connect with it
+	if err := db.SetPassword("legacy", cred); err != nil {
+		t.Fatalf("failed to set password: %s", err)
+	}
+	user, _ := db.Get("legacy")
+	if err := user.authenticate("password", "", now); err != nil {
+		t.Errorf("expected the password set by an admin to be accepted: %s", err)
+	}
+	if err := db.SetPassword("nobody", cred); err == nil {
+		t.Error("expected setting the password of a user who doesn't exist to fail")
+	}
+
+	// setting a password revokes the user's session
+	if err := db.SetPassword("alice", cred); err != nil {
+		t.Fatalf("failed to set password: %s", err)
+	}
+	user, _ = db.Get("alice")
+	if err := user.authenticate("", token, now); err != errInvalidCredentials {
+		t.Errorf("expected the session to be revoked, got %v", err)
+	}
+}
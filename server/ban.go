@@ -0,0 +1,43 @@
+package server
+
+// banList is the set of banned usernames and IP addresses, along with the reasons they were banned. It is persisted
+// with the server state.
+type banList struct {
+	Names map[string]string `json:"names,omitempty"`
+	IPs   map[string]string `json:"ips,omitempty"`
+}
+
+// newBanList creates an empty ban list.
+func newBanList() banList {
+	return banList{Names: make(map[string]string), IPs: make(map[string]string)}
+}
+
+// load replaces the ban list with a persisted one.
+func (b *banList) load(saved banList) {
+	*b = newBanList()
+	for name, reason := range saved.Names {
+		b.Names[name] = reason
+	}
+	for ip, reason := range saved.IPs {
+		b.IPs[ip] = reason
+	}
+}
+
+// save returns a copy of the ban list to be persisted.
+func (b *banList) save() banList {
+	var saved banList
+	saved.load(*b)
+	return saved
+}
+
+// nameBanned determines whether a username is banned, returning the reason it was banned.
+func (b *banList) nameBanned(name string) (string, bool) {
+	reason, ok := b.Names[name]
+	return reason, ok
+}
+
+// ipBanned determines whether an IP address is banned, returning the reason it was banned.
+func (b *banList) ipBanned(ip string) (string, bool) {
+	reason, ok := b.IPs[ip]
+	return reason, ok
+}
@@ -0,0 +1,246 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/jemgunay/procedural-game/protocol"
+)
+
+const (
+	// chatBurst is the number of chat messages a user can send at once, which are replenished at one per
+	// chatInterval.
+	chatBurst    = 5
+	chatInterval = time.Second
+	// chatProximityRadius is the distance from the sender within which users receive proximity chat.
+	chatProximityRadius = 1000.0
+)
+
+// SetAdmins sets the names of the users who can use admin chat commands, such as /kick and /tp. It must be called
+// before the server is started.
+func (s *Server) SetAdmins(names ...string) {
+	s.admins = make(map[string]struct{}, len(names))
+	for _, name := range names {
+		s.admins[name] = struct{}{}
+	}
+}
+
+// systemChat creates a chat message from the server to a single user.
+func systemChat(format string, args ...interface{}) protocol.Chat {
+	return protocol.Chat{Channel: protocol.ChatSystem, Text: fmt.Sprintf(format, args...)}
+}
+
+// sanitiseChat removes control characters and surrounding whitespace from chat text, so that clients can't forge
+// lines in other users' chat logs or the server's log.
+func sanitiseChat(text string) string {
+	return strings.TrimSpace(strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, text))
+}
+
+// AllowChat records a chat message or command from a user at time now, reporting whether they are within the chat
+// rate limit.
+func (d *UserDB) AllowChat(username string, now time.Time) bool {
+	user, ok := d.users[username]
+	if !ok {
+		return false
+	}
+	allowed := user.chatLimit.take(now, chatBurst, chatInterval)
+	d.users[username] = user
+	return allowed
+}
+
+// SendNear sends a message to the connected users within radius of a position.
+func (d *UserDB) SendNear(p protocol.Payload, x, y, radius float64) {
+	for _, user := range d.users {
+		if user.conn != nil && math.Hypot(user.x-x, user.y-y) <= radius {
+			user.Send(p)
+		}
+	}
+}
+
+// Online returns the names of the connected users in alphabetical order.
+func (d *UserDB) Online() []string {
+	var names []string
+	for _, user := range d.users {
+		if user.conn != nil {
+			names = append(names, user.name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Teleport moves a user to a position. Their position history is cleared so that projectiles aren't tested against
+// where they were before being teleported.
+func (d *UserDB) Teleport(username string, x, y float64) {
+	user, ok := d.users[username]
+	if !ok {
+		return
+	}
+	user.x, user.y = x, y
+	user.history = nil
+	d.users[username] = user
+}
+
+// chat routes a chat message received from a user at time now to the users on its channel, or runs it as a command if
+// it starts with a slash.
+func (s *Server) chat(user User, msg protocol.Chat, now time.Time) {
+	if len(msg.Text) > protocol.MaxChatLength {
+		user.Send(systemChat("messages can't be longer than %d characters", protocol.MaxChatLength))
+		return
+	}
+	text := sanitiseChat(msg.Text)
+	if text == "" {
+		return
+	}
+	if !s.userDB.AllowChat(user.name, now) {
+		user.Send(systemChat("you're sending messages too quickly"))
+		return
+	}
+	if strings.HasPrefix(text, "/") {
+		s.chatCommand(user, text, now)
+		return
+	}
+
+	switch msg.Channel {
+	case protocol.ChatGlobal:
+		slog.Info("chat", "channel", msg.Channel, "user", user.name, "text", text)
+		s.userDB.Broadcast(protocol.Chat{Channel: protocol.ChatGlobal, From: user.name, Text: text})
+
+	case protocol.ChatProximity:
+		slog.Info("chat", "channel", msg.Channel, "user", user.name, "text", text)
+		s.userDB.SendNear(protocol.Chat{Channel: protocol.ChatProximity, From: user.name, Text: text}, user.x, user.y,
+			chatProximityRadius)
+
+	case protocol.ChatWhisper:
+		s.whisper(user, msg.To, text)
+
+	default:
+		user.Send(systemChat("unknown chat channel: %s", msg.Channel))
+	}
+}
+
+// whisper sends a chat message from a user to a single connected user, echoing it back to the sender.
+func (s *Server) whisper(from User, to, text string) {
+	recipient, ok := s.userDB.Get(to)
+	if !ok || recipient.conn == nil {
+		from.Send(systemChat("%s isn't online", to))
+		return
+	}
+
+	msg := protocol.Chat{Channel: protocol.ChatWhisper, From: from.name, To: recipient.name, Text: text}
+	recipient.Send(msg)
+	if recipient.name != from.name {
+		from.Send(msg)
+	}
+}
+
+// cutField splits the first whitespace separated field from the rest of a string.
+func cutField(s string) (string, string) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, unicode.IsSpace)
+	if i < 0 {
+		return s, ""
+	}
+	return s[:i], strings.TrimSpace(s[i:])
+}
+
+// chatCommand runs a chat command sent by a user at time now, replying to them with the outcome.
+func (s *Server) chatCommand(user User, text string, now time.Time) {
+	name, args := cutField(text)
+	_, admin := s.admins[user.name]
+
+	switch name {
+	case "/help":
+		help := "commands: /who, /w <name> <message>"
+		if admin {
+			help += ", /kick <name> [reason], /tp [name] <x> <y>"
+		}
+		user.Send(systemChat("%s", help))
+
+	case "/who":
+		online := s.userDB.Online()
+		user.Send(systemChat("%d online: %s", len(online), strings.Join(online, ", ")))
+
+	case "/w", "/whisper":
+		to, message := cutField(args)
+		if to == "" || message == "" {
+			user.Send(systemChat("usage: /w <name> <message>"))
+			return
+		}
+		s.whisper(user, to, message)
+
+	case "/kick", "/tp":
+		if !admin {
+			user.Send(systemChat("you don't have permission to use %s", name))
+			return
+		}
+		if name == "/kick" {
+			s.kickCommand(user, args, now)
+		} else {
+			s.teleportCommand(user, args)
+		}
+
+	default:
+		user.Send(systemChat("unknown command %s - try /help", name))
+	}
+}
+
+// kickCommand kicks the user named in an admin's /kick command, with an optional reason.
+func (s *Server) kickCommand(admin User, args string, now time.Time) {
+	name, reason := cutField(args)
+	if name == "" {
+		admin.Send(systemChat("usage: /kick <name> [reason]"))
+		return
+	}
+	if reason == "" {
+		reason = "kicked by " + admin.name
+	}
+
+	if err := s.kickUser(name, reason, now); err != nil {
+		admin.Send(systemChat("%s", err))
+		return
+	}
+	if name != admin.name {
+		admin.Send(systemChat("kicked %s", name))
+	}
+}
+
+// teleportCommand moves the admin, or the user named in their /tp command, to a position.
+func (s *Server) teleportCommand(admin User, args string) {
+	fields := strings.Fields(args)
+	name := admin.name
+	if len(fields) == 3 {
+		name, fields = fields[0], fields[1:]
+	}
+	if len(fields) != 2 {
+		admin.Send(systemChat("usage: /tp [name] <x> <y>"))
+		return
+	}
+
+	var coords [2]float64
+	for i, field := range fields {
+		v, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			admin.Send(systemChat("invalid coordinate: %s", field))
+			return
+		}
+		coords[i] = v
+	}
+
+	if err := s.teleportUser(name, coords[0], coords[1]); err != nil {
+		admin.Send(systemChat("%s", err))
+		return
+	}
+	admin.Send(systemChat("teleported %s to (%.0f, %.0f)", name, coords[0], coords[1]))
+}
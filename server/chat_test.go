@@ -0,0 +1,103 @@
+package server
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jemgunay/procedural-game/protocol"
+	"github.com/jemgunay/procedural-game/sim"
+)
+
+// TestChat checks that chat messages are routed to the users on their channel, and that chat commands are run with the
+// sender's permissions.
+func TestChat(t *testing.T) {
+	srv, err := New("test-seed", "", DefaultTickRate)
+	if err != nil {
+		t.Fatalf("failed to create server: %s", err)
+	}
+	defer srv.Shutdown()
+	srv.SetAdmins("alice")
+
+	now := time.Now()
+	conns := map[string]*recordConn{}
+	for name, x := range map[string]float64{"alice": 0, "bobby": 500, "carol": 5000} {
+		conns[name] = &recordConn{}
+		srv.userDB.users[name] = User{name: name, x: x, health: sim.MaxHealth, lastSeen: now, conn: conns[name]}
+	}
+	srv.userDB.users["dave"] = User{name: "dave", health: sim.MaxHealth}
+
+	send := func(from string, msg protocol.Chat) {
+		srv.handleCommand(command{username: from, conn: conns[from], msg: msg, received: now})
+	}
+	// expect checks the messages received by every connected user since the last check
+	expect := func(desc string, want map[string][]protocol.Payload) {
+		t.Helper()
+		for name, conn := range conns {
+			if got := conn.take(); !reflect.DeepEqual(got, want[name]) {
+				t.Errorf("%s: %s received %+v, want %+v", desc, name, got, want[name])
+			}
+		}
+	}
+
+	global := protocol.Chat{Channel: protocol.ChatGlobal, From: "bobby", Text: "hello everyone"}
+	send("bobby", protocol.Chat{Channel: protocol.ChatGlobal, Text: " hello\x1b everyone\n"})
+	expect("global", map[string][]protocol.Payload{"alice": {global}, "bobby": {global}, "carol": {global}})
+
+	near := protocol.Chat{Channel: protocol.ChatProximity, From: "alice", Text: "anyone nearby?"}
+	send("alice", protocol.Chat{Channel: protocol.ChatProximity, Text: "anyone nearby?"})
+	expect("proximity", map[string][]protocol.Payload{"alice": {near}, "bobby": {near}})
+
+	whisper := protocol.Chat{Channel: protocol.ChatWhisper, From: "alice", To: "carol", Text: "psst"}
+	send("alice", protocol.Chat{Channel: protocol.ChatWhisper, To: "carol", Text: "psst"})
+	expect("whisper", map[string][]protocol.Payload{"alice": {whisper}, "carol": {whisper}})
+	send("alice", protocol.Chat{Channel: protocol.ChatGlobal, Text: "/w carol  psst"})
+	expect("whisper command", map[string][]protocol.Payload{"alice": {whisper}, "carol": {whisper}})
+	send("alice", protocol.Chat{Channel: protocol.ChatGlobal, Text: "/w dave hi"})
+	expect("offline whisper", map[string][]protocol.Payload{"alice": {systemChat("dave isn't online")}})
+
+	send("carol", protocol.Chat{Channel: protocol.ChatGlobal, Text: "/who"})
+	expect("who", map[string][]protocol.Payload{"carol": {systemChat("3 online: alice, bobby, carol")}})
+
+	// admin commands are only available to admins
+	send("bobby", protocol.Chat{Channel: protocol.ChatGlobal, Text: "/tp 0 0"})
+	expect("unprivileged", map[string][]protocol.Payload{"bobby": {systemChat("you don't have permission to use /tp")}})
+
+	send("alice", protocol.Chat{Channel: protocol.ChatGlobal, Text: "/tp bobby 100 -200"})
+	expect("teleport", map[string][]protocol.Payload{"alice": {systemChat("teleported bobby to (100, -200)")}})
+	if bobby, _ := srv.userDB.Get("bobby"); bobby.x != 100 || bobby.y != -200 {
+		t.Errorf("bobby teleported to (%f, %f), want (100, -200)", bobby.x, bobby.y)
+	}
+
+	// alice has used up her burst of messages
+	now = now.Add(chatInterval)
+	send("alice", protocol.Chat{Channel: protocol.ChatGlobal, Text: "/kick carol being rude"})
+	expect("kick", map[string][]protocol.Payload{
+		"alice": {protocol.Disconnect{Name: "carol"}, systemChat("kicked carol")},
+		"bobby": {protocol.Disconnect{Name: "carol"}},
+		"carol": {protocol.Kick{Reason: "being rude"}},
+	})
+	if carol, _ := srv.userDB.Get("carol"); carol.conn != nil {
+		t.Error("expected carol to be disconnected")
+	}
+	delete(conns, "carol")
+
+	// messages must be within the length and rate limits
+	send("bobby", protocol.Chat{Channel: protocol.ChatGlobal, Text: strings.Repeat("a", protocol.MaxChatLength+1)})
+	expect("too long", map[string][]protocol.Payload{
+		"bobby": {systemChat("messages can't be longer than %d characters", protocol.MaxChatLength)},
+	})
+	now = now.Add(time.Minute)
+	for i := 0; i < chatBurst; i++ {
+		send("bobby", protocol.Chat{Channel: protocol.ChatProximity, Text: "spam"})
+	}
+	conns["alice"].take()
+	conns["bobby"].take()
+	send("bobby", protocol.Chat{Channel: protocol.ChatProximity, Text: "spam"})
+	expect("rate limited", map[string][]protocol.Payload{"bobby": {systemChat("you're sending messages too quickly")}})
+	now = now.Add(chatInterval)
+	send("bobby", protocol.Chat{Channel: protocol.ChatProximity, Text: "spam"})
+	spam := protocol.Chat{Channel: protocol.ChatProximity, From: "bobby", Text: "spam"}
+	expect("rate limit replenished", map[string][]protocol.Payload{"alice": {spam}, "bobby": {spam}})
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"log/slog"
+	"math"
+	"time"
+
+	"github.com/jemgunay/procedural-game/protocol"
+	"github.com/jemgunay/procedural-game/sim"
+)
+
+const (
+	// hitRadius is the distance from a user's position within which a projectile hits them.
+	hitRadius = 50.0
+	// respawnDelay is how long a dead user waits before respawning.
+	respawnDelay = time.Second * 5
+	// spawnAttempts is the number of random spawn positions considered when respawning a user.
+	spawnAttempts = 20
+	// safeSpawnDistance is the distance from all other alive users at which a spawn position is considered safe.
+	safeSpawnDistance = 1000.0
+)
+
+// Hit applies weapon damage to the users hit by the provided projectiles at time now, killing users whose health
+// reaches zero. Users are rewound by each projectile's lag compensation before testing for hits. The projectiles which
+// didn't hit anyone are returned, along with the resulting hit and death events to be broadcast.
+func (d *UserDB) Hit(now time.Time, projectiles []Projectile) ([]Projectile, []protocol.Payload) {
+	var (
+		remaining []Projectile
+		events    []protocol.Payload
+	)
+	for _, p := range projectiles {
+		victim, ok := d.hitTest(p, now.Add(-p.rewind))
+		if !ok {
+			remaining = append(remaining, p)
+			continue
+		}
+
+		damage := p.weapon.Damage
+		if damage > victim.health {
+			damage = victim.health
+		}
+		victim.health -= damage
+		events = append(events, protocol.PlayerHit{
+			Name:     victim.name,
+			Attacker: p.owner,
+			Weapon:   string(p.weapon.Name),
+			Damage:   damage,
+			Health:   victim.health,
+		})
+
+		if victim.dead() {
+			slog.Info("player killed", "user", victim.name, "killer", p.owner, "weapon", p.weapon.Name)
+			victim.respawnAt = now.Add(respawnDelay)
+			victim.inputs = nil
+			events = append(events, protocol.PlayerDied{
+				Name:         victim.name,
+				Killer:       p.owner,
+				RespawnDelay: respawnDelay,
+			})
+		}
+		d.users[victim.name] = victim
+	}
+	return remaining, events
+}
+
+// hitTest finds a connected, alive user other than the projectile's owner which the projectile overlaps with, using
+// each user's position at time t.
+func (d *UserDB) hitTest(p Projectile, t time.Time) (User, bool) {
+	for _, user := range d.users {
+		if user.name == p.owner || user.conn == nil || user.dead() {
+			continue
+		}
+
+		x, y, ok := user.history.at(t)
+		if !ok {
+			x, y = user.x, user.y
+		}
+		deltaX := p.x - x
+		deltaY := p.y - y
+		if (deltaX*deltaX)+(deltaY*deltaY) < hitRadius*hitRadius {
+			return user, true
+		}
+	}
+	return User{}, false
+}
+
+// Respawn respawns each dead user whose respawn delay has passed at a safe position with full health. The resulting
+// respawn events are returned to be broadcast.
+func (d *UserDB) Respawn(now time.Time) []protocol.Payload {
+	var events []protocol.Payload
+	for name, user := range d.users {
+		if !user.dead() || now.Before(user.respawnAt) {
+			continue
+		}
+
+		user.x, user.y = d.spawnPoint(name)
+		user.health = sim.MaxHealth
+		// don't rewind the user to their position before respawning
+		user.history = nil
+		d.users[name] = user
+		events = append(events, protocol.PlayerRespawned{Player: user.Vitals()})
+	}
+	return events
+}
+
+// spawnPoint picks a random spawn position for a user which is as far as possible from every other alive user,
+// accepting the first position which is at least safeSpawnDistance away.
+func (d *UserDB) spawnPoint(username string) (float64, float64) {
+	var bestX, bestY, bestDist float64
+	for i := 0; i < spawnAttempts; i++ {
+		x := float64(d.rand.Intn(worldSize))
+		y := float64(d.rand.Intn(worldSize))
+
+		// find the distance to the closest alive user
+		closest := math.Inf(1)
+		for _, user := range d.users {
+			if user.name == username || user.conn == nil || user.dead() {
+				continue
+			}
+			closest = math.Min(closest, math.Hypot(user.x-x, user.y-y))
+		}
+
+		if closest >= safeSpawnDistance {
+			return x, y
+		}
+		if i == 0 || closest > bestDist {
+			bestX, bestY, bestDist = x, y, closest
+		}
+	}
+	return bestX, bestY
+}
@@ -0,0 +1,197 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jemgunay/procedural-game/protocol"
+	"github.com/jemgunay/procedural-game/sim"
+)
+
+// newCombatServer creates a server with a shooter and a target, a bystander near the target and a user far away from
+// all of them, each connected over a recordConn. A step is run so that each user's area of interest is populated.
+func newCombatServer(t *testing.T) (*Server, map[string]*recordConn) {
+	t.Helper()
+	srv, err := New("test-seed", "", DefaultTickRate)
+	if err != nil {
+		t.Fatalf("failed to create server: %s", err)
+	}
+
+	conns := make(map[string]*recordConn)
+	positions := map[string][2]float64{
+		"shooter":   {1300, 1000},
+		"target":    {1000, 1000},
+		"bystander": {1000, 1300},
+		"faraway":   {7000, 7000},
+	}
+	for name, pos := range positions {
+		conns[name] = &recordConn{}
+		srv.userDB.users[name] = User{name: name, health: sim.MaxHealth, x: pos[0], y: pos[1], lastSeen: time.Now(),
+			conn: conns[name]}
+	}
+	srv.step()
+	for _, conn := range conns {
+		conn.take()
+	}
+	return srv, conns
+}
+
+// fireAt creates a stationary projectile fired by the shooter on top of a user.
+func fireAt(srv *Server, name string, weapon sim.WeaponName) {
+	user, _ := srv.userDB.Get(name)
+	srv.projectileDB.Create(Projectile{owner: "shooter", weapon: sim.Weapons[weapon], x: user.x, y: user.y,
+		startX: user.x, startY: user.y, spawnTime: srv.simTime, ttl: time.Second})
+}
+
+// combatEvents returns and clears the hit, death and respawn events sent to a user, ignoring everything else.
+func combatEvents(c *recordConn) []protocol.Payload {
+	var events []protocol.Payload
+	for _, p := range c.take() {
+		switch p.(type) {
+		case protocol.PlayerHit, protocol.PlayerDied, protocol.PlayerRespawned:
+			events = append(events, p)
+		}
+	}
+	return events
+}
+
+// TestCombat checks that projectiles damage and kill the users they hit, and that the resulting events are sent to the
+// users involved and the users near them.
+func TestCombat(t *testing.T) {
+	tests := []struct {
+		name       string
+		health     uint64
+		shots      []sim.WeaponName
+		wantHealth uint64
+		want       []protocol.Payload
+		// wantMissed is the number of projectiles which don't hit anyone and keep flying
+		wantMissed int
+	}{
+		{
+			name:       "hit",
+			health:     sim.MaxHealth,
+			shots:      []sim.WeaponName{sim.Deagle},
+			wantHealth: 50,
+			want: []protocol.Payload{
+				protocol.PlayerHit{Name: "target", Attacker: "shooter", Weapon: "Deagle", Damage: 50, Health: 50},
+			},
+		},
+		{
+			name:       "multiple hits in a step",
+			health:     sim.MaxHealth,
+			shots:      []sim.WeaponName{sim.M4A1, sim.M4A1},
+			wantHealth: 60,
+			want: []protocol.Payload{
+				protocol.PlayerHit{Name: "target", Attacker: "shooter", Weapon: "M4A1", Damage: 20, Health: 80},
+				protocol.PlayerHit{Name: "target", Attacker: "shooter", Weapon: "M4A1", Damage: 20, Health: 60},
+			},
+		},
+		{
+			name:   "kill",
+			health: 50,
+			shots:  []sim.WeaponName{sim.Deagle},
+			want: []protocol.Payload{
+				protocol.PlayerHit{Name: "target", Attacker: "shooter", Weapon: "Deagle", Damage: 50, Health: 0},
+				protocol.PlayerDied{Name: "target", Killer: "shooter", RespawnDelay: respawnDelay},
+			},
+		},
+		{
+			name:   "damage is capped at remaining health",
+			health: 10,
+			shots:  []sim.WeaponName{sim.Deagle},
+			want: []protocol.Payload{
+				protocol.PlayerHit{Name: "target", Attacker: "shooter", Weapon: "Deagle", Damage: 10, Health: 0},
+				protocol.PlayerDied{Name: "target", Killer: "shooter", RespawnDelay: respawnDelay},
+			},
+		},
+		{
+			name:   "dead users aren't hit",
+			health: 50,
+			shots:  []sim.WeaponName{sim.Deagle, sim.Deagle},
+			want: []protocol.Payload{
+				protocol.PlayerHit{Name: "target", Attacker: "shooter", Weapon: "Deagle", Damage: 50, Health: 0},
+				protocol.PlayerDied{Name: "target", Killer: "shooter", RespawnDelay: respawnDelay},
+			},
+			wantMissed: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, conns := newCombatServer(t)
+			target, _ := srv.userDB.Get("target")
+			target.health = tt.health
+			srv.userDB.Update(target)
+
+			for _, weapon := range tt.shots {
+				fireAt(srv, "target", weapon)
+			}
+			srv.step()
+
+			// the users involved and the bystander near them are told, but the user far away isn't
+			for _, name := range []string{"shooter", "target", "bystander"} {
+				if got := combatEvents(conns[name]); !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("%s: got events %+v, want %+v", name, got, tt.want)
+				}
+			}
+			if got := combatEvents(conns["faraway"]); len(got) > 0 {
+				t.Errorf("faraway: got unexpected events %+v", got)
+			}
+
+			target, _ = srv.userDB.Get("target")
+			if target.health != tt.wantHealth {
+				t.Errorf("got health %d, want %d", target.health, tt.wantHealth)
+			}
+			// projectiles which hit are removed
+			if got := len(srv.projectileDB.projectiles); got != tt.wantMissed {
+				t.Errorf("got %d projectiles remaining, want %d", got, tt.wantMissed)
+			}
+		})
+	}
+}
+
+// TestRespawn checks that killed users respawn with full health once the respawn delay has passed, and not before.
+func TestRespawn(t *testing.T) {
+	srv, conns := newCombatServer(t)
+	target, _ := srv.userDB.Get("target")
+	target.health = 1
+	srv.userDB.Update(target)
+
+	fireAt(srv, "target", sim.Deagle)
+	srv.step()
+	died := srv.simTime
+	if events := combatEvents(conns["target"]); len(events) != 2 {
+		t.Fatalf("expected the target to be hit and killed, got %+v", events)
+	}
+
+	for !srv.simTime.After(died.Add(respawnDelay + time.Second)) {
+		srv.step()
+		events := combatEvents(conns["target"])
+		if srv.simTime.Before(died.Add(respawnDelay)) {
+			if len(events) > 0 {
+				t.Fatalf("got %+v %s after dying, before the respawn delay", events, srv.simTime.Sub(died))
+			}
+			target, _ := srv.userDB.Get("target")
+			if !target.dead() {
+				t.Fatalf("target respawned %s after dying", srv.simTime.Sub(died))
+			}
+			continue
+		}
+
+		if len(events) != 1 {
+			t.Fatalf("expected the target to respawn %s after dying, got %+v", srv.simTime.Sub(died), events)
+		}
+		respawned, ok := events[0].(protocol.PlayerRespawned)
+		if !ok || respawned.Player.Name != "target" || respawned.Player.Health != sim.MaxHealth {
+			t.Fatalf("unexpected respawn event: %+v", events[0])
+		}
+		target, _ := srv.userDB.Get("target")
+		if target.dead() || target.health != sim.MaxHealth || len(target.history) > 0 {
+			t.Errorf("unexpected respawned target: health %d, %d history samples", target.health,
+				len(target.history))
+		}
+		return
+	}
+	t.Fatal("target never respawned")
+}
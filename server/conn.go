@@ -0,0 +1,235 @@
+package server
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/jemgunay/procedural-game/protocol"
+)
+
+const (
+	// outboxSize is the number of messages which can be queued to be written to a client.
+	outboxSize = 256
+	// maxStallDuration is how long a client's outbound queue can stay full before the client is evicted.
+	maxStallDuration = time.Second * 3
+)
+
+// errOutboxFull is returned when a message which can't be dropped is sent to a client whose outbound queue is full.
+var errOutboxFull = errors.New("outbound queue is full")
+
+// dropPolicy determines what happens to a message sent to a client whose outbound queue is full.
+type dropPolicy int
+
+const (
+	// neverDrop messages are always delivered, such as joins and deaths which the client can't recover from missing.
+	// A client which can't keep up with them is evicted.
+	neverDrop dropPolicy = iota
+	// dropStale messages are superseded by newer messages of the same type, such as snapshots, so the oldest queued
+	// message of the same type is dropped to make room.
+	dropStale
+)
+
+// dropPolicyFor returns the drop policy for a message.
+func dropPolicyFor(p protocol.Payload) dropPolicy {
+	switch p.(type) {
+	case protocol.Snapshot, protocol.Vitals:
+		return dropStale
+	}
+	return neverDrop
+}
+
+// ConnStats are the metrics of a client connection's outbound queue, along with its round trip time.
+type ConnStats struct {
+	// Queued is the number of messages waiting to be written, and PeakQueued is the most there have been at once.
+	Queued     int
+	PeakQueued int
+	// Sent is the number of messages written, Dropped is the number of messages dropped because the queue was full
+	// and WriteErrors is the number of messages which failed to be written.
+	Sent        uint64
+	Dropped     uint64
+	WriteErrors uint64
+	// RTT is the round trip time measured by the latest heartbeat, or zero if none has been answered yet.
+	RTT time.Duration
+}
+
+// clientConn is a client connection with a bounded outbound queue, which is written to the network by a dedicated
+// writer goroutine so that the simulation goroutine never waits on a slow client. Only Send and Close are queued, the
+// remaining methods are those of the underlying connection.
+type clientConn struct {
+	protocol.Conn
+	log     *slog.Logger
+	metrics *metrics
+
+	mu    sync.Mutex
+	ready *sync.Cond
+	queue []protocol.Payload
+	// fullSince is when the queue last became full, or zero if it has since had room, and overflowed is set if a
+	// message which can't be dropped didn't fit in it
+	fullSince  time.Time
+	overflowed bool
+	closed     bool
+	stats      ConnStats
+}
+
+// newClientConn wraps a connection in a clientConn, which logs to log and counts the messages it drops in metrics.
+// Queued messages aren't written until the writer is started.
+func newClientConn(conn protocol.Conn, log *slog.Logger, m *metrics) *clientConn {
+	c := &clientConn{
+		Conn:    conn,
+		log:     log,
+		metrics: m,
+		queue:   make([]protocol.Payload, 0, outboxSize),
+	}
+	c.ready = sync.NewCond(&c.mu)
+	return c
+}
+
+// Send queues a payload to be written to the client. If the queue is full, the payload's drop policy determines
+// whether a message is dropped to make room, or an error is returned and the client should be evicted.
+func (c *clientConn) Send(p protocol.Payload) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return net.ErrClosed
+	}
+
+	if len(c.queue) >= outboxSize {
+		if c.fullSince.IsZero() {
+			c.fullSince = time.Now()
+		}
+		if dropPolicyFor(p) == neverDrop {
+			c.overflowed = true
+			return errOutboxFull
+		}
+
+		// drop the oldest queued message the payload supersedes, or the payload itself if there are none
+		c.stats.Dropped++
+		c.metrics.messageDropped("out", "queue_full", p.Type())
+		i := 0
+		for i < len(c.queue) && c.queue[i].Type() != p.Type() {
+			i++
+		}
+		if i == len(c.queue) {
+			return nil
+		}
+		c.queue = append(c.queue[:i], c.queue[i+1:]...)
+	}
+
+	c.queue = append(c.queue, p)
+	if len(c.queue) > c.stats.PeakQueued {
+		c.stats.PeakQueued = len(c.queue)
+	}
+	c.ready.Signal()
+	return nil
+}
+
+// Close closes the connection once the messages which have already been queued are written.
+func (c *clientConn) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	c.ready.Signal()
+	c.mu.Unlock()
+	return nil
+}
+
+// evict closes the connection immediately, discarding any queued messages.
+func (c *clientConn) evict() {
+	c.mu.Lock()
+	c.closed = true
+	c.queue = nil
+	c.ready.Signal()
+	c.mu.Unlock()
+	// closing the underlying connection unblocks a write to an unresponsive client
+	c.Conn.Close()
+}
+
+// stalled determines whether the client isn't keeping up with the messages sent to it at time now, i.e. a message
+// which can't be dropped didn't fit in its queue, or its queue has been full for longer than maxStallDuration.
+func (c *clientConn) stalled(now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.overflowed || (!c.fullSince.IsZero() && now.Sub(c.fullSince) > maxStallDuration)
+}
+
+// Stats returns the metrics of the connection's outbound queue.
+func (c *clientConn) Stats() ConnStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Queued = len(c.queue)
+	return stats
+}
+
+// write writes queued messages to the connection until it is closed and the queue is empty. If a write fails, the
+// connection is closed immediately and the remaining messages are discarded, as a partially written frame would corrupt
+// the messages written after it. Closing the connection ends its reader, which disconnects the user. It is run on the
+// connection's writer goroutine.
+func (c *clientConn) write() {
+	defer c.Conn.Close()
+
+	for {
+		c.mu.Lock()
+		for len(c.queue) == 0 && !c.closed {
+			c.ready.Wait()
+		}
+		if len(c.queue) == 0 {
+			c.mu.Unlock()
+			return
+		}
+		p := c.queue[0]
+		c.queue[0] = nil
+		c.queue = c.queue[1:]
+		// the queue is no longer considered full once it has drained by half
+		if len(c.queue) <= outboxSize/2 {
+			c.fullSince = time.Time{}
+		}
+		c.mu.Unlock()
+
+		err := c.Conn.Send(p)
+
+		c.mu.Lock()
+		if err != nil {
+			c.stats.WriteErrors++
+			c.closed = true
+			c.queue = nil
+			c.mu.Unlock()
+			c.log.Warn("failed to write message, closing connection", "type", p.Type(), "err", err)
+			return
+		}
+		c.stats.Sent++
+		c.mu.Unlock()
+	}
+}
+
+// evictStalled disconnects the users whose clients aren't keeping up with the messages sent to them at time now, so
+// that a stalled client can't hold up the messages queued for it forever. The wall clock is used rather than the
+// simulation time as queues are drained in real time.
+func (s *Server) evictStalled(now time.Time) {
+	for _, user := range s.userDB.users {
+		conn, ok := user.conn.(*clientConn)
+		if !ok || !conn.stalled(now) {
+			continue
+		}
+		conn.log.Warn("evicting client which isn't keeping up with the messages sent to it")
+		s.userDB.Disconnect(user, now)
+		conn.evict()
+	}
+}
+
+// ConnStats returns the outbound queue metrics and round trip time of every connected user's connection, by username.
+func (s *Server) ConnStats() map[string]ConnStats {
+	stats := make(map[string]ConnStats)
+	s.call(func() {
+		for _, user := range s.userDB.users {
+			if conn, ok := user.conn.(*clientConn); ok {
+				connStats := conn.Stats()
+				connStats.RTT = user.rtt
+				stats[user.name] = connStats
+			}
+		}
+	})
+	return stats
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"log/slog"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jemgunay/procedural-game/protocol"
+)
+
+// blockingConn is a connection whose writes block until they are released.
+type blockingConn struct {
+	discardConn
+	release chan struct{}
+
+	mu   sync.Mutex
+	sent []protocol.Payload
+}
+
+func (c *blockingConn) Send(p protocol.Payload) error {
+	<-c.release
+	c.mu.Lock()
+	c.sent = append(c.sent, p)
+	c.mu.Unlock()
+	return nil
+}
+
+// TestClientConnBackpressure checks that a full outbound queue drops stale messages to make room, and that a client
+// whose queue overflows or stays full is considered stalled.
+func TestClientConnBackpressure(t *testing.T) {
+	conn := &blockingConn{release: make(chan struct{})}
+	c := newClientConn(conn, slog.Default(), newMetrics())
+
+	// fill the queue with snapshots and a join, before any are written
+	if err := c.Send(protocol.UserJoined{Name: "alice"}); err != nil {
+		t.Fatalf("failed to queue join: %s", err)
+	}
+	for tick := uint32(1); tick < outboxSize; tick++ {
+		if err := c.Send(protocol.Snapshot{Tick: tick}); err != nil {
+			t.Fatalf("failed to queue snapshot %d: %s", tick, err)
+		}
+	}
+
+	// the oldest snapshot makes room for newer snapshots, while the join is kept
+	now := time.Now()
+	for tick := uint32(outboxSize); tick < outboxSize+10; tick++ {
+		if err := c.Send(protocol.Snapshot{Tick: tick}); err != nil {
+			t.Fatalf("failed to queue snapshot %d: %s", tick, err)
+		}
+	}
+	stats := c.Stats()
+	if stats.Queued != outboxSize || stats.PeakQueued != outboxSize || stats.Dropped != 10 {
+		t.Fatalf("unexpected stats after dropping snapshots: %+v", stats)
+	}
+	if c.stalled(now) {
+		t.Fatal("client stalled before the stall duration elapsed")
+	}
+	if !c.stalled(now.Add(maxStallDuration * 2)) {
+		t.Fatal("client not stalled after its queue stayed full")
+	}
+
+	// messages which can't be dropped overflow the queue
+	if err := c.Send(protocol.PlayerDied{Name: "alice"}); err != errOutboxFull {
+		t.Fatalf("got %v queueing a death to a full queue, want %s", err, errOutboxFull)
+	}
+	if !c.stalled(now) {
+		t.Fatal("client not stalled after its queue overflowed")
+	}
+
+	// the queue is written in order once the client catches up
+	close(conn.release)
+	c.Close()
+	c.write()
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if len(conn.sent) != outboxSize {
+		t.Fatalf("got %d messages written, want %d", len(conn.sent), outboxSize)
+	}
+	if conn.sent[0] != (protocol.UserJoined{Name: "alice"}) {
+		t.Fatalf("got first message %+v, want the join", conn.sent[0])
+	}
+	if first := conn.sent[1].(protocol.Snapshot); first.Tick != 11 {
+		t.Fatalf("got first snapshot %d, want 11", first.Tick)
+	}
+	if stats := c.Stats(); stats.Queued != 0 || stats.Sent != outboxSize {
+		t.Fatalf("unexpected stats after writing: %+v", stats)
+	}
+	if err := c.Send(protocol.Snapshot{}); err == nil {
+		t.Fatal("expected sending to a closed connection to fail")
+	}
+}
+
+// TestEvictStalled checks that users whose clients stall are disconnected, while other users are unaffected.
+func TestEvictStalled(t *testing.T) {
+	srv, err := New("test-seed", "", DefaultTickRate)
+	if err != nil {
+		t.Fatalf("failed to create server: %s", err)
+	}
+
+	stalled := newClientConn(&blockingConn{release: make(chan struct{})}, slog.Default(), newMetrics())
+	healthy := newClientConn(discardConn{}, slog.Default(), newMetrics())
+	go healthy.write()
+	defer healthy.Close()
+	srv.userDB.users["stalled"] = User{name: "stalled", health: 100, conn: stalled}
+	srv.userDB.users["healthy"] = User{name: "healthy", health: 100, conn: healthy}
+
+	// the stalled user's queue overflows with the healthy user's deaths
+	for i := 0; i <= outboxSize; i++ {
+		srv.userDB.Broadcast(protocol.PlayerDied{Name: "healthy"}, "healthy")
+	}
+	healthy.Send(protocol.PlayerRespawned{Player: protocol.Vitals{Name: "healthy"}})
+	srv.evictStalled(time.Now())
+
+	if user, _ := srv.userDB.Get("stalled"); user.conn != nil {
+		t.Fatal("stalled user was not evicted")
+	}
+	if user, _ := srv.userDB.Get("healthy"); user.conn == nil {
+		t.Fatal("healthy user was evicted")
+	}
+	// the healthy user is sent the respawn and the stalled user's disconnection
+	if stats := srv.ConnStats(); len(stats) != 1 || stats["healthy"].Sent+uint64(stats["healthy"].Queued) != 2 {
+		t.Fatalf("unexpected connection stats: %+v", stats)
+	}
+}
+
+// failingConn is a connection whose writes fail after a number of successful writes.
+type failingConn struct {
+	discardConn
+	failAfter int
+	sent      []protocol.Payload
+	closed    bool
+}
+
+func (c *failingConn) Send(p protocol.Payload) error {
+	if len(c.sent) >= c.failAfter {
+		return os.ErrDeadlineExceeded
+	}
+	c.sent = append(c.sent, p)
+	return nil
+}
+
+func (c *failingConn) Close() error {
+	c.closed = true
+	return nil
+}
+
+// TestClientConnWriteError checks that a failed write closes the connection rather than writing the messages queued
+// after it, which could follow a partially written frame.
+func TestClientConnWriteError(t *testing.T) {
+	conn := &failingConn{failAfter: 1}
+	c := newClientConn(conn, slog.Default(), newMetrics())
+	for tick := uint32(1); tick <= 3; tick++ {
+		if err := c.Send(protocol.Snapshot{Tick: tick}); err != nil {
+			t.Fatalf("failed to queue snapshot %d: %s", tick, err)
+		}
+	}
+
+	// the writer returns without the connection being closed by the server
+	c.write()
+	if len(conn.sent) != 1 || !conn.closed {
+		t.Fatalf("got %d messages written and closed=%t, want 1 message written and the connection closed",
+			len(conn.sent), conn.closed)
+	}
+	if stats := c.Stats(); stats.Sent != 1 || stats.WriteErrors != 1 || stats.Queued != 0 {
+		t.Fatalf("unexpected stats after a write error: %+v", stats)
+	}
+	if err := c.Send(protocol.Snapshot{Tick: 4}); err == nil {
+		t.Fatal("expected sending after a write error to fail")
+	}
+}
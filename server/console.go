@@ -0,0 +1,196 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// consoleHelp lists the admin console's commands.
+const consoleHelp = `commands:
+  users                    list every user
+  conns                    list the connection stats of every connected user
+  kick <name> [reason]     kick a connected user
+  ban <name> [reason]      ban a username, kicking the user if they're connected
+  unban <name>             lift the ban on a username
+  banip <ip> [reason]      ban an IP address, kicking any users connected from it
+  unbanip <ip>             lift the ban on an IP address
+  bans                     list the banned usernames and IP addresses
+  tp <name> <x> <y>        teleport a connected user
+  health <name> <health>   set the health of a connected user
+  password <name> <pass>   set a user's password, e.g. for users saved before passwords were required
+  say <message>            broadcast an announcement to every connected user
+  tickrate <rate>          change the number of simulation steps per second
+  save                     save the server state
+  shutdown                 shut down the server`
+
+// RunConsole runs the admin console, reading a command per line from in and writing the outcome of each to out until
+// in is exhausted or the shutdown command is entered. It reports whether the shutdown command was entered, in which
+// case the caller is expected to shut down the server.
+func (s *Server) RunConsole(in io.Reader, out io.Writer) bool {
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if line == "shutdown" {
+			return true
+		}
+		if err := s.consoleCommand(out, line); err != nil {
+			fmt.Fprintf(out, "error: %s\n", err)
+		}
+	}
+	return false
+}
+
+// consoleCommand runs a single admin console command, writing its outcome to out.
+func (s *Server) consoleCommand(out io.Writer, line string) error {
+	name, args := cutField(line)
+	switch name {
+	case "help":
+		fmt.Fprintln(out, consoleHelp)
+
+	case "users":
+		users, err := s.Users()
+		if err != nil {
+			return err
+		}
+		for _, user := range users {
+			status := "offline"
+			if user.Online {
+				status = fmt.Sprintf("online from %s, rtt %s", user.IP, user.RTT)
+			}
+			if user.Admin {
+				status += ", admin"
+			}
+			if user.Banned {
+				status += ", banned"
+			}
+			fmt.Fprintf(out, "%s at (%.0f, %.0f) with %d health (%s)\n", user.Name, user.X, user.Y, user.Health, status)
+		}
+		fmt.Fprintf(out, "%d users\n", len(users))
+
+	case "conns":
+		stats := s.ConnStats()
+		names := make([]string, 0, len(stats))
+		for name := range stats {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			stat := stats[name]
+			fmt.Fprintf(out, "%s: %d queued (peak %d), %d sent, %d dropped, %d write errors, rtt %s\n", name,
+				stat.Queued, stat.PeakQueued, stat.Sent, stat.Dropped, stat.WriteErrors, stat.RTT)
+		}
+
+	case "kick", "ban":
+		user, reason := cutField(args)
+		if user == "" {
+			return fmt.Errorf("usage: %s <name> [reason]", name)
+		}
+		if name == "kick" {
+			if reason == "" {
+				reason = "kicked by an admin"
+			}
+			return s.Kick(user, reason)
+		}
+		if reason == "" {
+			reason = "banned by an admin"
+		}
+		return s.Ban(user, reason)
+
+	case "unban":
+		if args == "" {
+			return errors.New("usage: unban <name>")
+		}
+		return s.Unban(args)
+
+	case "banip":
+		ip, reason := cutField(args)
+		if ip == "" {
+			return errors.New("usage: banip <ip> [reason]")
+		}
+		if reason == "" {
+			reason = "banned by an admin"
+		}
+		return s.BanIP(ip, reason)
+
+	case "unbanip":
+		if args == "" {
+			return errors.New("usage: unbanip <ip>")
+		}
+		return s.UnbanIP(args)
+
+	case "bans":
+		names, ips, err := s.Bans()
+		if err != nil {
+			return err
+		}
+		for _, bans := range []map[string]string{names, ips} {
+			banned := make([]string, 0, len(bans))
+			for name := range bans {
+				banned = append(banned, name)
+			}
+			sort.Strings(banned)
+			for _, name := range banned {
+				fmt.Fprintf(out, "%s: %s\n", name, bans[name])
+			}
+		}
+		fmt.Fprintf(out, "%d usernames and %d IP addresses banned\n", len(names), len(ips))
+
+	case "tp":
+		fields := strings.Fields(args)
+		if len(fields) != 3 {
+			return errors.New("usage: tp <name> <x> <y>")
+		}
+		x, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return fmt.Errorf("invalid x coordinate: %s", fields[1])
+		}
+		y, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil {
+			return fmt.Errorf("invalid y coordinate: %s", fields[2])
+		}
+		return s.Teleport(fields[0], x, y)
+
+	case "health":
+		fields := strings.Fields(args)
+		if len(fields) != 2 {
+			return errors.New("usage: health <name> <health>")
+		}
+		health, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid health: %s", fields[1])
+		}
+		return s.SetHealth(fields[0], health)
+
+	case "password":
+		user, password := cutField(args)
+		if user == "" || password == "" {
+			return errors.New("usage: password <name> <password>")
+		}
+		return s.SetPassword(user, password)
+
+	case "say":
+		return s.Announce(args)
+
+	case "tickrate":
+		rate, err := strconv.ParseUint(args, 10, 32)
+		if err != nil {
+			return errors.New("usage: tickrate <rate>")
+		}
+		return s.SetTickRate(uint(rate))
+
+	case "save":
+		return s.Save()
+
+	default:
+		return fmt.Errorf("unknown command %s - try help", name)
+	}
+	return nil
+}
@@ -3,11 +3,13 @@ package server
 import (
 	"errors"
 	"fmt"
+	"log/slog"
 	"math/rand"
-	"net"
-	"sync"
 	"time"
 	"unicode"
+
+	"github.com/jemgunay/procedural-game/protocol"
+	"github.com/jemgunay/procedural-game/sim"
 )
 
 const (
@@ -15,60 +17,111 @@ const (
 	MinUsernameLength = 5
 	// MaxUsernameLength is the maximum username length.
 	MaxUsernameLength = 12
+
+	// maxQueuedInputs is the maximum number of unprocessed input commands retained per user.
+	maxQueuedInputs = 128
+	// maxMoveBudget caps the movement time a user can accumulate while not sending input commands.
+	maxMoveBudget = time.Millisecond * 250
+	// worldSize is the width and height of the area users are spawned within.
+	worldSize = 8000
 )
 
 // User represents a persistent user record.
 type User struct {
-	name string
-	// vitals is a stringified composition of the core player data such as position, health, etc
-	vitals string
+	name   string
 	x, y   float64
 	rot    float64
 	health uint64
 
-	conn   net.Conn
-	exitCh chan struct{}
+	// inputs are the input commands received but not yet simulated
+	inputs []protocol.Input
+	// lastSeq is the sequence number of the last simulated input command
+	lastSeq uint32
+	// moveBudget is the movement time available to the user's input commands, which accumulates in real time to
+	// prevent clients from moving faster than permitted by sending extra inputs
+	moveBudget time.Duration
+	// respawnAt is the time at which a dead user respawns
+	respawnAt time.Time
+	// history is the user's recent positions, used to rewind them when testing projectile hits
+	history positionHistory
+	// armoury is the user's weapons and ammo, used to validate their projectiles
+	armoury armoury
+	// offences is the number of invalid messages received from the user since lastOffence was forgiven
+	offences    int
+	lastOffence time.Time
+	// chatLimit limits the rate of the user's chat messages and commands
+	chatLimit tokenBucket
+	// credential is the user's hashed password and session is their latest session token
+	credential credential
+	session    session
+	// ackedTick is the latest snapshot the user's client has acknowledged, which snapshots are delta-encoded against
+	ackedTick uint32
+	// sent are the recent states of the world sent to the user in snapshots
+	sent *protocol.StateHistory
+	// interest is the set of other players within the user's area of interest
+	interest map[string]struct{}
+	// lastSeen is when a message was last received from the user's client, and rtt is the round trip time of their
+	// connection as measured by the latest heartbeat
+	lastSeen time.Time
+	rtt      time.Duration
+	// clockOffset is the estimated offset of the user's client clock from the server's clock, which their timestamps
+	// are corrected by before being validated, and clockSynced is set once it has been estimated
+	clockOffset time.Duration
+	clockSynced bool
+	// disconnectedAt is when the user last lost their connection, which they can resume within the reconnect grace
+	disconnectedAt time.Time
+
+	conn protocol.Conn
 }
 
-// Send marshals and writes a message to a user's client.
-func (u *User) Send(msg Message) {
+// Vitals returns the core player data such as position, health, etc.
+func (u *User) Vitals() protocol.Vitals {
+	return protocol.Vitals{
+		Name:   u.name,
+		X:      u.x,
+		Y:      u.y,
+		Rot:    u.rot,
+		Health: u.health,
+		Seq:    u.lastSeq,
+	}
+}
+
+// dead determines whether the user is dead and awaiting respawn.
+func (u *User) dead() bool {
+	return u.health == 0
+}
+
+// Send queues a message to be written to a user's client.
+func (u *User) Send(p protocol.Payload) {
 	if u.conn == nil {
 		return
 	}
 
-	rawMsg := msg.Pack()
-	if _, err := u.conn.Write(rawMsg); err != nil {
-		fmt.Printf("failed to write \"%s\" to %s: %s\n", string(rawMsg), u.conn.RemoteAddr(), err)
+	if err := u.conn.Send(p); err != nil {
+		slog.Warn("failed to send message", "user", u.name, "type", p.Type(), "err", err)
 	}
 }
 
-// UserDB is a database of users.
+// UserDB is a database of users. It is only accessed by the server's simulation goroutine, so it isn't locked.
 type UserDB struct {
 	users map[string]User
 	rand  *rand.Rand
-
-	sync.RWMutex
 }
 
-// Get safely retrieves the user corresponding with the provided username. If the user doesn't exist, an empty User is
+// Get retrieves the user corresponding with the provided username. If the user doesn't exist, an empty User is
 // returned.
 func (d *UserDB) Get(username string) (User, bool) {
-	d.RLock()
 	user, ok := d.users[username]
-	d.RUnlock()
 	return user, ok
 }
 
-// Update safely updates a user by its username.
+// Update updates a user by its username.
 func (d *UserDB) Update(user User) {
-	d.Lock()
 	d.users[user.name] = user
-	d.Unlock()
 }
 
 // Broadcast broadcasts a message to all connected users except those in the specified list of exclusion usernames.
-func (d *UserDB) Broadcast(msg Message, excludeUsernames ...string) {
-	d.RLock()
+func (d *UserDB) Broadcast(p protocol.Payload, excludeUsernames ...string) {
 	for _, user := range d.users {
 		skipUser := false
 		// if current user is in exclusion list, then skip sending message to that user
@@ -81,141 +134,215 @@ func (d *UserDB) Broadcast(msg Message, excludeUsernames ...string) {
 		if skipUser {
 			continue
 		}
-		user.Send(msg)
+		user.Send(p)
 	}
-	d.RUnlock()
 }
 
-// Create creates a new user in the user DB given a username and connection.
-func (d *UserDB) Create(username string, conn net.Conn) (User, error) {
-	// create new user at the top of this func so that the conn can be consumed on error
-	newUser := User{
-		name:   username,
-		x:      float64(d.rand.Intn(8000)),
-		y:      float64(d.rand.Intn(8000)),
-		rot:    0.0,
-		health: 100,
-		conn:   conn,
-		exitCh: make(chan struct{}, 1),
-	}
-
-	// validate username
+// validateUsername checks that a username is suitable for a new user.
+func validateUsername(username string) error {
 	switch {
 	case len(username) < MinUsernameLength:
-		return newUser, fmt.Errorf("username must have a minimum length of %d characters", MinUsernameLength)
+		return fmt.Errorf("username must have a minimum length of %d characters", MinUsernameLength)
 	case len(username) > MaxUsernameLength:
-		return newUser, fmt.Errorf("username length must not exceed %d characters", MaxUsernameLength)
+		return fmt.Errorf("username length must not exceed %d characters", MaxUsernameLength)
 	}
 
 	// allow letters, numbers, underscore and hyphen
 	for _, r := range username {
 		if !unicode.IsLetter(r) && !unicode.IsNumber(r) && r != '_' && r != '-' {
-			return newUser, errors.New("username can only contain letters, numbers, underscores and hyphens")
+			return errors.New("username can only contain letters, numbers, underscores and hyphens")
 		}
 	}
+	return nil
+}
 
-	var err error
-	d.RLock()
-	for _, user := range d.users {
-		if user.name == username {
-			err = errors.New("username already taken")
-			break
-		}
-	}
-	d.RUnlock()
-	if err != nil {
-		return newUser, err
+// Create creates a new user in the user DB given a validated username, their hashed password and connection, which is
+// established at time now.
+func (d *UserDB) Create(username string, cred credential, conn protocol.Conn, now time.Time) (User, error) {
+	if _, ok := d.users[username]; ok {
+		return User{}, errors.New("username already taken")
 	}
 
-	// set initial random position
-	newUser.vitals = ConcatVitals(
-		newUser.x,
-		newUser.y,
-		newUser.rot,
-		newUser.health,
-	)
-
-	// insert new user into DB
-	d.Lock()
+	newUser := User{
+		name:       username,
+		x:          float64(d.rand.Intn(worldSize)),
+		y:          float64(d.rand.Intn(worldSize)),
+		rot:        0.0,
+		health:     sim.MaxHealth,
+		armoury:    newArmoury(),
+		credential: cred,
+		lastSeen:   now,
+		conn:       conn,
+	}
 	d.users[newUser.name] = newUser
-	d.Unlock()
 	return newUser, nil
 }
 
-// Connect associates an existing user in the user DB with a new connection.
-func (d *UserDB) Connect(username string, conn net.Conn) (User, error) {
-	d.RLock()
+// Connect associates an existing user in the user DB, who has been authenticated, with a new connection established at
+// time now.
+//
+// Users who authenticated with a session token are reconnecting, so they take over the connection of their previous
+// session if it hasn't been detected as dead yet, and resume their previous session if it was lost within the
+// reconnect grace period. Whether the previous session was resumed is returned.
+func (d *UserDB) Connect(username string, token bool, conn protocol.Conn, now time.Time) (User, bool, error) {
 	user, ok := d.users[username]
-	d.RUnlock()
 	if !ok {
-		return User{}, errors.New("user not found in DB")
+		return User{}, false, errors.New("user not found in DB")
 	}
 
 	// check user is not already connected to prevent kicking off different client
+	resumed := token && (user.conn != nil || now.Sub(user.disconnectedAt) <= reconnectGrace)
 	if user.conn != nil {
-		return User{}, errors.New("user already connected")
+		if !token {
+			return User{}, false, errors.New("user already connected")
+		}
+		slog.Info("user reconnected, closing their previous connection", "user", username)
+		old := user.conn
+		d.Disconnect(user, now)
+		closeNow(old)
+		user = d.users[username]
 	}
 
-	// update connection
+	// update connection - the client starts with no snapshots or players on each connection, and with a fresh armoury
+	// and input sequence unless it is resuming its previous session
 	user.conn = conn
-	d.Lock()
+	user.lastSeen = now
+	user.rtt = 0
+	user.clockSynced = false
+	if !resumed {
+		user.armoury = newArmoury()
+		user.lastSeq = 0
+	}
+	user.ackedTick = 0
+	user.sent = nil
+	user.interest = nil
 	d.users[username] = user
-	d.Unlock()
-	return user, nil
+	return user, resumed, nil
 }
 
-// Disconnect clears the reference to a user's connection.
-func (d *UserDB) Disconnect(user User) {
-	// clear connection reference before updating DB
-	user.conn = nil
-	d.Lock()
-	// set connection to nil
-	d.users[user.name] = user
-	d.Unlock()
+// Disconnect clears the reference to a user's connection, which was lost at time now.
+func (d *UserDB) Disconnect(user User, now time.Time) {
+	// clear connection reference and any unprocessed inputs
+	if u, ok := d.users[user.name]; ok {
+		u.disconnectedAt = now
+		u.conn = nil
+		u.inputs = nil
+		u.history = nil
+		u.ackedTick = 0
+		u.sent = nil
+		u.interest = nil
+		d.users[user.name] = u
+	}
 
 	// broadcast user leaving message to all remaining connected users
-	d.Broadcast(Message{
-		Type:  "disconnect",
-		Value: user.name,
-	}, user.name)
+	d.Broadcast(protocol.Disconnect{Name: user.name}, user.name)
+}
+
+// QueueInput queues an input command from a user to be simulated on the next server update. Commands which have
+// already been processed are discarded.
+func (d *UserDB) QueueInput(username string, in protocol.Input) {
+	user, ok := d.users[username]
+	if !ok || in.Seq <= user.lastSeq {
+		return
+	}
+	if n := len(user.inputs); n > 0 && in.Seq <= user.inputs[n-1].Seq {
+		return
+	}
+	if len(user.inputs) >= maxQueuedInputs {
+		slog.Warn("dropping input: too many queued inputs", "user", username)
+		return
+	}
+	user.inputs = append(user.inputs, in)
+	d.users[username] = user
+}
+
+// ProcessInputs simulates the queued input commands of every user.
+func (d *UserDB) ProcessInputs(dt time.Duration) {
+	for name, user := range d.users {
+		user.moveBudget += dt
+		if user.moveBudget > maxMoveBudget {
+			user.moveBudget = maxMoveBudget
+		}
+
+		for _, in := range user.inputs {
+			// dead users can't move, but their inputs are still acknowledged
+			if user.dead() {
+				user.lastSeq = in.Seq
+				continue
+			}
+
+			// truncate inputs which exceed the available movement time
+			if in.Duration > user.moveBudget {
+				in.Duration = user.moveBudget
+			}
+			if in.Duration > 0 {
+				user.moveBudget -= in.Duration
+			}
+
+			user.x, user.y = sim.Move(user.x, user.y, in)
+			user.rot = sim.Aim(user.rot, in)
+			user.lastSeq = in.Seq
+		}
+
+		user.inputs = user.inputs[:0]
+		d.users[name] = user
+	}
 }
 
 // Projectile represents a server projectile instance.
 type Projectile struct {
+	id             uint32
 	owner          string
+	weapon         sim.Weapon
 	x, y           float64
 	startX, startY float64
 	velX, velY     float64
 	spawnTime      time.Time
 	ttl            time.Duration
+	// rewind is how far back in time users are rewound when testing whether the projectile hits them
+	rewind time.Duration
+}
+
+// payload creates the message describing the projectile to clients.
+func (p *Projectile) payload() protocol.Projectile {
+	return protocol.Projectile{
+		ID:        p.id,
+		Owner:     p.owner,
+		Weapon:    string(p.weapon.Name),
+		SpawnTime: p.spawnTime.UnixNano(),
+		StartX:    p.startX,
+		StartY:    p.startY,
+		VelX:      p.velX,
+		VelY:      p.velY,
+		TTL:       p.ttl,
+	}
 }
 
-// ProjectileDB is a database of projectiles.
+// ProjectileDB is a database of projectiles. It is only accessed by the server's simulation goroutine, so it isn't
+// locked.
 type ProjectileDB struct {
 	projectiles []Projectile
-
-	sync.RWMutex
+	// lastID is the ID assigned to the most recently created projectile
+	lastID uint32
 }
 
+// Create assigns a projectile a unique ID and inserts it into the DB.
 func (d *ProjectileDB) Create(projectile Projectile) {
-	d.Lock()
+	d.lastID++
+	projectile.id = d.lastID
 	d.projectiles = append(d.projectiles, projectile)
-	d.Unlock()
 }
 
-func (d *ProjectileDB) Update() {
-	d.Lock()
+func (d *ProjectileDB) Update(now time.Time) {
 	var aliveProjectiles []Projectile
 	for _, p := range d.projectiles {
 		// only retain projectiles with unexpired TTLs
-		if !time.Now().UTC().After(p.spawnTime.Add(p.ttl)) {
-			timeAlive := float64(time.Now().UTC().Sub(p.spawnTime)/time.Millisecond) / 100
+		if !now.After(p.spawnTime.Add(p.ttl)) {
+			timeAlive := float64(now.Sub(p.spawnTime)/time.Millisecond) / 100
 			p.x = p.startX + p.velX*timeAlive
 			p.y = p.startY + p.velY*timeAlive
 			aliveProjectiles = append(aliveProjectiles, p)
 		}
 	}
 	d.projectiles = aliveProjectiles
-	d.Unlock()
 }
-
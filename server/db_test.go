@@ -0,0 +1,108 @@
+package server
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/jemgunay/procedural-game/protocol"
+	"github.com/jemgunay/procedural-game/sim"
+)
+
+// TestProcessInputs checks that users are only moved by the movement time they've accumulated, that stale and
+// duplicate input commands are dropped, and that dead users don't move.
+func TestProcessInputs(t *testing.T) {
+	const tick = time.Second / 60
+	right := func(seq uint32, d time.Duration) protocol.Input {
+		return protocol.Input{Seq: seq, MoveX: 1, Duration: d}
+	}
+	distance := func(d time.Duration) float64 {
+		return sim.BaseSpeed * d.Seconds()
+	}
+
+	tests := []struct {
+		name    string
+		health  uint64
+		lastSeq uint32
+		// idle is the number of steps simulated without input before the inputs are queued
+		idle    int
+		inputs  []protocol.Input
+		wantX   float64
+		wantSeq uint32
+	}{
+		{
+			name:    "within budget",
+			health:  sim.MaxHealth,
+			inputs:  []protocol.Input{right(1, tick)},
+			wantX:   distance(tick),
+			wantSeq: 1,
+		},
+		{
+			name:    "inputs beyond budget are truncated",
+			health:  sim.MaxHealth,
+			inputs:  []protocol.Input{right(1, tick), right(2, tick), right(3, tick)},
+			wantX:   distance(tick),
+			wantSeq: 3,
+		},
+		{
+			name:    "budget accumulates while idle",
+			health:  sim.MaxHealth,
+			idle:    5,
+			inputs:  []protocol.Input{right(1, tick*3), right(2, tick*3)},
+			wantX:   distance(tick * 6),
+			wantSeq: 2,
+		},
+		{
+			name:   "accumulated budget is capped",
+			health: sim.MaxHealth,
+			idle:   60,
+			inputs: []protocol.Input{right(1, sim.MaxInputDuration), right(2, sim.MaxInputDuration),
+				right(3, sim.MaxInputDuration), right(4, sim.MaxInputDuration)},
+			wantX:   distance(maxMoveBudget),
+			wantSeq: 4,
+		},
+		{
+			name:    "stale and duplicate inputs are dropped",
+			health:  sim.MaxHealth,
+			lastSeq: 5,
+			idle:    5,
+			inputs: []protocol.Input{right(4, tick), right(5, tick), right(6, tick), right(6, tick), right(8, tick),
+				right(7, tick)},
+			wantX:   distance(tick * 2),
+			wantSeq: 8,
+		},
+		{
+			name:    "dead users don't move",
+			idle:    5,
+			inputs:  []protocol.Input{right(1, tick), right(2, tick)},
+			wantSeq: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := UserDB{users: make(map[string]User), rand: rand.New(rand.NewSource(1))}
+			db.users["walker"] = User{name: "walker", health: tt.health, lastSeq: tt.lastSeq}
+			for i := 0; i < tt.idle; i++ {
+				db.ProcessInputs(tick)
+			}
+
+			for _, in := range tt.inputs {
+				db.QueueInput("walker", in)
+			}
+			db.ProcessInputs(tick)
+
+			walker, _ := db.Get("walker")
+			if math.Abs(walker.x-tt.wantX) > 1e-6 || walker.y != 0 {
+				t.Errorf("walker moved to (%f, %f), want (%f, 0)", walker.x, walker.y, tt.wantX)
+			}
+			if walker.lastSeq != tt.wantSeq {
+				t.Errorf("got last seq %d, want %d", walker.lastSeq, tt.wantSeq)
+			}
+			if len(walker.inputs) != 0 {
+				t.Errorf("expected all queued inputs to be processed, %d remain", len(walker.inputs))
+			}
+		})
+	}
+}
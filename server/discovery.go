@@ -0,0 +1,115 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/jemgunay/procedural-game/protocol"
+)
+
+const (
+	// discoveryReplyBurst and discoveryReplyInterval limit the rate the server replies to discovery queries at, across
+	// all clients, so that a flood of queries can't tie up the simulation goroutine.
+	discoveryReplyBurst    = 32
+	discoveryReplyInterval = time.Second / 16
+	// maxDiscoveryQuerySize is the size of the buffer discovery queries are read into. Larger datagrams are truncated
+	// and fail to decode.
+	maxDiscoveryQuerySize = 2048
+)
+
+// ListenDiscovery answers the discovery queries broadcast by clients browsing the LAN in the background until the
+// server is shut down, describing the server to them by name. The server must already be listening for connections,
+// as clients join it on the port it's listening on. Queries are only answered from private and loopback addresses, so
+// the server isn't advertised beyond the LAN.
+func (s *Server) ListenDiscovery(addr, name string) error {
+	s.listenersMu.Lock()
+	info := s.advertised
+	s.listenersMu.Unlock()
+	if info.Port == 0 {
+		return errors.New("server isn't listening for connections")
+	}
+	info.Name = name
+	info.Version = protocol.Version
+
+	conn, err := net.ListenPacket("udp4", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen for discovery queries on %s: %s", addr, err)
+	}
+
+	s.listenersMu.Lock()
+	select {
+	case <-s.stopChan:
+		s.listenersMu.Unlock()
+		conn.Close()
+		return errShutdown
+	default:
+	}
+	s.discoveryConns = append(s.discoveryConns, conn)
+	s.listenersMu.Unlock()
+
+	slog.Info("listening for discovery queries", "addr", conn.LocalAddr().String(), "name", name)
+	go s.answerDiscovery(conn, info)
+	return nil
+}
+
+// answerDiscovery replies to the discovery queries received on conn with the server's info until conn is closed.
+func (s *Server) answerDiscovery(conn net.PacketConn, info protocol.ServerInfo) {
+	var (
+		buf     = make([]byte, maxDiscoveryQuerySize)
+		limiter tokenBucket
+	)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			slog.Warn("failed to read discovery query", "err", err)
+			continue
+		}
+		if !lanAddr(from) {
+			continue
+		}
+		query, err := protocol.DecodeDiscovery(buf[:n])
+		// ignore replies from other servers, which are also broadcast to if they share a host
+		if err != nil || query.Server != nil {
+			continue
+		}
+		if !limiter.take(time.Now(), discoveryReplyBurst, discoveryReplyInterval) {
+			continue
+		}
+
+		if err := s.call(func() {
+			info.Players = 0
+			for _, user := range s.userDB.users {
+				if user.conn != nil {
+					info.Players++
+				}
+			}
+			info.Seed = s.worldSeed
+		}); err != nil {
+			return
+		}
+		reply, err := protocol.Discovery{Time: query.Time, Server: &info}.Encode()
+		if err != nil {
+			slog.Error("failed to encode discovery reply", "err", err)
+			continue
+		}
+		if _, err := conn.WriteTo(reply, from); err != nil {
+			slog.Warn("failed to write discovery reply", "addr", from.String(), "err", err)
+		}
+	}
+}
+
+// lanAddr determines whether an address is on the local network, i.e. it's a private, link-local or loopback address.
+func lanAddr(addr net.Addr) bool {
+	udpAddr, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return false
+	}
+	ip := udpAddr.IP
+	return ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast()
+}
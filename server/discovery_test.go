@@ -0,0 +1,100 @@
+package server
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/jemgunay/procedural-game/client"
+	"github.com/jemgunay/procedural-game/protocol"
+)
+
+// TestDiscovery checks that clients browsing for servers over loopback find the server, along with its player count,
+// seed and ping, and can join it at the address discovered.
+func TestDiscovery(t *testing.T) {
+	srv, err := New("test-seed", "", DefaultTickRate)
+	if err != nil {
+		t.Fatalf("failed to create server: %s", err)
+	}
+	defer srv.Shutdown()
+	if err := srv.ListenDiscovery("127.0.0.1:0", "test server"); err == nil {
+		t.Error("expected discovery to require the server to be listening")
+	}
+	if err := srv.Listen("127.0.0.1:0", nil); err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+	if err := srv.ListenDiscovery("127.0.0.1:0", "test server"); err != nil {
+		t.Fatalf("failed to listen for discovery queries: %s", err)
+	}
+	srv.Start()
+	discoveryAddr := srv.discoveryConns[0].LocalAddr().String()
+
+	browser, err := client.Browse(discoveryAddr)
+	if err != nil {
+		t.Fatalf("failed to browse: %s", err)
+	}
+	defer browser.Close()
+
+	// waitForServers waits for the browser to list a server with the expected number of players
+	waitForServers := func(players int) client.DiscoveredServer {
+		t.Helper()
+		deadline := time.Now().Add(testTimeout)
+		for {
+			browser.Refresh()
+			time.Sleep(time.Millisecond * 10)
+			servers := browser.Servers()
+			if len(servers) > 1 {
+				t.Fatalf("expected one server, got %+v", servers)
+			}
+			if len(servers) == 1 && servers[0].Players == players {
+				return servers[0]
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for a server with %d players, got %+v", players, servers)
+			}
+		}
+	}
+	found := waitForServers(0)
+	port := srv.advertised.Port
+	if found.Name != "test server" || found.Seed != "test-seed" || found.TLS || !found.UDP || !found.Compatible() {
+		t.Errorf("unexpected server info %+v", found)
+	}
+	if found.Addr != net.JoinHostPort("127.0.0.1", strconv.Itoa(port)) {
+		t.Errorf("expected server at port %d, got %s", port, found.Addr)
+	}
+	if found.Ping <= 0 || found.Ping > testTimeout {
+		t.Errorf("unexpected ping %s", found.Ping)
+	}
+
+	// join the server at the address discovered
+	c, err := client.Start(found.Addr)
+	if err != nil {
+		t.Fatalf("failed to connect: %s", err)
+	}
+	defer c.Disconnect()
+	c.Send(protocol.Connect{Username: "alice", Password: "password", Version: protocol.Version})
+	waitFor(t, c, func(p protocol.Payload) bool {
+		_, ok := p.(protocol.RegisterSuccess)
+		return ok
+	})
+	waitForServers(1)
+}
+
+// TestLANAddr checks that discovery queries are only answered from addresses on the local network.
+func TestLANAddr(t *testing.T) {
+	tests := map[string]bool{
+		"127.0.0.1":     true,
+		"192.168.1.20":  true,
+		"10.1.2.3":      true,
+		"172.16.0.1":    true,
+		"169.254.10.10": true,
+		"8.8.8.8":       false,
+		"203.0.113.5":   false,
+	}
+	for ip, want := range tests {
+		if got := lanAddr(&net.UDPAddr{IP: net.ParseIP(ip), Port: protocol.DiscoveryPort}); got != want {
+			t.Errorf("%s: got %t, want %t", ip, got, want)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/jemgunay/procedural-game/protocol"
+)
+
+// reconnectGrace is how long after losing their connection a user can reconnect with their session token and resume
+// their previous session.
+const reconnectGrace = time.Second * 30
+
+// heartbeat pings every connected user each heartbeat interval, and disconnects the users who haven't sent any
+// messages within the idle timeout, as their connection has most likely died without being closed. The wall clock is
+// used rather than the simulation time as connections are read in real time.
+func (s *Server) heartbeat(now time.Time) {
+	ping := now.Sub(s.lastPing) >= protocol.HeartbeatInterval
+	if ping {
+		s.lastPing = now
+	}
+
+	for _, user := range s.userDB.users {
+		if user.conn == nil {
+			continue
+		}
+		if idle := now.Sub(user.lastSeen); idle > protocol.IdleTimeout {
+			slog.Info("disconnecting idle user", "user", user.name, "idle", idle.Truncate(time.Second))
+			s.userDB.Disconnect(user, now)
+			closeNow(user.conn)
+			continue
+		}
+		if ping {
+			user.Send(protocol.Ping{Time: now.UnixNano()})
+		}
+	}
+}
+
+// RecordRTT records the round trip time of a user's connection, as measured by a heartbeat.
+func (d *UserDB) RecordRTT(username string, rtt time.Duration) {
+	user, ok := d.users[username]
+	if !ok {
+		return
+	}
+	user.rtt = rtt
+	d.users[username] = user
+}
+
+// closeNow closes a connection without waiting for the messages queued for it to be written.
+func closeNow(conn protocol.Conn) {
+	if c, ok := conn.(*clientConn); ok {
+		c.evict()
+		return
+	}
+	conn.Close()
+}
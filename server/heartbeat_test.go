@@ -0,0 +1,163 @@
+package server
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/jemgunay/procedural-game/protocol"
+	"github.com/jemgunay/procedural-game/sim"
+)
+
+// TestHeartbeat checks that connected users are pinged, that their round trip times are measured from their pongs and
+// that users who go quiet are disconnected.
+func TestHeartbeat(t *testing.T) {
+	srv, err := New("test-seed", "", DefaultTickRate)
+	if err != nil {
+		t.Fatalf("failed to create server: %s", err)
+	}
+	defer srv.Shutdown()
+
+	now := time.Now()
+	alive, idle := &recordConn{}, &recordConn{}
+	srv.userDB.users["alive"] = User{name: "alive", health: sim.MaxHealth, lastSeen: now, conn: alive}
+	srv.userDB.users["idle"] = User{name: "idle", health: sim.MaxHealth, lastSeen: now.Add(-protocol.IdleTimeout * 2),
+		conn: idle}
+	srv.heartbeat(now)
+
+	if user, _ := srv.userDB.Get("idle"); user.conn != nil || !user.disconnectedAt.Equal(now) {
+		t.Fatal("expected idle user to be disconnected")
+	}
+	if len(idle.take()) != 0 {
+		t.Error("expected idle user not to be pinged")
+	}
+	// users are visited in no particular order, so the alive user may be pinged before the idle user disconnects
+	sent := alive.take()
+	if len(sent) != 2 {
+		t.Fatalf("unexpected messages sent to alive user: %+v", sent)
+	}
+	for _, want := range []protocol.Payload{protocol.Disconnect{Name: "idle"}, protocol.Ping{Time: now.UnixNano()}} {
+		if sent[0] != want && sent[1] != want {
+			t.Errorf("expected %+v to be sent to alive user, got %+v", want, sent)
+		}
+	}
+
+	// pings aren't sent again until the heartbeat interval has passed
+	srv.heartbeat(now.Add(protocol.HeartbeatInterval / 2))
+	if sent := alive.take(); len(sent) != 0 {
+		t.Fatalf("unexpected messages sent before heartbeat interval: %+v", sent)
+	}
+
+	// the round trip time is measured from the time of the ping being answered
+	received := now.Add(time.Millisecond * 40)
+	srv.handleCommand(command{username: "alive", conn: alive, msg: protocol.Pong{Time: now.UnixNano()},
+		received: received})
+	user, _ := srv.userDB.Get("alive")
+	if user.rtt != time.Millisecond*40 || !user.lastSeen.Equal(received) {
+		t.Errorf("got rtt %s last seen at %s, want 40ms at %s", user.rtt, user.lastSeen, received)
+	}
+
+	// pongs which don't answer the latest ping aren't timed, so that clients can't inflate their round trip time
+	stale := protocol.Pong{Time: now.Add(-time.Second).UnixNano()}
+	srv.handleCommand(command{username: "alive", conn: alive, msg: stale, received: received})
+	if user, _ := srv.userDB.Get("alive"); user.rtt != time.Millisecond*40 {
+		t.Errorf("got rtt %s after a stale pong, want 40ms", user.rtt)
+	}
+
+	// pings from the client are answered
+	srv.handleCommand(command{username: "alive", conn: alive, msg: protocol.Ping{Time: 1234}, received: received})
+	if sent := alive.take(); len(sent) != 1 || sent[0] != (protocol.Pong{Time: 1234}) {
+		t.Errorf("unexpected reply to ping: %+v", sent)
+	}
+}
+
+// TestResume checks that a user reconnecting with a session token takes over their previous connection and resumes
+// their session within the reconnect grace period.
+func TestResume(t *testing.T) {
+	var ammo sim.Ammo
+	for ammo = range sim.StartingAmmo {
+		break
+	}
+
+	db := UserDB{users: make(map[string]User), rand: rand.New(rand.NewSource(1))}
+	now := time.Now()
+	first, second := &recordConn{}, &recordConn{}
+	if _, err := db.Create("alice", credential{}, first, now); err != nil {
+		t.Fatalf("failed to create user: %s", err)
+	}
+	user, _ := db.Get("alice")
+	user.armoury.ammo[ammo] = 0
+	user.lastSeq = 10
+	db.Update(user)
+
+	// a password can't be used to take over a live connection
+	if _, _, err := db.Connect("alice", false, second, now); err == nil {
+		t.Fatal("expected connecting an already connected user with a password to fail")
+	}
+
+	// a session token takes over a connection which hasn't been detected as dead yet
+	user, resumed, err := db.Connect("alice", true, second, now)
+	if err != nil || !resumed || user.conn != second {
+		t.Fatalf("failed to take over connection: resumed=%t err=%v", resumed, err)
+	}
+	if user.armoury.ammo[ammo] != 0 || user.lastSeq != 10 {
+		t.Error("expected armoury and input sequence to be resumed")
+	}
+
+	// sessions can be resumed within the grace period after losing the connection
+	db.Disconnect(user, now)
+	if _, resumed, err = db.Connect("alice", true, first, now.Add(reconnectGrace)); err != nil || !resumed {
+		t.Fatalf("expected session to be resumed within grace period: resumed=%t err=%v", resumed, err)
+	}
+
+	// but not after it
+	user, _ = db.Get("alice")
+	db.Disconnect(user, now)
+	user, resumed, err = db.Connect("alice", true, second, now.Add(reconnectGrace+time.Second))
+	if err != nil || resumed {
+		t.Fatalf("expected session not to be resumed after grace period: resumed=%t err=%v", resumed, err)
+	}
+	if user.armoury.ammo[ammo] != sim.StartingAmmo[ammo] || user.lastSeq != 0 {
+		t.Error("expected a fresh armoury and input sequence")
+	}
+}
+
+// TestHandshakeTimeout checks that connections which don't establish a user in time are closed, releasing their
+// address's connection slot.
+func TestHandshakeTimeout(t *testing.T) {
+	srv, err := New("test-seed", "", DefaultTickRate)
+	if err != nil {
+		t.Fatalf("failed to create server: %s", err)
+	}
+	defer srv.Shutdown()
+	srv.handshakeTimeout = time.Millisecond * 50
+	transport := protocol.NewPipeTransport()
+	l, err := transport.Listen("server")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+	srv.Serve(l)
+
+	conn, err := transport.Dial("server")
+	if err != nil {
+		t.Fatalf("failed to dial: %s", err)
+	}
+	defer conn.Close()
+	received := make(chan error, 1)
+	go func() {
+		_, err := conn.Receive()
+		received <- err
+	}()
+	select {
+	case err := <-received:
+		if err == nil {
+			t.Fatal("expected connection to be closed, got a message")
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("connection which didn't complete the handshake wasn't closed")
+	}
+
+	waitForServer(t, srv, func() bool {
+		return len(srv.connLimits.open) == 0
+	})
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"time"
+
+	"github.com/jemgunay/procedural-game/sim"
+)
+
+const (
+	// maxRewind caps how far back in time users are rewound when testing projectile hits, so that clients with very
+	// high latency can't hit users who have long since moved out of the way.
+	maxRewind = time.Millisecond * 500
+	// historyRetention is how long position samples are retained for. It exceeds maxRewind so that there is always a
+	// sample either side of the oldest rewind time.
+	historyRetention = maxRewind + time.Millisecond*100
+)
+
+// positionSample is a user's position at a point in server time.
+type positionSample struct {
+	time time.Time
+	x, y float64
+}
+
+// positionHistory is a time ordered record of a user's recent positions.
+type positionHistory []positionSample
+
+// record appends a position sample taken at time t and discards the samples which are too old to be rewound to. The
+// updated history is returned.
+func (h positionHistory) record(t time.Time, x, y float64) positionHistory {
+	cutoff := t.Add(-historyRetention)
+	for len(h) > 0 && h[0].time.Before(cutoff) {
+		h = h[1:]
+	}
+
+	// replace samples which aren't strictly newer than the latest sample to keep the history ordered
+	for len(h) > 0 && !t.After(h[len(h)-1].time) {
+		h = h[:len(h)-1]
+	}
+	return append(h, positionSample{time: t, x: x, y: y})
+}
+
+// at returns the position at time t, interpolated between the samples either side of it. Times outside of the
+// recorded history are clamped to the oldest or latest sample. false is returned if the history is empty.
+func (h positionHistory) at(t time.Time) (float64, float64, bool) {
+	if len(h) == 0 {
+		return 0, 0, false
+	}
+	if !t.After(h[0].time) {
+		return h[0].x, h[0].y, true
+	}
+
+	for i := 1; i < len(h); i++ {
+		next := h[i]
+		if t.After(next.time) {
+			continue
+		}
+		prev := h[i-1]
+		frac := float64(t.Sub(prev.time)) / float64(next.time.Sub(prev.time))
+		return prev.x + (next.x-prev.x)*frac, prev.y + (next.y-prev.y)*frac, true
+	}
+
+	latest := h[len(h)-1]
+	return latest.x, latest.y, true
+}
+
+// RecordHistory records the current position of every connected, alive user at time now.
+func (d *UserDB) RecordHistory(now time.Time) {
+	for name, user := range d.users {
+		if user.conn == nil || user.dead() {
+			continue
+		}
+		user.history = user.history.record(now, user.x, user.y)
+		d.users[name] = user
+	}
+}
+
+// lagCompensation determines how far back in time users should be rewound for a projectile fired by a user whose
+// connection has a round trip time of rtt, so that hits are tested against the positions the shooter saw when firing.
+// This is the projectile's transit time to the server, estimated as half the round trip time measured by the server's
+// heartbeats, plus the delay at which clients render remote players, capped at maxRewind. The projectile's client
+// supplied spawn time isn't used, as it is subject to clock skew and spoofing.
+func lagCompensation(rtt time.Duration) time.Duration {
+	transit := rtt / 2
+	if transit < 0 {
+		transit = 0
+	}
+
+	rewind := transit + sim.InterpolationDelay
+	if rewind > maxRewind {
+		rewind = maxRewind
+	}
+	return rewind
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/jemgunay/procedural-game/protocol"
+	"github.com/jemgunay/procedural-game/sim"
+)
+
+// testTickRate is the server update rate used when simulating lag compensation.
+const testTickRate = time.Second / 60
+
+// newTestUserDB creates a user DB containing a connected shooter and a connected target.
+func newTestUserDB() *UserDB {
+	d := &UserDB{
+		users: make(map[string]User),
+		rand:  rand.New(rand.NewSource(1)),
+	}
+	for _, name := range []string{"shooter", "target"} {
+		d.users[name] = User{
+			name:   name,
+			health: sim.MaxHealth,
+			conn:   &protocol.StreamConn{},
+		}
+	}
+	return d
+}
+
+// simulateShot simulates a target strafing past a shooter whose view of the target lags behind the server by their
+// one-way latency plus the interpolation delay, where the round trip time is twice the latency. The shooter fires
+// straight up, aiming exactly where they will see the target when the projectile arrives. It reports whether the shot
+// hit the target.
+func simulateShot(t *testing.T, latency time.Duration, compensate bool) bool {
+	const (
+		targetStart = 1000.0
+		targetY     = 500.0
+		flightTime  = time.Millisecond * 500
+	)
+
+	// the target moves right at full speed from the start
+	start := time.Unix(0, 0).UTC()
+	targetX := func(t time.Time) float64 {
+		return targetStart + sim.BaseSpeed*t.Sub(start).Seconds()
+	}
+
+	fireTime := start.Add(time.Second)
+	perceived := fireTime.Add(flightTime - latency - sim.InterpolationDelay)
+	shot := Projectile{
+		owner:     "shooter",
+		weapon:    sim.Weapons[sim.Deagle],
+		startX:    targetX(perceived),
+		velY:      targetY / float64(flightTime/(time.Millisecond*100)),
+		spawnTime: fireTime,
+		ttl:       time.Second * 5,
+	}
+	received := fireTime.Add(latency)
+	if compensate {
+		shot.rewind = lagCompensation(latency * 2)
+	}
+
+	users := newTestUserDB()
+	var (
+		projectiles ProjectileDB
+		fired       bool
+	)
+	for now := start; now.Before(fireTime.Add(time.Second)); now = now.Add(testTickRate) {
+		target := users.users["target"]
+		target.x, target.y = targetX(now), targetY
+		users.users["target"] = target
+		users.RecordHistory(now)
+
+		if !fired && !now.Before(received) {
+			projectiles.Create(shot)
+			fired = true
+		}
+		projectiles.Update(now)
+
+		var events []protocol.Payload
+		projectiles.projectiles, events = users.Hit(now, projectiles.projectiles)
+		if len(events) == 0 {
+			continue
+		}
+		if hit, ok := events[0].(protocol.PlayerHit); !ok || hit.Name != "target" || hit.Attacker != "shooter" {
+			t.Fatalf("unexpected hit event: %+v", events[0])
+		}
+		return true
+	}
+	return false
+}
+
+func TestLagCompensatedHits(t *testing.T) {
+	for _, latency := range []time.Duration{50, 100, 150, 200} {
+		latency *= time.Millisecond
+		if !simulateShot(t, latency, true) {
+			t.Errorf("%s latency: accurate shot missed the target", latency)
+		}
+	}
+
+	// without rewinding, the target has moved out of the way by the time the shot is tested
+	if simulateShot(t, time.Millisecond*200, false) {
+		t.Error("uncompensated shot unexpectedly hit the target")
+	}
+}
+
+func TestPositionHistory(t *testing.T) {
+	start := time.Unix(0, 0).UTC()
+	var h positionHistory
+	if _, _, ok := h.at(start); ok {
+		t.Fatal("expected empty history to have no position")
+	}
+
+	for i := 0; i <= 60; i++ {
+		h = h.record(start.Add(testTickRate*time.Duration(i)), float64(i)*10, 0)
+	}
+	// samples older than the retention period are discarded
+	if oldest := h[0].time; oldest.Before(start.Add(testTickRate * 60).Add(-historyRetention)) {
+		t.Fatalf("expected samples older than %s to be discarded, oldest is at %s", historyRetention, oldest)
+	}
+
+	tests := []struct {
+		name  string
+		t     time.Time
+		wantX float64
+	}{
+		{"exact sample", start.Add(testTickRate * 50), 500},
+		{"between samples", start.Add(testTickRate*50 + testTickRate/2), 505},
+		{"after latest", start.Add(time.Second * 5), 600},
+		{"before oldest", start, h[0].x},
+	}
+	for _, tt := range tests {
+		x, _, ok := h.at(tt.t)
+		if !ok || x < tt.wantX-1e-6 || x > tt.wantX+1e-6 {
+			t.Errorf("%s: got x=%f, want %f", tt.name, x, tt.wantX)
+		}
+	}
+}
+
+func TestLagCompensationCap(t *testing.T) {
+	tests := []struct {
+		rtt  time.Duration
+		want time.Duration
+	}{
+		{time.Millisecond * 200, time.Millisecond*100 + sim.InterpolationDelay},
+		{0, sim.InterpolationDelay},
+		{-time.Second, sim.InterpolationDelay},
+		{time.Second * 3, maxRewind},
+	}
+	for _, tt := range tests {
+		if got := lagCompensation(tt.rtt); got != tt.want {
+			t.Errorf("rtt %s: got rewind %s, want %s", tt.rtt, got, tt.want)
+		}
+	}
+}
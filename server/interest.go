@@ -0,0 +1,119 @@
+package server
+
+import (
+	"math"
+
+	"github.com/jemgunay/procedural-game/protocol"
+	"github.com/jemgunay/procedural-game/sim"
+)
+
+const (
+	// DefaultInterestRadius is the default distance from a user's player within which they are sent updates about
+	// other players and projectiles. It comfortably exceeds the distance visible on screen.
+	DefaultInterestRadius = 2000.0
+	// interestCellSize is the width and height of the cells of the spatial index, which are aligned to world tiles.
+	interestCellSize = sim.TileSize * 8
+)
+
+// cell is the coordinate of a cell within the spatial index.
+type cell struct {
+	x, y int
+}
+
+// cellOf returns the cell containing a position.
+func cellOf(x, y float64) cell {
+	return cell{
+		x: int(math.Floor(x / interestCellSize)),
+		y: int(math.Floor(y / interestCellSize)),
+	}
+}
+
+// entity is a player or projectile within a cell of the spatial index.
+type entity struct {
+	x, y float64
+	// name is the name of the player, or empty for a projectile
+	name string
+	id   uint32
+}
+
+// world is the state of the world at the end of a step, spatially indexed so that the state within each user's area of
+// interest can be found without considering every player and projectile.
+type world struct {
+	state protocol.State
+	cells map[cell][]entity
+}
+
+// newWorld creates an empty world for the provided step.
+func newWorld(tick uint32) *world {
+	return &world{
+		state: protocol.NewState(tick),
+		cells: make(map[cell][]entity),
+	}
+}
+
+// addPlayer adds a player's vitals to the world.
+func (w *world) addPlayer(vitals protocol.Vitals) {
+	w.state.Players[vitals.Name] = vitals
+	c := cellOf(vitals.X, vitals.Y)
+	w.cells[c] = append(w.cells[c], entity{x: vitals.X, y: vitals.Y, name: vitals.Name})
+}
+
+// addProjectile adds a projectile at its current position to the world.
+func (w *world) addProjectile(p *Projectile) {
+	w.state.Projectiles[p.id] = p.payload()
+	c := cellOf(p.x, p.y)
+	w.cells[c] = append(w.cells[c], entity{x: p.x, y: p.y, id: p.id})
+}
+
+// interest returns the state of the world within radius of a position.
+func (w *world) interest(x, y, radius float64) protocol.State {
+	state := protocol.NewState(w.state.Tick)
+	min, max := cellOf(x-radius, y-radius), cellOf(x+radius, y+radius)
+	for cx := min.x; cx <= max.x; cx++ {
+		for cy := min.y; cy <= max.y; cy++ {
+			for _, e := range w.cells[cell{x: cx, y: cy}] {
+				if math.Hypot(e.x-x, e.y-y) > radius {
+					continue
+				}
+				if e.name != "" {
+					state.Players[e.name] = w.state.Players[e.name]
+				} else {
+					state.Projectiles[e.id] = w.state.Projectiles[e.id]
+				}
+			}
+		}
+	}
+	return state
+}
+
+// SetInterestRadius sets the distance from a user's player within which they are sent updates about other players and
+// projectiles. It must be called before the server is started.
+func (s *Server) SetInterestRadius(radius float64) {
+	s.interestRadius = radius
+}
+
+// eventSubjects returns the names of the players a simulation event is about.
+func eventSubjects(event protocol.Payload) []string {
+	switch e := event.(type) {
+	case protocol.PlayerHit:
+		return []string{e.Name, e.Attacker}
+	case protocol.PlayerDied:
+		return []string{e.Name, e.Killer}
+	case protocol.PlayerRespawned:
+		return []string{e.Player.Name}
+	}
+	return nil
+}
+
+// BroadcastInterested sends an event to the connected users who are interested in any of the named players, as well as
+// to the named players themselves.
+func (d *UserDB) BroadcastInterested(p protocol.Payload, names ...string) {
+	for _, user := range d.users {
+		for _, name := range names {
+			if _, ok := user.interest[name]; ok || user.name == name {
+				user.Send(p)
+				break
+			}
+		}
+	}
+}
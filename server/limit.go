@@ -0,0 +1,202 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/jemgunay/procedural-game/protocol"
+)
+
+const (
+	// maxConnsPerIP is the number of connections which can be open from a single IP address at once.
+	maxConnsPerIP = 8
+	// connAttemptBurst is the number of connections which can be opened from a single IP address at once, which are
+	// replenished at one per connAttemptInterval.
+	connAttemptBurst    = 10
+	connAttemptInterval = time.Second * 2
+	// connAttemptsPruneInterval is how often the connection attempt records of addresses which haven't connected
+	// recently are forgotten.
+	connAttemptsPruneInterval = time.Minute
+	// droppedLogInterval is how often the messages dropped from a connection for being sent too quickly are logged.
+	droppedLogInterval = time.Second * 10
+)
+
+// tokenBucket limits the rate of an action, allowing bursts of actions which are replenished at a fixed interval.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take takes a token from a bucket holding up to burst tokens which are replenished at one per interval, reporting
+// whether there was one to take at time now. A new bucket starts full.
+func (b *tokenBucket) take(now time.Time, burst int, interval time.Duration) bool {
+	switch {
+	case b.last.IsZero():
+		b.tokens = float64(burst)
+		b.last = now
+	case now.After(b.last):
+		b.tokens += float64(now.Sub(b.last)) / float64(interval)
+		if b.tokens > float64(burst) {
+			b.tokens = float64(burst)
+		}
+		b.last = now
+	}
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// rateLimit is the rate at which an action can be taken, allowing bursts of actions which are replenished at one per
+// interval.
+type rateLimit struct {
+	burst    int
+	interval time.Duration
+}
+
+// messageLimits are the rates at which a connection can send each type of message. Inputs and snapshot acks are sent
+// every frame and snapshot respectively, and projectiles are also limited by the fire rate of each weapon. Message
+// types without a limit are limited to defaultMessageLimit.
+var messageLimits = map[protocol.Type]rateLimit{
+	// connecting is deliberately slow as the credentials are hashed
+	protocol.TypeConnect:     {burst: 3, interval: time.Second * 5},
+	protocol.TypeInput:       {burst: 240, interval: time.Second / 240},
+	protocol.TypeSnapshotAck: {burst: 240, interval: time.Second / 240},
+	protocol.TypeProjectile:  {burst: 30, interval: time.Second / 30},
+	protocol.TypePing:        {burst: 5, interval: protocol.HeartbeatInterval / 2},
+	protocol.TypePong:        {burst: 5, interval: protocol.HeartbeatInterval / 2},
+}
+
+// defaultMessageLimit is the rate at which a connection can send the message types which aren't in messageLimits.
+var defaultMessageLimit = rateLimit{burst: 20, interval: time.Second / 10}
+
+// messageLimiter limits the rate at which each type of message is read from a connection. It is only used by the
+// connection's reader goroutine, so messages over the limit are dropped before reaching the simulation goroutine.
+type messageLimiter struct {
+	log     *slog.Logger
+	metrics *metrics
+	buckets map[protocol.Type]*tokenBucket
+	// dropped is the number of messages of each type dropped since they were last logged at lastLogged
+	dropped    map[protocol.Type]uint64
+	lastLogged time.Time
+}
+
+// newMessageLimiter creates a message limiter for a connection, which logs to log and counts the messages it drops in
+// metrics.
+func newMessageLimiter(log *slog.Logger, m *metrics) *messageLimiter {
+	return &messageLimiter{
+		log:     log,
+		metrics: m,
+		buckets: make(map[protocol.Type]*tokenBucket),
+		dropped: make(map[protocol.Type]uint64),
+	}
+}
+
+// allow reports whether a message of type t received at time now is within the connection's limit for that type.
+func (l *messageLimiter) allow(t protocol.Type, now time.Time) bool {
+	limit, ok := messageLimits[t]
+	if !ok {
+		limit = defaultMessageLimit
+	}
+	bucket, ok := l.buckets[t]
+	if !ok {
+		bucket = &tokenBucket{}
+		l.buckets[t] = bucket
+	}
+
+	if bucket.take(now, limit.burst, limit.interval) {
+		return true
+	}
+
+	// dropped messages are logged periodically rather than individually, so that floods don't also flood the log
+	l.metrics.messageDropped("in", "rate_limited", t)
+	l.dropped[t]++
+	if now.Sub(l.lastLogged) >= droppedLogInterval {
+		for droppedType, count := range l.dropped {
+			l.log.Warn("dropped messages sent too quickly", "type", droppedType, "count", count)
+		}
+		l.dropped = make(map[protocol.Type]uint64)
+		l.lastLogged = now
+	}
+	return false
+}
+
+// connLimiter tracks the connections from each IP address, so that the number of connections open from an address
+// and the rate at which they're opened can be limited. It is owned by the simulation goroutine.
+type connLimiter struct {
+	open      map[string]int
+	attempts  map[string]*tokenBucket
+	lastPrune time.Time
+}
+
+// newConnLimiter creates a connection limiter with no connections.
+func newConnLimiter() connLimiter {
+	return connLimiter{
+		open:     make(map[string]int),
+		attempts: make(map[string]*tokenBucket),
+	}
+}
+
+// admit records a connection attempt from an IP address at time now, returning an error if the address has too many
+// connections open or is connecting too quickly. Admitted connections must be released once closed.
+func (l *connLimiter) admit(ip string, now time.Time) error {
+	attempts, ok := l.attempts[ip]
+	if !ok {
+		attempts = &tokenBucket{}
+		l.attempts[ip] = attempts
+	}
+	if !attempts.take(now, connAttemptBurst, connAttemptInterval) {
+		return fmt.Errorf("too many connection attempts from %s, try again later", ip)
+	}
+	if l.open[ip] >= maxConnsPerIP {
+		return fmt.Errorf("too many connections from %s", ip)
+	}
+	l.open[ip]++
+	return nil
+}
+
+// release records that a connection admitted from an IP address has closed.
+func (l *connLimiter) release(ip string) {
+	if l.open[ip] <= 1 {
+		delete(l.open, ip)
+		return
+	}
+	l.open[ip]--
+}
+
+// prune forgets the connection attempts of addresses whose attempts have fully replenished by time now, as they are
+// indistinguishable from addresses which have never connected. It only does so once per connAttemptsPruneInterval.
+func (l *connLimiter) prune(now time.Time) {
+	if now.Sub(l.lastPrune) < connAttemptsPruneInterval {
+		return
+	}
+	l.lastPrune = now
+	for ip, attempts := range l.attempts {
+		if now.Sub(attempts.last) >= connAttemptInterval*connAttemptBurst {
+			delete(l.attempts, ip)
+		}
+	}
+}
+
+// remoteIP gets the IP address from a connection's remote address, or the whole address if it doesn't have a port, as
+// is the case for in-memory pipes.
+func remoteIP(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// admitConn decides whether to serve a new connection from an IP address at time now, returning the reason it's being
+// refused if not.
+func (s *Server) admitConn(ip string, now time.Time) error {
+	if reason, banned := s.bans.ipBanned(ip); banned {
+		return fmt.Errorf("banned: %s", reason)
+	}
+	return s.connLimits.admit(ip, now)
+}
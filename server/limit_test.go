@@ -0,0 +1,84 @@
+package server
+
+import (
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/jemgunay/procedural-game/protocol"
+)
+
+// TestConnLimits checks that connections are refused from banned addresses, from addresses with too many connections
+// open and from addresses connecting too quickly.
+func TestConnLimits(t *testing.T) {
+	srv, err := New("test-seed", "", DefaultTickRate)
+	if err != nil {
+		t.Fatalf("failed to create server: %s", err)
+	}
+	defer srv.Shutdown()
+	if err := srv.BanIP("10.0.0.1", "cheating"); err != nil {
+		t.Fatalf("failed to ban IP: %s", err)
+	}
+
+	now := time.Now()
+	if err := srv.admitConn("10.0.0.1", now); err == nil || err.Error() != "banned: cheating" {
+		t.Errorf("expected banned address to be refused, got %v", err)
+	}
+
+	// an address can only have so many connections open at once
+	for i := 0; i < maxConnsPerIP; i++ {
+		if err := srv.admitConn("10.0.0.2", now); err != nil {
+			t.Fatalf("connection %d refused: %s", i, err)
+		}
+	}
+	if err := srv.admitConn("10.0.0.2", now); err == nil {
+		t.Error("expected connection over the per address limit to be refused")
+	}
+	if err := srv.admitConn("10.0.0.3", now); err != nil {
+		t.Errorf("expected connection from another address to be admitted: %s", err)
+	}
+	srv.connLimits.release("10.0.0.2")
+	if err := srv.admitConn("10.0.0.2", now); err != nil {
+		t.Errorf("expected connection to be admitted after one was released: %s", err)
+	}
+
+	// the connection attempts have now been used up, even though connections have been released
+	for i := 0; i < maxConnsPerIP; i++ {
+		srv.connLimits.release("10.0.0.2")
+	}
+	if err := srv.admitConn("10.0.0.2", now); err == nil {
+		t.Error("expected connection attempts over the rate limit to be refused")
+	}
+	if err := srv.admitConn("10.0.0.2", now.Add(connAttemptInterval)); err != nil {
+		t.Errorf("expected connection attempts to be replenished: %s", err)
+	}
+
+	// idle addresses are forgotten
+	srv.connLimits.prune(now.Add(connAttemptInterval * connAttemptBurst * 2))
+	if len(srv.connLimits.attempts) != 0 {
+		t.Errorf("expected connection attempts to be pruned, got %d", len(srv.connLimits.attempts))
+	}
+}
+
+// TestMessageLimits checks that each type of message is limited to its own rate.
+func TestMessageLimits(t *testing.T) {
+	limiter := newMessageLimiter(slog.Default(), newMetrics())
+	now := time.Now()
+
+	connect := messageLimits[protocol.TypeConnect]
+	for i := 0; i < connect.burst; i++ {
+		if !limiter.allow(protocol.TypeConnect, now) {
+			t.Fatalf("connect %d dropped within burst", i)
+		}
+	}
+	if limiter.allow(protocol.TypeConnect, now) {
+		t.Error("expected connect over the burst to be dropped")
+	}
+	// other message types have their own limits
+	if !limiter.allow(protocol.TypeInput, now) {
+		t.Error("expected input to be allowed")
+	}
+	if !limiter.allow(protocol.TypeConnect, now.Add(connect.interval)) {
+		t.Error("expected connect to be allowed once replenished")
+	}
+}
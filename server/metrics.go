@@ -0,0 +1,238 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jemgunay/procedural-game/protocol"
+)
+
+// tickDurationBuckets are the upper bounds, in seconds, of the buckets of the simulation step duration histogram.
+var tickDurationBuckets = []float64{0.0005, 0.001, 0.002, 0.004, 0.008, 0.016, 0.032, 0.064}
+
+// messageCount is the number of messages of a type and their total size in bytes.
+type messageCount struct {
+	messages uint64
+	bytes    uint64
+}
+
+// dropKey identifies the messages dropped in a direction, for a reason, by type.
+type dropKey struct {
+	direction string
+	reason    string
+	msgType   protocol.Type
+}
+
+// metrics are the server's counters, which are updated by the simulation goroutine and every connection's reader and
+// writer goroutines. They implement protocol.Meter so that the messages sent and received over every connection are
+// counted. The server's gauges are read from its state when the metrics are exported.
+type metrics struct {
+	mu       sync.Mutex
+	sent     map[protocol.Type]messageCount
+	received map[protocol.Type]messageCount
+	dropped  map[dropKey]uint64
+	// tickBuckets are the number of steps which took up to each of tickDurationBuckets, or longer for the last
+	tickBuckets  []uint64
+	tickSum      float64
+	tickCount    uint64
+	connsRefused uint64
+}
+
+// newMetrics creates metrics with every counter at zero.
+func newMetrics() *metrics {
+	return &metrics{
+		sent:        make(map[protocol.Type]messageCount),
+		received:    make(map[protocol.Type]messageCount),
+		dropped:     make(map[dropKey]uint64),
+		tickBuckets: make([]uint64, len(tickDurationBuckets)+1),
+	}
+}
+
+// MessageSent records a message written to a client.
+func (m *metrics) MessageSent(t protocol.Type, size int) {
+	m.mu.Lock()
+	count := m.sent[t]
+	count.messages++
+	count.bytes += uint64(size)
+	m.sent[t] = count
+	m.mu.Unlock()
+}
+
+// MessageReceived records a message read from a client.
+func (m *metrics) MessageReceived(t protocol.Type, size int) {
+	m.mu.Lock()
+	count := m.received[t]
+	count.messages++
+	count.bytes += uint64(size)
+	m.received[t] = count
+	m.mu.Unlock()
+}
+
+// messageDropped records a message dropped in a direction, i.e. in or out, for a reason.
+func (m *metrics) messageDropped(direction, reason string, t protocol.Type) {
+	m.mu.Lock()
+	m.dropped[dropKey{direction: direction, reason: reason, msgType: t}]++
+	m.mu.Unlock()
+}
+
+// connRefused records a refused connection.
+func (m *metrics) connRefused() {
+	m.mu.Lock()
+	m.connsRefused++
+	m.mu.Unlock()
+}
+
+// observeTick records the duration of a simulation step.
+func (m *metrics) observeTick(d time.Duration) {
+	seconds := d.Seconds()
+	i := sort.SearchFloat64s(tickDurationBuckets, seconds)
+
+	m.mu.Lock()
+	m.tickBuckets[i]++
+	m.tickSum += seconds
+	m.tickCount++
+	m.mu.Unlock()
+}
+
+// gauges are the metrics read from the server's state when they're exported.
+type gauges struct {
+	connectedUsers  int
+	registeredUsers int
+	openConns       int
+	projectiles     int
+	tickRate        float64
+}
+
+// WriteMetrics writes the server's metrics to w in the Prometheus text exposition format.
+func (s *Server) WriteMetrics(w io.Writer) error {
+	var g gauges
+	if err := s.call(func() {
+		for _, user := range s.userDB.users {
+			if user.conn != nil {
+				g.connectedUsers++
+			}
+		}
+		g.registeredUsers = len(s.userDB.users)
+		for _, open := range s.connLimits.open {
+			g.openConns += open
+		}
+		g.projectiles = len(s.projectileDB.projectiles)
+		g.tickRate = float64(time.Second) / float64(s.tickInterval)
+	}); err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	s.metrics.write(bw, g)
+	return bw.Flush()
+}
+
+// MetricsHandler returns the handler serving the server's metrics in the Prometheus text exposition format.
+func (s *Server) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		if err := s.WriteMetrics(w); err != nil {
+			http.Error(w, err.Error(), adminErrorStatus(err))
+		}
+	})
+}
+
+// ListenMetrics serves the server's metrics over HTTP in the background until the server is shut down. Like the admin
+// API, the metrics are only served on a loopback address, e.g. localhost:9002.
+func (s *Server) ListenMetrics(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", s.MetricsHandler())
+	return s.listenLocal("metrics", addr, mux)
+}
+
+// write writes the metrics along with the gauges to w in the Prometheus text exposition format.
+func (m *metrics) write(w io.Writer, g gauges) {
+	writeMetric(w, "procgame_connected_users", "gauge", "Number of users connected.", float64(g.connectedUsers))
+	writeMetric(w, "procgame_registered_users", "gauge", "Number of users registered.", float64(g.registeredUsers))
+	writeMetric(w, "procgame_open_connections", "gauge", "Number of client connections open.", float64(g.openConns))
+	writeMetric(w, "procgame_projectiles", "gauge", "Number of projectiles in flight.", float64(g.projectiles))
+	writeMetric(w, "procgame_tick_rate_hertz", "gauge", "Number of simulation steps per second.", g.tickRate)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	writeMetric(w, "procgame_connections_refused_total", "counter",
+		"Number of connections refused due to bans or connection limits.", float64(m.connsRefused))
+	writeMessageCounts(w, "procgame_messages_received_total", "Number of messages received, by type.",
+		m.received, func(c messageCount) uint64 { return c.messages })
+	writeMessageCounts(w, "procgame_received_bytes_total", "Number of bytes received, by message type.",
+		m.received, func(c messageCount) uint64 { return c.bytes })
+	writeMessageCounts(w, "procgame_messages_sent_total", "Number of messages sent, by type.",
+		m.sent, func(c messageCount) uint64 { return c.messages })
+	writeMessageCounts(w, "procgame_sent_bytes_total", "Number of bytes sent, by message type.",
+		m.sent, func(c messageCount) uint64 { return c.bytes })
+
+	fmt.Fprintln(w, "# HELP procgame_messages_dropped_total Number of messages dropped, by direction, reason and type.")
+	fmt.Fprintln(w, "# TYPE procgame_messages_dropped_total counter")
+	keys := make([]dropKey, 0, len(m.dropped))
+	for key := range m.dropped {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.direction != b.direction {
+			return a.direction < b.direction
+		}
+		if a.reason != b.reason {
+			return a.reason < b.reason
+		}
+		return a.msgType < b.msgType
+	})
+	for _, key := range keys {
+		fmt.Fprintf(w, "procgame_messages_dropped_total{direction=%q,reason=%q,type=%q} %d\n", key.direction,
+			key.reason, key.msgType, m.dropped[key])
+	}
+
+	fmt.Fprintln(w, "# HELP procgame_tick_duration_seconds Duration of each simulation step.")
+	fmt.Fprintln(w, "# TYPE procgame_tick_duration_seconds histogram")
+	var cumulative uint64
+	for i, bound := range tickDurationBuckets {
+		cumulative += m.tickBuckets[i]
+		fmt.Fprintf(w, "procgame_tick_duration_seconds_bucket{le=\"%s\"} %d\n", formatFloat(bound), cumulative)
+	}
+	fmt.Fprintf(w, "procgame_tick_duration_seconds_bucket{le=\"+Inf\"} %d\n", m.tickCount)
+	fmt.Fprintf(w, "procgame_tick_duration_seconds_sum %s\n", formatFloat(m.tickSum))
+	fmt.Fprintf(w, "procgame_tick_duration_seconds_count %d\n", m.tickCount)
+}
+
+// writeMetric writes a metric without labels.
+func writeMetric(w io.Writer, name, kind, help string, value float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %s\n", name, help, name, kind, name, formatFloat(value))
+}
+
+// writeMessageCounts writes a counter labelled by message type, whose values are selected from counts by value.
+func writeMessageCounts(w io.Writer, name, help string, counts map[protocol.Type]messageCount,
+	value func(messageCount) uint64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+	types := make([]protocol.Type, 0, len(counts))
+	for t := range counts {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool {
+		return types[i] < types[j]
+	})
+	for _, t := range types {
+		fmt.Fprintf(w, "%s{type=%q} %d\n", name, t, value(counts[t]))
+	}
+}
+
+// formatFloat formats a metric value.
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
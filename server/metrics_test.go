@@ -0,0 +1,81 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jemgunay/procedural-game/client"
+	"github.com/jemgunay/procedural-game/protocol"
+)
+
+// TestMetrics checks that the messages sent and received over connections, the connected users and the simulation
+// steps are counted, and are exported in the Prometheus text exposition format.
+func TestMetrics(t *testing.T) {
+	srv, err := New("test-seed", "", DefaultTickRate)
+	if err != nil {
+		t.Fatalf("failed to create server: %s", err)
+	}
+	transport := protocol.NewPipeTransport()
+	l, err := transport.Listen("server")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+	srv.Serve(l)
+	srv.Start()
+	defer srv.Shutdown()
+
+	c, err := client.Connect(transport, "server")
+	if err != nil {
+		t.Fatalf("failed to connect: %s", err)
+	}
+	defer c.Disconnect()
+	c.Send(protocol.Connect{Username: "alice", Password: "password", Version: protocol.Version})
+	waitFor(t, c, func(p protocol.Payload) bool {
+		_, ok := p.(protocol.RegisterSuccess)
+		return ok
+	})
+	srv.metrics.messageDropped("out", "queue_full", protocol.TypeSnapshot)
+	waitForServer(t, srv, func() bool {
+		srv.metrics.mu.Lock()
+		defer srv.metrics.mu.Unlock()
+		return srv.metrics.tickCount > 0
+	})
+
+	api := httptest.NewServer(srv.MetricsHandler())
+	defer api.Close()
+	resp, err := api.Client().Get(api.URL)
+	if err != nil {
+		t.Fatalf("failed to get metrics: %s", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read metrics: %s", err)
+	}
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain") {
+		t.Errorf("unexpected content type %s", resp.Header.Get("Content-Type"))
+	}
+
+	want := []string{
+		"procgame_connected_users 1\n",
+		"procgame_registered_users 1\n",
+		"procgame_open_connections 1\n",
+		`procgame_messages_received_total{type="connect"} 1` + "\n",
+		`procgame_messages_sent_total{type="register_success"} 1` + "\n",
+		`procgame_messages_dropped_total{direction="out",reason="queue_full",type="snapshot"} 1` + "\n",
+		`procgame_tick_duration_seconds_bucket{le="+Inf"} `,
+		"# TYPE procgame_tick_duration_seconds histogram\n",
+	}
+	for _, line := range want {
+		if !bytes.Contains(body, []byte(line)) {
+			t.Errorf("expected metrics to contain %q, got:\n%s", line, body)
+		}
+	}
+	if !bytes.Contains(body, []byte(`procgame_received_bytes_total{type="connect"} `)) ||
+		bytes.Contains(body, []byte(`procgame_received_bytes_total{type="connect"} 0`+"\n")) {
+		t.Errorf("expected the size of the connect message to be counted, got:\n%s", body)
+	}
+}
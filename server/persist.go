@@ -0,0 +1,138 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	// DefaultStatePath is the default file the server state is persisted to.
+	DefaultStatePath = "server_state.json"
+	// stateVersion is the version of the persisted state format. Version 2 added credentials and sessions, and
+	// version 3 added bans.
+	stateVersion = 3
+	// saveInterval is how often the server state is persisted while the server is running.
+	saveInterval = time.Second * 30
+)
+
+// savedState is the server state persisted to disk.
+type savedState struct {
+	Version int         `json:"version"`
+	Seed    string      `json:"seed"`
+	Users   []savedUser `json:"users"`
+	Bans    banList     `json:"bans"`
+}
+
+// savedUser is a user record persisted to disk.
+type savedUser struct {
+	Name   string  `json:"name"`
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Rot    float64 `json:"rot"`
+	Health uint64  `json:"health"`
+
+	Salt           []byte    `json:"salt,omitempty"`
+	Hash           []byte    `json:"hash,omitempty"`
+	SessionHash    []byte    `json:"sessionHash,omitempty"`
+	SessionExpires time.Time `json:"sessionExpires,omitempty"`
+}
+
+// save returns the persistable records of every user, sorted by name.
+func (d *UserDB) save() []savedUser {
+	users := make([]savedUser, 0, len(d.users))
+	for _, user := range d.users {
+		users = append(users, savedUser{
+			Name:   user.name,
+			X:      user.x,
+			Y:      user.y,
+			Rot:    user.rot,
+			Health: user.health,
+
+			Salt:           user.credential.salt,
+			Hash:           user.credential.hash,
+			SessionHash:    user.session.hash,
+			SessionExpires: user.session.expires,
+		})
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Name < users[j].Name
+	})
+	return users
+}
+
+// load inserts persisted user records into the DB. None of the users are connected, and users who were dead when
+// saved are respawned on the next update.
+func (d *UserDB) load(users []savedUser) {
+	for _, u := range users {
+		d.users[u.Name] = User{
+			name:    u.Name,
+			x:       u.X,
+			y:       u.Y,
+			rot:     u.Rot,
+			health:  u.Health,
+			armoury: newArmoury(),
+
+			credential: credential{salt: u.Salt, hash: u.Hash},
+			session:    session{hash: u.SessionHash, expires: u.SessionExpires},
+		}
+	}
+}
+
+// writeState atomically writes the server state to the file at path, so that a crash mid-write can't corrupt the
+// previously saved state.
+func writeState(path string, state savedState) error {
+	state.Version = stateVersion
+	data, err := json.MarshalIndent(state, "", "\t")
+	if err != nil {
+		return fmt.Errorf("failed to marshal state: %s", err)
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary state file: %s", err)
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write state: %s", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to sync state: %s", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to close state file: %s", err)
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace state file: %s", err)
+	}
+	return nil
+}
+
+// readState reads the server state from the file at path. false is returned if no state has been saved.
+func readState(path string) (savedState, bool, error) {
+	var state savedState
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return state, false, nil
+		}
+		return state, false, fmt.Errorf("failed to read state file: %s", err)
+	}
+
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, false, fmt.Errorf("failed to unmarshal state: %s", err)
+	}
+	// state saved by older versions can still be restored
+	if state.Version < 1 || state.Version > stateVersion {
+		return state, false, fmt.Errorf("unsupported state version %d", state.Version)
+	}
+	return state, true, nil
+}
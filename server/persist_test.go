@@ -0,0 +1,136 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jemgunay/procedural-game/sim"
+)
+
+// TestStateRoundTrip checks that the server state read from the state file matches the state written to it, and that
+// a server restores it on startup.
+func TestStateRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state.json")
+
+	cred, err := newCredential("password")
+	if err != nil {
+		t.Fatalf("failed to create credential: %s", err)
+	}
+	_, sess, err := newSession(time.Unix(1557000000, 0).UTC())
+	if err != nil {
+		t.Fatalf("failed to create session: %s", err)
+	}
+	want := savedState{
+		Seed: "test-seed",
+		Users: []savedUser{
+			{Name: "alice", X: 4021.37, Y: 120.5, Rot: -1.5707, Health: sim.MaxHealth, Salt: cred.salt,
+				Hash: cred.hash, SessionHash: sess.hash, SessionExpires: sess.expires},
+			// a user saved before passwords were required, who died before the state was saved
+			{Name: "bobby", X: 12.5, Y: 7999.99},
+		},
+		Bans: banList{Names: map[string]string{"carol": "griefing"}, IPs: map[string]string{"10.0.0.1": "spam"}},
+	}
+
+	// the state is written twice so that the existing file is replaced
+	for i := 0; i < 2; i++ {
+		if err := writeState(path, want); err != nil {
+			t.Fatalf("failed to write state: %s", err)
+		}
+	}
+	got, ok, err := readState(path)
+	if err != nil || !ok {
+		t.Fatalf("failed to read state: ok=%t, err=%v", ok, err)
+	}
+	want.Version = stateVersion
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got state %+v, want %+v", got, want)
+	}
+	// the temporary files used to replace the state atomically are cleaned up
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 1 {
+		t.Errorf("expected only the state file to remain, got %v (%v)", entries, err)
+	}
+
+	// a server started with the state file restores it, and saves it unchanged
+	srv, err := New("", path, DefaultTickRate)
+	if err != nil {
+		t.Fatalf("failed to create server: %s", err)
+	}
+	defer srv.Shutdown()
+	var restored savedState
+	srv.call(func() {
+		restored = srv.savedState()
+	})
+	restored.Version = stateVersion
+	if !reflect.DeepEqual(restored, want) {
+		t.Errorf("got restored state %+v, want %+v", restored, want)
+	}
+	srv.call(func() {
+		alice, _ := srv.userDB.Get("alice")
+		if err := alice.authenticate("password", "", time.Now()); err != nil {
+			t.Errorf("expected restored user to authenticate with their password: %s", err)
+		}
+	})
+}
+
+// TestStateMissing checks that a missing state file isn't an error, and that the server starts a new world.
+func TestStateMissing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	if _, ok, err := readState(path); ok || err != nil {
+		t.Fatalf("expected a missing state file to be reported as not saved, got ok=%t, err=%v", ok, err)
+	}
+
+	srv, err := New("test-seed", path, DefaultTickRate)
+	if err != nil {
+		t.Fatalf("failed to create server with a missing state file: %s", err)
+	}
+	defer srv.Shutdown()
+	srv.call(func() {
+		if state := srv.savedState(); state.Seed != "test-seed" || len(state.Users) != 0 {
+			t.Errorf("expected a new world, got %+v", state)
+		}
+	})
+
+	// the state file's directory must exist for it to be saved
+	if err := writeState(filepath.Join(t.TempDir(), "missing", "state.json"), savedState{}); err == nil {
+		t.Error("expected writing to a missing directory to fail")
+	}
+}
+
+// TestStateCorrupt checks that corrupt or unsupported state files are rejected rather than silently discarded, so that
+// the server refuses to start instead of overwriting them.
+func TestStateCorrupt(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"empty", ""},
+		{"garbage", "not json"},
+		{"truncated", `{"version": 3, "seed": "test-seed", "users": [{"name": "al`},
+		{"wrong type", `{"version": 3, "users": {"name": "alice"}}`},
+		{"missing version", `{"seed": "test-seed"}`},
+		{"future version", `{"version": 4, "seed": "test-seed"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "state.json")
+			if err := os.WriteFile(path, []byte(tt.data), 0o600); err != nil {
+				t.Fatalf("failed to write state file: %s", err)
+			}
+
+			if _, ok, err := readState(path); ok || err == nil {
+				t.Errorf("expected state to be rejected, got ok=%t, err=%v", ok, err)
+			}
+			if srv, err := New("test-seed", path, DefaultTickRate); err == nil {
+				srv.Shutdown()
+				t.Error("expected server to refuse to start")
+			}
+			if data, err := os.ReadFile(path); err != nil || string(data) != tt.data {
+				t.Errorf("expected state file to be left untouched, got %q (%v)", data, err)
+			}
+		})
+	}
+}
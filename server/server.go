@@ -1,286 +1,739 @@
-// Package server handles launching a TCP game server and the processing of incoming player requests.
+// Package server handles launching a game server and the processing of incoming player requests.
 package server
 
 import (
-	"bufio"
-	"encoding/json"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"math/rand"
 	"net"
-	"strings"
+	"net/http"
+	"sync"
 	"time"
 
-	"github.com/faiface/pixel"
+	"github.com/jemgunay/procedural-game/protocol"
 )
 
-var (
-	listener net.Listener
-	stopChan chan struct{}
+const (
+	// DefaultTickRate is the default number of simulation steps per second.
+	DefaultTickRate = 60
+	// MaxTickRate is the maximum number of simulation steps per second.
+	MaxTickRate = 1000
+	// maxCatchUpSteps is the maximum number of simulation steps run at once to catch up with real time after the
+	// server falls behind. Any remaining backlog is skipped rather than being simulated in a burst.
+	maxCatchUpSteps = 5
+	// commandQueueSize is the number of received messages which can be queued for the simulation goroutine before
+	// connections stop being read from.
+	commandQueueSize = 1024
+)
+
+// errShutdown is returned when the server has been shut down.
+var errShutdown = errors.New("server has shut down")
+
+// Server is a game server instance, which simulates the world and serves the players connected to it. All of the
+// server's state is owned by a single simulation goroutine, which processes the messages received from every
+// connection in turn, so none of it is locked.
+type Server struct {
+	listeners []protocol.Listener
+	// httpServers serve the admin API and metrics, and discoveryConns answer discovery queries
+	httpServers    []*http.Server
+	discoveryConns []net.PacketConn
+	// advertised describes how to connect to the server to the clients discovering it
+	advertised protocol.ServerInfo
+	// listenersMu guards the fields above, which can be added to while the server is running
+	listenersMu sync.Mutex
+	stopChan    chan struct{}
+	// shutdownOnce ensures the server is only shut down once, however many times it's told to
+	shutdownOnce sync.Once
+	// startChan is closed to start the simulation loop, and done is closed once the simulation goroutine has exited
+	startChan chan struct{}
+	done      chan struct{}
+
+	// commands are the messages received from connected users, and calls are functions to be run on the simulation
+	// goroutine on behalf of other goroutines
+	commands chan command
+	calls    chan call
 
 	userDB       UserDB
 	projectileDB ProjectileDB
 	worldSeed    string
-)
 
-// Start starts the TCP server and polls for incoming TCP connections.
-func Start(addr, seed string) error {
-	worldSeed = seed
-	stopChan = make(chan struct{}, 1)
-	userDB = UserDB{
-		users: make(map[string]User),
-		rand:  rand.New(rand.NewSource(int64(time.Now().UTC().Nanosecond()))),
+	// tickInterval is the fixed duration simulated by each step, tick is the number of the last step and simTime is
+	// the simulation time at the end of it
+	tickInterval time.Duration
+	tick         uint32
+	simTime      time.Time
+	// interestRadius is the distance from a user's player within which they are sent updates
+	interestRadius float64
+	// admins are the names of the users who can use admin chat commands, and bans are the banned usernames and IP
+	// addresses
+	admins map[string]struct{}
+	bans   banList
+	// connLimits limits the connections from each IP address
+	connLimits connLimiter
+	// metrics are updated by the simulation goroutine and every connection's goroutines, so are locked separately
+	metrics *metrics
+	// ticker drives the simulation loop once the server has started
+	ticker *time.Ticker
+	// lastPing is when connected users were last sent a heartbeat
+	lastPing time.Time
+	// handshakeTimeout is how long a connection has to establish a user before it is closed
+	handshakeTimeout time.Duration
+
+	// statePath is the file the server state is persisted to, or empty if persistence is disabled
+	statePath string
+	lastSave  time.Time
+	// saveMu serialises writes to the state file, which are made in the background
+	saveMu sync.Mutex
+}
+
+// command is a message received from a connected user's client, to be processed on the simulation goroutine.
+type command struct {
+	username string
+	// conn is the connection the message was received on, so that messages from stale connections can be ignored
+	conn     protocol.Conn
+	msg      protocol.Payload
+	received time.Time
+}
+
+// call is a function to be run on the simulation goroutine, which is closed once it has returned.
+type call struct {
+	f    func()
+	done chan struct{}
+}
+
+// New creates a server for the world generated from seed, which is simulated tickRate times per second once started.
+// If stateFile is not empty, the server state saved in it is restored and it is periodically updated. Saved state is
+// only restored if its world seed matches the provided seed, or if no seed is provided. The server doesn't accept
+// connections until it is served a listener, and must be shut down to stop its simulation goroutine.
+func New(seed, stateFile string, tickRate uint) (*Server, error) {
+	interval, err := tickInterval(tickRate)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	s := &Server{
+		stopChan:  make(chan struct{}),
+		startChan: make(chan struct{}),
+		done:      make(chan struct{}),
+		commands:  make(chan command, commandQueueSize),
+		calls:     make(chan call),
+		worldSeed: seed,
+		userDB: UserDB{
+			users: make(map[string]User),
+			rand:  rand.New(rand.NewSource(int64(now.Nanosecond()))),
+		},
+		tickInterval:     interval,
+		simTime:          now,
+		interestRadius:   DefaultInterestRadius,
+		handshakeTimeout: protocol.HandshakeTimeout,
+		bans:             newBanList(),
+		connLimits:       newConnLimiter(),
+		metrics:          newMetrics(),
+		statePath:        stateFile,
+		lastSave:         now,
+	}
+
+	// restore saved state
+	if s.statePath != "" {
+		state, ok, err := readState(s.statePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load server state from %s: %s", s.statePath, err)
+		}
+		switch {
+		case !ok:
+			slog.Info("no saved server state found", "path", s.statePath)
+		case seed != "" && seed != state.Seed:
+			slog.Info("saved server state is for another world seed, starting a new world", "path", s.statePath,
+				"saved_seed", state.Seed)
+		default:
+			s.worldSeed = state.Seed
+			s.userDB.load(state.Users)
+			s.bans.load(state.Bans)
+			slog.Info("restored server state", "path", s.statePath, "users", len(state.Users))
+		}
+	}
+
+	go s.run()
+	return s, nil
+}
+
+// tickInterval returns the duration of a simulation step at tickRate steps per second, which must be between 1 and
+// MaxTickRate.
+func tickInterval(tickRate uint) (time.Duration, error) {
+	if tickRate == 0 || tickRate > MaxTickRate {
+		return 0, fmt.Errorf("tick rate must be between 1 and %d", MaxTickRate)
 	}
+	return time.Second / time.Duration(tickRate), nil
+}
 
+// Listen listens for incoming TCP and UDP connections on the same port. TCP connections are encrypted with TLS if
+// tlsConfig is not nil, in which case UDP is disabled as it doesn't support TLS.
+func (s *Server) Listen(addr string, tlsConfig *tls.Config) error {
 	// bind TCP listener
-	var err error
-	listener, err = net.Listen("tcp", addr)
+	tcpListener, err := protocol.TCPTransport{TLSConfig: tlsConfig}.Listen(addr)
 	if err != nil {
-		return fmt.Errorf("failed to bind TCP on port %s: %s", addr, err)
+		return fmt.Errorf("failed to listen for TCP connections on %s: %s", addr, err)
+	}
+	slog.Info("listening for TCP connections", "addr", tcpListener.Addr().String(), "tls", tlsConfig != nil)
+
+	// bind UDP listener to the same port as TCP, which may have been chosen by the OS
+	var udpListener protocol.Listener
+	if tlsConfig == nil {
+		udpListener, err = protocol.UDPTransport{}.Listen(tcpListener.Addr().String())
+		if err != nil {
+			tcpListener.Close()
+			return fmt.Errorf("failed to listen for UDP connections on %s: %s", tcpListener.Addr(), err)
+		}
+		slog.Info("listening for UDP connections", "addr", udpListener.Addr().String())
+	} else {
+		slog.Info("UDP disabled as it doesn't support TLS")
 	}
 
-	fmt.Printf("TCP server listening on %s\n", listener.Addr())
+	s.listenersMu.Lock()
+	if tcpAddr, ok := tcpListener.Addr().(*net.TCPAddr); ok {
+		s.advertised.Port = tcpAddr.Port
+	}
+	s.advertised.TLS = tlsConfig != nil
+	s.advertised.UDP = udpListener != nil
+	s.listenersMu.Unlock()
 
-	// main TCP server loop
+	s.Serve(tcpListener)
+	if udpListener != nil {
+		s.Serve(udpListener)
+	}
+	return nil
+}
+
+// Serve accepts connections from the listener in the background until the server is shut down, at which point the
+// listener is closed.
+func (s *Server) Serve(l protocol.Listener) {
+	s.listenersMu.Lock()
+	select {
+	case <-s.stopChan:
+		s.listenersMu.Unlock()
+		l.Close()
+		return
+	default:
+	}
+	s.listeners = append(s.listeners, l)
+	s.listenersMu.Unlock()
+
+	// main server loop for the listener
 	go func() {
-		defer listener.Close()
+		defer l.Close()
 
 		for {
 			// listen for an incoming connection
-			conn, err := listener.Accept()
+			conn, err := l.Accept()
 			if err != nil {
 				select {
-				case <-stopChan:
+				case <-s.stopChan:
 					// shutdown server
 					return
 
 				default:
-					fmt.Printf("failed to accept connection: %s\n", err)
+					slog.Error("failed to accept connection", "addr", l.Addr().String(), "err", err)
+					if errors.Is(err, net.ErrClosed) {
+						return
+					}
+					continue
 				}
 			}
 			// handle connection
-			go handleConn(conn)
+			go s.handleConn(conn)
 		}
 	}()
+}
 
-	return nil
+// Start starts the server's simulation loop, which advances the simulation by a fixed step at the tick rate until the
+// server is shut down.
+func (s *Server) Start() {
+	close(s.startChan)
 }
 
-func Update() {
-	projectileDB.Update()
-	projectileDB.RLock()
-	userDB.Lock()
-	for _, projectile := range projectileDB.projectiles {
-		for _, user := range userDB.users {
+// run runs the simulation goroutine, which processes received messages and calls from other goroutines until the
+// server is shut down. Once started, it also advances the simulation at the tick rate.
+func (s *Server) run() {
+	defer close(s.done)
 
-			if user.name == projectile.owner {
-				continue
+	var ticks <-chan time.Time
+	start := s.startChan
+	for {
+		select {
+		case <-s.stopChan:
+			if s.ticker != nil {
+				s.ticker.Stop()
 			}
+			return
 
-			deltaX := projectile.x - user.x
-			deltaY := projectile.y - user.y
-			playerRadius := 50.0
-
-			if (deltaX*deltaX)+(deltaY*deltaY) < playerRadius*playerRadius {
-				fmt.Printf("player %s hit by %s's projectile!\n", user.name, projectile.owner)
-
-				user.x = float64(userDB.rand.Intn(8000))
-				user.y = float64(userDB.rand.Intn(8000))
-				user.vitals = ConcatVitals(
-					user.x,
-					user.y,
-					user.rot,
-					user.health,
-				)
-				userDB.Unlock()
-				userDB.Update(user)
-				userDB.Broadcast(Message{
-					"vitals_server",
-					user.name + "|" + user.vitals,
-				})
-				userDB.Lock()
+		case <-start:
+			s.ticker = time.NewTicker(s.tickInterval)
+			ticks, start = s.ticker.C, nil
+
+		case now := <-ticks:
+			// run as many steps as are due, so that the simulation keeps pace with real time
+			steps := 0
+			for !s.simTime.Add(s.tickInterval).After(now.UTC()) {
+				if steps == maxCatchUpSteps {
+					slog.Warn("simulation fell behind, skipping ahead", "behind", now.UTC().Sub(s.simTime))
+					s.simTime = now.UTC()
+					break
+				}
+				start := time.Now()
+				s.step()
+				s.metrics.observeTick(time.Since(start))
+				steps++
 			}
+
+		case cmd := <-s.commands:
+			s.handleCommand(cmd)
+
+		case c := <-s.calls:
+			c.f()
+			close(c.done)
 		}
 	}
-	userDB.Unlock()
-	projectileDB.RUnlock()
 }
 
-// Shutdown gracefully shuts down the TCP server.
-func Shutdown() {
-	fmt.Println("TCP server shutting down")
-	userDB.Broadcast(Message{
-		Type: "server_shutdown",
+// post queues a received message to be processed on the simulation goroutine. It blocks while the queue is full, so
+// that clients can't send messages faster than they can be processed. Messages posted after the server has been shut
+// down are dropped.
+func (s *Server) post(cmd command) {
+	select {
+	case s.commands <- cmd:
+	case <-s.stopChan:
+	}
+}
+
+// call runs f on the simulation goroutine, giving it exclusive access to the server's state, and waits for it to
+// return. errShutdown is returned without running f if the server has been shut down.
+func (s *Server) call(f func()) error {
+	c := call{f: f, done: make(chan struct{})}
+	select {
+	case s.calls <- c:
+	case <-s.stopChan:
+		return errShutdown
+	}
+	// a call which has been received is always run to completion
+	<-c.done
+	return nil
+}
+
+// step advances the simulation by a single tick: queued input commands are simulated, before projectile hits and
+// respawns are processed. A snapshot of the resulting state of the world around each user is then sent to them, users
+// who aren't keeping up with the messages sent to them are evicted and idle users are disconnected. All simulation is
+// driven by the simulation time rather than the wall clock, so that every step simulates exactly the tick interval.
+func (s *Server) step() {
+	s.tick++
+	s.simTime = s.simTime.Add(s.tickInterval)
+	now := s.simTime
+
+	s.userDB.ProcessInputs(s.tickInterval)
+	s.userDB.RecordHistory(now)
+
+	s.projectileDB.Update(now)
+
+	// apply projectile hits, removing the projectiles which hit a user
+	var events []protocol.Payload
+	s.projectileDB.projectiles, events = s.userDB.Hit(now, s.projectileDB.projectiles)
+
+	events = append(events, s.userDB.Respawn(now)...)
+	for _, e := range events {
+		s.userDB.BroadcastInterested(e, eventSubjects(e)...)
+	}
+
+	// send each user the changes to the world around them since the last snapshot they received
+	s.userDB.SendSnapshots(s.captureState(), s.interestRadius)
+	wall := time.Now()
+	s.evictStalled(wall)
+	s.heartbeat(wall)
+	s.connLimits.prune(wall)
+
+	// periodically persist the server state in the background
+	if s.statePath != "" && now.Sub(s.lastSave) >= saveInterval {
+		s.lastSave = now
+		state := s.savedState()
+		go func() {
+			if err := s.saveState(state); err != nil {
+				slog.Error("failed to save server state", "path", s.statePath, "err", err)
+			}
+		}()
+	}
+}
+
+// savedState returns the server state to be persisted, i.e. the world seed, user records and bans. It must be called
+// on the simulation goroutine, and the returned state can then be saved on another goroutine.
+func (s *Server) savedState() savedState {
+	return savedState{
+		Seed:  s.worldSeed,
+		Users: s.userDB.save(),
+		Bans:  s.bans.save(),
+	}
+}
+
+// saveState persists the provided state to the state file, if persistence is enabled.
+func (s *Server) saveState(state savedState) error {
+	if s.statePath == "" {
+		return nil
+	}
+
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+	return writeState(s.statePath, state)
+}
+
+// Shutdown gracefully shuts down the server, closing its listeners and the connections of any users which are still
+// connected, and stopping its simulation goroutine. It can be called more than once, e.g. by both the admin console
+// and a signal handler, and returns once the server has shut down.
+func (s *Server) Shutdown() {
+	s.shutdownOnce.Do(func() {
+		slog.Info("server shutting down")
+		var state savedState
+		s.call(func() {
+			s.userDB.Broadcast(protocol.ServerShutdown{})
+			state = s.savedState()
+		})
+		if err := s.saveState(state); err != nil {
+			slog.Error("failed to save server state", "path", s.statePath, "err", err)
+		}
+		time.Sleep(time.Millisecond * 500)
+
+		s.listenersMu.Lock()
+		close(s.stopChan)
+		for _, l := range s.listeners {
+			l.Close()
+		}
+		for _, conn := range s.discoveryConns {
+			conn.Close()
+		}
+		s.listenersMu.Unlock()
+		s.closeHTTP()
+
+		// the server's state can be accessed directly once the simulation goroutine has exited
+		<-s.done
+		for _, user := range s.userDB.users {
+			if user.conn != nil {
+				user.conn.Close()
+			}
+		}
 	})
-	time.Sleep(time.Millisecond * 500)
-	stopChan <- struct{}{}
-	listener.Close()
 }
 
-// handles the processing and maintenance of a connection between the server and a single game client.
-func handleConn(conn net.Conn) {
+// handles reading from a connection between the server and a single game client. Once a user has been established on
+// the connection, the messages received from it are posted to the simulation goroutine to be processed.
+func (s *Server) handleConn(conn protocol.Conn) {
 	defer conn.Close()
 
-	// get client address
-	addr := conn.RemoteAddr().String()
-	fmt.Println("TCP client connection established on " + addr)
+	// every message logged about the connection is tagged with its address, and its user once established
+	ip := remoteIP(conn.RemoteAddr())
+	log := slog.With("transport", transportName(conn), "addr", conn.RemoteAddr().String())
+
+	// refuse connections from banned addresses and addresses which are connecting too much
+	var refused error
+	if err := s.call(func() {
+		refused = s.admitConn(ip, time.Now().UTC())
+	}); err != nil {
+		return
+	}
+	if refused != nil {
+		log.Info("refused client connection", "reason", refused)
+		s.metrics.connRefused()
+		if err := conn.Send(protocol.ConnectFailure{Reason: refused.Error()}); err != nil {
+			log.Warn("failed to write message", "type", protocol.TypeConnectFailure, "err", err)
+		}
+		return
+	}
+	defer s.call(func() {
+		s.connLimits.release(ip)
+	})
+	if metered, ok := conn.(protocol.Metered); ok {
+		metered.SetMeter(s.metrics)
+	}
+	log.Info("client connection established")
 
-	var user User
+	var (
+		username string
+		out      *clientConn
+	)
 	defer func() {
 		// clean up on messy connection closure
-		if user.conn != nil {
-			userDB.Disconnect(user)
+		if username != "" {
+			s.post(command{username: username, conn: out, msg: protocol.Disconnect{}, received: time.Now().UTC()})
 		}
 
 		// client disconnecting
-		fmt.Println("TCP client connection disconnected on " + addr)
+		log.Info("client connection closed")
 	}()
 
-	for {
-		select {
-		case <-user.exitCh:
-			return
-		default:
-		}
+	// connections which don't establish a user in time are closed, so that they don't hold their address's connection
+	// slot forever
+	handshakeLog := log
+	handshake := time.AfterFunc(s.handshakeTimeout, func() {
+		handshakeLog.Info("closing connection which didn't complete the handshake", "timeout", s.handshakeTimeout)
+		conn.Close()
+	})
+	defer handshake.Stop()
 
-		resp, err := bufio.NewReader(conn).ReadString('\n')
+	limiter := newMessageLimiter(log, s.metrics)
+	for {
+		msg, err := conn.Receive()
 		if err != nil {
-			fmt.Printf("failed to read incoming TCP request: %s\n", err)
+			if errors.Is(err, protocol.ErrInvalidMessage) {
+				log.Warn("invalid message received", "err", err)
+				continue
+			}
+			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+				log.Debug("connection closed by client", "err", err)
+			} else {
+				log.Warn("failed to read message", "err", err)
+			}
 			return
 		}
-
-		// unmarshal raw request
-		var msg Message
-		if err := json.Unmarshal([]byte(resp), &msg); err != nil {
-			fmt.Printf("invalid request received from %s, %s:\n%s\n", addr, err, []byte(resp))
+		received := time.Now().UTC()
+		if !limiter.allow(msg.Type(), received) {
 			continue
 		}
 
 		// require a successful register/connect before allowing access to other request instruction types
-		if user.conn == nil {
-			user = establishUser(msg, conn)
+		if username == "" {
+			if username, out = s.establishUser(msg, conn, log); username != "" {
+				handshake.Stop()
+				log = log.With("user", username)
+				limiter.log = log
+			}
 			continue
 		}
+		s.post(command{username: username, conn: out, msg: msg, received: received})
+	}
+}
 
-		// execute operation on initialised user
-		switch msg.Type {
-		case "disconnect":
-			// clear user's connection reference in the user DB
-			userDB.Disconnect(user)
-			// destroy reference to local user
-			user = User{}
-
-		case "vitals_client":
-			// write pos msg right back to other clients
-			data, err := msg.Unpack()
-			if err != nil {
-				fmt.Printf("failed to split vitals request: %s\n", err)
-				break
-			}
-
-			user.x = (data.Get("pos").(pixel.Vec)).X
-			user.y = (data.Get("pos").(pixel.Vec)).Y
-			user.rot = data.GetFloat("rot")
-			user.health = data.GetUInt("health")
-			user.vitals = msg.Value
-			userDB.Update(user)
+// handleCommand processes a message received from a connected user's client.
+func (s *Server) handleCommand(cmd command) {
+	user, ok := s.userDB.Get(cmd.username)
+	// ignore messages received on connections the user has since been disconnected from
+	if !ok || user.conn == nil || user.conn != cmd.conn {
+		return
+	}
+	// any message shows that the connection is still alive
+	user.lastSeen = cmd.received
+	s.userDB.Update(user)
+
+	// execute operation on initialised user
+	switch data := cmd.msg.(type) {
+	case protocol.Disconnect:
+		// clear user's connection reference in the user DB
+		s.userDB.Disconnect(user, cmd.received)
+		user.conn.Close()
+
+	case protocol.Ping:
+		user.Send(protocol.Pong{Time: data.Time})
+		s.userDB.SyncClock(user.name, data.Sent(), cmd.received)
+
+	case protocol.Pong:
+		// only pongs answering the latest ping are timed, so that clients can't inflate the round trip time which their
+		// shots are lag compensated by
+		if data.Time == s.lastPing.UnixNano() {
+			s.userDB.RecordRTT(user.name, cmd.received.Sub(data.Sent()))
+		}
 
-			msg.Type = "vitals_server"
-			msg.Value = user.name + "|" + msg.Value
-			userDB.Broadcast(msg, user.name)
+	case protocol.Input:
+		// inputs are simulated on the next server update
+		s.userDB.QueueInput(user.name, data)
 
-		case "create_projectile":
-			data, err := msg.Unpack()
-			if err != nil {
-				fmt.Printf("create_projectile message incorrectly formatted: %s\n", err)
-			}
-			newProjectile := Projectile{
-				owner:     user.name,
-				spawnTime: data.GetTime("spawnTime"),
-				ttl:       data.GetDuration("ttl"),
-				startX:    data.GetFloat("startX"),
-				startY:    data.GetFloat("startY"),
-				velX:      data.GetFloat("velX"),
-				velY:      data.GetFloat("velY"),
-			}
+	case protocol.Projectile:
+		// dead users can't fire
+		if user.dead() {
+			break
+		}
+		weapon, err := s.userDB.ValidateShot(user.name, data, cmd.received)
+		if err != nil {
+			slog.Warn("dropping invalid projectile", "user", user.name, "err", err)
+			s.offend(user, cmd.received)
+			break
+		}
+		// the projectile is simulated in server time, which validating it may have estimated the client's clock offset
+		// from
+		user, _ = s.userDB.Get(user.name)
+		newProjectile := Projectile{
+			owner:     user.name,
+			weapon:    weapon,
+			spawnTime: user.serverTime(data.Spawned()),
+			ttl:       data.TTL,
+			startX:    data.StartX,
+			startY:    data.StartY,
+			velX:      data.VelX,
+			velY:      data.VelY,
+			rewind:    lagCompensation(user.rtt),
+		}
+		// the projectile is sent to other users in the next snapshot
+		s.projectileDB.Create(newProjectile)
 
-			projectileDB.Create(newProjectile)
-			userDB.Broadcast(msg, user.name)
+	case protocol.SnapshotAck:
+		s.userDB.AckSnapshot(user.name, data.Tick)
 
-		default:
-			fmt.Printf("unsupported request type for connected stage: %s\n", msg.Type)
+	case protocol.Reload:
+		if err := s.userDB.Reload(user.name, data, cmd.received); err != nil {
+			slog.Warn("dropping invalid reload", "user", user.name, "err", err)
+			s.offend(user, cmd.received)
 		}
+
+	case protocol.Chat:
+		s.chat(user, data, cmd.received)
+
+	default:
+		slog.Warn("unsupported message type for connected user", "user", user.name, "type", cmd.msg.Type())
 	}
 }
 
-// handles registering (signing up) and reconnecting (logging in) users on an established connection, associating the
-// connection with a user in the process
-func establishUser(msg Message, conn net.Conn) (user User) {
-	if msg.Type != "connect" {
-		fmt.Println("unsupported request type for init stage: " + msg.Type)
-		return user
+// transportName returns the name of the network a connection was made over, e.g. tcp, udp or pipe, for logging.
+func transportName(conn protocol.Conn) string {
+	return conn.RemoteAddr().Network()
+}
+
+// records an invalid message from a user and kicks them if they are a repeat offender, closing their connection.
+func (s *Server) offend(user User, now time.Time) {
+	if !s.userDB.Offend(user.name, now) {
+		return
 	}
+	slog.Info("kicking user", "user", user.name, "reason", "too many invalid messages")
+	s.kick(user, "too many invalid messages", now)
+}
 
-	var ok bool
-	var err error
+// kick tells a user why they're being kicked at time now, before disconnecting them and closing their connection.
+func (s *Server) kick(user User, reason string, now time.Time) {
+	user.Send(protocol.Kick{Reason: reason})
+	s.userDB.Disconnect(user, now)
+	user.conn.Close()
+}
 
-	user, ok = userDB.Get(msg.Value)
-	// user does not exist yet - attempt to create new user given the provided username
+// handles registering (signing up) and reconnecting (logging in) users on an established connection, associating the
+// connection with a user in the process. The name of the user established on the connection is returned along with
+// the connection's outbound queue, or an empty name if no user was established. Credentials are hashed on the
+// connection's goroutine as hashing is deliberately slow, and the user DB is accessed on the simulation goroutine.
+func (s *Server) establishUser(msg protocol.Payload, conn protocol.Conn, log *slog.Logger) (string, *clientConn) {
+	req, ok := msg.(protocol.Connect)
 	if !ok {
-		user, err = userDB.Create(msg.Value, conn)
-		if err != nil {
-			user.Send(Message{
-				Type:  "register_failure",
-				Value: "failed to create user: " + err.Error(),
-			})
-			return
-		}
+		log.Warn("unsupported message type before connecting", "type", msg.Type())
+		return "", nil
+	}
+	log = log.With("user", req.Username)
 
-		// respond with register success
-		user.Send(Message{
-			Type:  "register_success",
-			Value: worldSeed + "|" + user.name + "|" + user.vitals,
-		})
-	} else {
-		// attempt to establish connection for existing user
-		user, err = userDB.Connect(msg.Value, conn)
-		if err != nil {
-			user.Send(Message{
-				Type:  "connect_failure",
-				Value: "failed to connect existing user: " + err.Error(),
-			})
-			return
+	// agree on a protocol version before creating or connecting the user
+	version, err := protocol.Negotiate(req.Version)
+	if err != nil {
+		log.Info("refusing connection", "reason", err)
+		if err := conn.Send(protocol.ConnectFailure{Reason: err.Error()}); err != nil {
+			log.Warn("failed to write message", "type", protocol.TypeConnectFailure, "err", err)
 		}
+		return "", nil
+	}
 
-		// respond with connect success
-		user.Send(Message{
-			Type:  "connect_success",
-			Value: worldSeed + "|" + user.name + "|" + user.vitals,
-		})
+	// select the codec to switch to once the user has been welcomed
+	codecName := protocol.SelectCodec(req.Codec)
+	codec, err := protocol.NewCodec(codecName)
+	if err != nil {
+		log.Error("failed to create codec", "codec", codecName, "err", err)
+		return "", nil
 	}
 
-	// broadcast to all players that user successfully joined
-	userDB.Broadcast(Message{
-		Type:  "user_joined",
-		Value: user.name,
-	}, user.name)
+	var (
+		user      User
+		exists    bool
+		banReason string
+		banned    bool
+	)
+	if err := s.call(func() {
+		user, exists = s.userDB.Get(req.Username)
+		banReason, banned = s.bans.nameBanned(req.Username)
+	}); err != nil {
+		return "", nil
+	}
+	if banned {
+		log.Info("refusing connection", "reason", "banned: "+banReason)
+		if err := conn.Send(protocol.ConnectFailure{Reason: "banned: " + banReason}); err != nil {
+			log.Warn("failed to write message", "type", protocol.TypeConnectFailure, "err", err)
+		}
+		return "", nil
+	}
 
-	// send world update to player
-	var data strings.Builder
-	for _, u := range userDB.users {
-		if u.name == user.name {
-			continue
+	out := newClientConn(conn, log, s.metrics)
+	var response protocol.Payload
+	// user does not exist yet - attempt to create new user given the provided username and password
+	if !exists {
+		var cred credential
+		if err = validateUsername(req.Username); err == nil {
+			cred, err = newCredential(req.Password)
+		}
+		if err == nil {
+			err = s.call(func() {
+				if user, err = s.userDB.Create(req.Username, cred, out, time.Now().UTC()); err == nil {
+					response = protocol.RegisterSuccess{Welcome: s.newWelcome(user, version, codecName, false)}
+					// broadcast to all players that user successfully joined
+					s.userDB.Broadcast(protocol.UserJoined{Name: user.name}, user.name)
+				}
+			})
+		}
+		if err != nil {
+			log.Info("failed to register user", "err", err)
+			if err := conn.Send(protocol.RegisterFailure{
+				Reason: "failed to create user: " + err.Error(),
+			}); err != nil {
+				log.Warn("failed to write message", "type", protocol.TypeRegisterFailure, "err", err)
+			}
+			return "", nil
 		}
-		if data.String() != "" {
-			data.WriteString("/")
+		log.Info("user registered")
+	} else {
+		// attempt to authenticate and establish connection for existing user
+		err = user.authenticate(req.Password, req.Token, time.Now().UTC())
+		if err == nil {
+			err = s.call(func() {
+				var resumed bool
+				user, resumed, err = s.userDB.Connect(req.Username, req.Token != "", out, time.Now().UTC())
+				if err == nil {
+					response = protocol.ConnectSuccess{Welcome: s.newWelcome(user, version, codecName, resumed)}
+					s.userDB.Broadcast(protocol.UserJoined{Name: user.name}, user.name)
+				}
+			})
 		}
-		data.WriteString(u.name + "|" + u.vitals)
+		if err != nil {
+			log.Info("failed to connect user", "err", err)
+			if err := conn.Send(protocol.ConnectFailure{
+				Reason: "failed to connect existing user: " + err.Error(),
+			}); err != nil {
+				log.Warn("failed to write message", "type", protocol.TypeConnectFailure, "err", err)
+			}
+			return "", nil
+		}
+		log.Info("user connected")
 	}
-	if data.String() != "" {
-		user.Send(Message{
-			Type:  "init_world",
-			Value: data.String(),
-		})
+
+	// respond with register/connect success, switching the connection over to the codec agreed during the handshake
+	// before the writer starts writing the messages queued for the user
+	if err := conn.SendAndSwitch(response, codec); err != nil {
+		log.Warn("failed to write message", "type", response.Type(), "err", err)
 	}
+	go out.write()
+	return user.name, out
+}
 
-	return
+// newWelcome creates the handshake response for a newly connected user, issuing them a session token which can be used
+// to reconnect. resumed is set if the user resumed their previous session.
+func (s *Server) newWelcome(user User, version uint, codecName string, resumed bool) protocol.Welcome {
+	token, err := s.userDB.IssueSession(user.name, time.Now().UTC())
+	if err != nil {
+		slog.Error("failed to issue session token", "user", user.name, "err", err)
+	}
+	return protocol.Welcome{
+		Version: version,
+		Codec:   codecName,
+		Token:   token,
+		Seed:    s.worldSeed,
+		Player:  user.Vitals(),
+		Resumed: resumed,
+	}
 }
@@ -0,0 +1,447 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jemgunay/procedural-game/client"
+	"github.com/jemgunay/procedural-game/protocol"
+	"github.com/jemgunay/procedural-game/sim"
+)
+
+// testTimeout is how long tests wait for a client to receive an expected message.
+const testTimeout = time.Second * 5
+
+// discardConn is a connection which discards everything sent over it.
+type discardConn struct{}
+
+func (discardConn) Send(protocol.Payload) error                          { return nil }
+func (discardConn) SendAndSwitch(protocol.Payload, protocol.Codec) error { return nil }
+func (discardConn) Switch(protocol.Codec)                                {}
+func (discardConn) Receive() (protocol.Payload, error)                   { return nil, io.EOF }
+func (discardConn) RemoteAddr() net.Addr                                 { return &net.TCPAddr{} }
+func (discardConn) Close() error                                         { return nil }
+
+// recordConn is a connection which records everything sent over it.
+type recordConn struct {
+	discardConn
+	sent []protocol.Payload
+}
+
+func (c *recordConn) Send(p protocol.Payload) error {
+	c.sent = append(c.sent, p)
+	return nil
+}
+
+// take returns and clears the payloads sent over the connection.
+func (c *recordConn) take() []protocol.Payload {
+	sent := c.sent
+	c.sent = nil
+	return sent
+}
+
+// waitFor polls a client until it receives a message satisfying match, failing the test if none arrives in time.
+func waitFor(t *testing.T, c *client.Client, match func(protocol.Payload) bool) protocol.Payload {
+	t.Helper()
+	deadline := time.Now().Add(testTimeout)
+	for time.Now().Before(deadline) {
+		msg, err := c.Poll()
+		switch err {
+		case nil:
+			if match(msg) {
+				return msg
+			}
+		case client.ErrQueueEmpty:
+			time.Sleep(time.Millisecond)
+		default:
+			t.Fatalf("failed to poll client: %s", err)
+		}
+	}
+	t.Fatal("timed out waiting for message")
+	return nil
+}
+
+// TestInMemoryGame runs a server and many clients connected over an in-memory pipe, checking that every client joins
+// the world and sees the authoritative movement of every player.
+func TestInMemoryGame(t *testing.T) {
+	const numClients = 8
+
+	srv, err := New("test-seed", "", DefaultTickRate)
+	if err != nil {
+		t.Fatalf("failed to create server: %s", err)
+	}
+	transport := protocol.NewPipeTransport()
+	l, err := transport.Listen("server")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+	srv.Serve(l)
+	defer srv.Shutdown()
+	// players are spawned randomly, so every player is within the area of interest of every other
+	srv.SetInterestRadius(worldSize * 2)
+
+	clients := make([]*client.Client, numClients)
+	for i := range clients {
+		c, err := client.Connect(transport, "server")
+		if err != nil {
+			t.Fatalf("client %d: failed to connect: %s", i, err)
+		}
+		defer c.Disconnect()
+		clients[i] = c
+
+		name := fmt.Sprintf("player%d", i)
+		c.Send(protocol.Connect{
+			Username: name,
+			Password: "password",
+			Version:  protocol.Version,
+			Codec:    protocol.BinaryCodecName,
+		})
+		msg := waitFor(t, c, func(p protocol.Payload) bool {
+			switch p.(type) {
+			case protocol.RegisterSuccess, protocol.RegisterFailure:
+				return true
+			}
+			return false
+		})
+		success, ok := msg.(protocol.RegisterSuccess)
+		if !ok {
+			t.Fatalf("client %d: failed to register: %+v", i, msg)
+		}
+		if success.Seed != "test-seed" || success.Player.Name != name {
+			t.Fatalf("client %d: unexpected welcome: %+v", i, success.Welcome)
+		}
+	}
+
+	// every player moves right, which the server simulates on its next update
+	start := make(map[string]protocol.Vitals)
+	srv.call(func() {
+		for _, user := range srv.userDB.users {
+			start[user.name] = user.Vitals()
+		}
+	})
+	for _, c := range clients {
+		c.Send(protocol.Input{Seq: 1, MoveX: 1, Duration: srv.tickInterval})
+	}
+	waitForServer(t, srv, func() bool {
+		queued := 0
+		for _, user := range srv.userDB.users {
+			queued += len(user.inputs)
+		}
+		return queued == numClients
+	})
+	srv.call(srv.step)
+
+	// every client receives a snapshot of the step containing the acknowledged vitals of every player
+	for i, c := range clients {
+		msg := waitFor(t, c, func(p protocol.Payload) bool {
+			_, ok := p.(protocol.Snapshot)
+			return ok
+		})
+		snapshot := msg.(protocol.Snapshot)
+		if snapshot.Tick != 1 || len(snapshot.Players) != numClients {
+			t.Fatalf("client %d: unexpected snapshot: %+v", i, snapshot)
+		}
+		for _, vitals := range snapshot.Players {
+			if vitals.Seq != 1 || vitals.X <= start[vitals.Name].X || vitals.Y != start[vitals.Name].Y {
+				t.Fatalf("client %d: %s did not move right: %+v", i, vitals.Name, vitals)
+			}
+		}
+		c.Send(protocol.SnapshotAck{Tick: snapshot.Tick})
+	}
+
+	// once acknowledged, the next snapshot only contains the player who moved
+	waitForServer(t, srv, func() bool {
+		for _, user := range srv.userDB.users {
+			if user.ackedTick != 1 {
+				return false
+			}
+		}
+		return true
+	})
+	clients[0].Send(protocol.Input{Seq: 2, MoveY: 1, Duration: srv.tickInterval})
+	waitForServer(t, srv, func() bool {
+		user, _ := srv.userDB.Get("player0")
+		return len(user.inputs) == 1
+	})
+	srv.call(srv.step)
+
+	for i, c := range clients {
+		msg := waitFor(t, c, func(p protocol.Payload) bool {
+			_, ok := p.(protocol.Snapshot)
+			return ok
+		})
+		snapshot := msg.(protocol.Snapshot)
+		if snapshot.Tick != 2 || snapshot.Baseline != 1 || len(snapshot.Players) != 1 || snapshot.Players[0].Name != "player0" {
+			t.Fatalf("client %d: unexpected delta snapshot: %+v", i, snapshot)
+		}
+	}
+}
+
+// waitForServer waits for the server to reach a state satisfying done, which is checked on the simulation goroutine,
+// failing the test if it doesn't in time.
+func waitForServer(t *testing.T, srv *Server, done func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(testTimeout)
+	for {
+		var ok bool
+		if err := srv.call(func() { ok = done() }); err != nil {
+			t.Fatalf("failed to check server: %s", err)
+		}
+		if ok {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for server")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// TestFixedTimestep checks that each step simulates exactly one tick interval, regardless of when it is run.
+func TestFixedTimestep(t *testing.T) {
+	for _, tickRate := range []uint{30, 60} {
+		srv, err := New("test-seed", "", tickRate)
+		if err != nil {
+			t.Fatalf("failed to create server: %s", err)
+		}
+		srv.userDB.users["walker"] = User{name: "walker", health: sim.MaxHealth, lastSeen: time.Now(),
+			conn: discardConn{}}
+		start := srv.simTime
+
+		// the walker moves right for a second's worth of steps
+		for i := uint(1); i <= tickRate; i++ {
+			srv.userDB.QueueInput("walker", protocol.Input{Seq: uint32(i), MoveX: 1, Duration: srv.tickInterval})
+			srv.step()
+		}
+
+		elapsed := srv.tickInterval * time.Duration(tickRate)
+		if srv.tick != uint32(tickRate) || !srv.simTime.Equal(start.Add(elapsed)) {
+			t.Errorf("%d Hz: got tick %d at %s, want tick %d at %s", tickRate, srv.tick, srv.simTime.Sub(start),
+				tickRate, elapsed)
+		}
+		walker, _ := srv.userDB.Get("walker")
+		if want := sim.BaseSpeed * elapsed.Seconds(); math.Abs(walker.x-want) > 1e-6 {
+			t.Errorf("%d Hz: walker moved %f, want %f", tickRate, walker.x, want)
+		}
+		if state := srv.captureState().state; state.Tick != srv.tick || len(state.Players) != 1 {
+			t.Errorf("%d Hz: unexpected state: %+v", tickRate, state)
+		}
+	}
+
+	for _, tickRate := range []uint{0, MaxTickRate + 1, 2e9} {
+		if _, err := New("test-seed", "", tickRate); err == nil {
+			t.Errorf("expected a tick rate of %d to be rejected", tickRate)
+		}
+	}
+}
+
+// TestInterest checks that users are only sent the players and projectiles within their area of interest, and are told
+// when players enter and leave it.
+func TestInterest(t *testing.T) {
+	srv, err := New("test-seed", "", DefaultTickRate)
+	if err != nil {
+		t.Fatalf("failed to create server: %s", err)
+	}
+	srv.SetInterestRadius(1000)
+
+	conns := make(map[string]*recordConn)
+	place := func(name string, x, y float64) {
+		user, ok := srv.userDB.Get(name)
+		if !ok {
+			conns[name] = &recordConn{}
+			user = User{name: name, health: sim.MaxHealth, conn: conns[name]}
+		}
+		user.x, user.y = x, y
+		srv.userDB.Update(user)
+	}
+	// check the messages sent to a user by the last step, which end with a snapshot
+	check := func(name string, want ...protocol.Payload) protocol.Snapshot {
+		t.Helper()
+		sent := conns[name].take()
+		if len(sent) != len(want)+1 {
+			t.Fatalf("%s: got %+v, want %+v followed by a snapshot", name, sent, want)
+		}
+		for i := range want {
+			if !reflect.DeepEqual(sent[i], want[i]) {
+				t.Fatalf("%s: got %+v, want %+v", name, sent[i], want[i])
+			}
+		}
+		snapshot, ok := sent[len(want)].(protocol.Snapshot)
+		if !ok {
+			t.Fatalf("%s: got %+v, want a snapshot", name, sent[len(want)])
+		}
+		return snapshot
+	}
+	names := func(vitals []protocol.Vitals) []string {
+		var names []string
+		for _, v := range vitals {
+			names = append(names, v.Name)
+		}
+		return names
+	}
+
+	// alice and bobby are close together, while carol is far away in a different cell
+	place("alice", 0, 0)
+	place("bobby", 500, 0)
+	place("carol", 5000, -5000)
+	srv.projectileDB.Create(Projectile{owner: "carol", x: 5100, y: -5000, spawnTime: srv.simTime, ttl: time.Minute})
+	srv.projectileDB.Create(Projectile{owner: "bobby", x: 600, y: 0, spawnTime: srv.simTime, ttl: time.Minute})
+
+	srv.tick++
+	world := srv.captureState()
+	srv.userDB.SendSnapshots(world, srv.interestRadius)
+	bobby, _ := srv.userDB.Get("bobby")
+	alice := check("alice", protocol.PlayerEntered{Player: bobby.Vitals()})
+	if got := names(alice.Players); !reflect.DeepEqual(got, []string{"alice", "bobby"}) ||
+		len(alice.Projectiles) != 1 || alice.Projectiles[0].ID != 2 {
+		t.Fatalf("alice: unexpected snapshot: %+v", alice)
+	}
+	carol := check("carol")
+	if got := names(carol.Players); !reflect.DeepEqual(got, []string{"carol"}) ||
+		len(carol.Projectiles) != 1 || carol.Projectiles[0].ID != 1 {
+		t.Fatalf("carol: unexpected snapshot: %+v", carol)
+	}
+	check("bobby", protocol.PlayerEntered{Player: world.state.Players["alice"]})
+
+	// events are only sent to those interested in the players involved
+	hit := protocol.PlayerHit{Name: "alice", Attacker: "bobby", Weapon: "Deagle", Damage: 10, Health: 90}
+	srv.userDB.BroadcastInterested(hit, eventSubjects(hit)...)
+	if len(conns["alice"].take()) != 1 || len(conns["bobby"].take()) != 1 || len(conns["carol"].take()) != 0 {
+		t.Fatal("hit event was not sent to exactly alice and bobby")
+	}
+
+	// carol moves next to alice, while bobby moves away from both
+	srv.userDB.AckSnapshot("alice", alice.Tick)
+	place("carol", -500, 0)
+	place("bobby", 3000, 0)
+	srv.tick++
+	world = srv.captureState()
+	srv.userDB.SendSnapshots(world, srv.interestRadius)
+	alice = check("alice", protocol.PlayerEntered{Player: world.state.Players["carol"]}, protocol.PlayerLeft{Name: "bobby"})
+	if got := names(alice.Players); alice.Baseline != 1 || !reflect.DeepEqual(got, []string{"carol"}) ||
+		!reflect.DeepEqual(alice.RemovedPlayers, []string{"bobby"}) {
+		t.Fatalf("alice: unexpected delta snapshot: %+v", alice)
+	}
+	check("bobby", protocol.PlayerLeft{Name: "alice"})
+	check("carol", protocol.PlayerEntered{Player: world.state.Players["alice"]})
+}
+
+// TestLoad runs the server's simulation loop while many clients concurrently join, send inputs, acknowledge snapshots
+// and leave, checking that every client keeps receiving snapshots. It is intended to be run with the race detector.
+func TestLoad(t *testing.T) {
+	const (
+		numClients = 32
+		duration   = time.Second
+	)
+
+	srv, err := New("test-seed", "", DefaultTickRate)
+	if err != nil {
+		t.Fatalf("failed to create server: %s", err)
+	}
+	transport := protocol.NewPipeTransport()
+	l, err := transport.Listen("server")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+	srv.Serve(l)
+	srv.Start()
+	defer srv.Shutdown()
+
+	// users join with session tokens, as hashing a password for each of them would be slow
+	tokens := make([]string, numClients)
+	srv.call(func() {
+		for i := range tokens {
+			name := fmt.Sprintf("player%d", i)
+			srv.userDB.users[name] = User{name: name, health: sim.MaxHealth, armoury: newArmoury()}
+			if tokens[i], err = srv.userDB.IssueSession(name, time.Now().UTC()); err != nil {
+				return
+			}
+		}
+	})
+	if err != nil {
+		t.Fatalf("failed to issue session: %s", err)
+	}
+
+	errs := make(chan error, numClients)
+	for i := range tokens {
+		go func(i int) {
+			errs <- runLoadClient(transport, fmt.Sprintf("player%d", i), tokens[i], duration)
+		}(i)
+	}
+	for i := 0; i < numClients; i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+// runLoadClient joins the server as a user and sends inputs every millisecond for the provided duration, returning an
+// error if it doesn't keep receiving snapshots.
+func runLoadClient(transport protocol.Transport, name, token string, duration time.Duration) error {
+	c, err := client.Connect(transport, "server")
+	if err != nil {
+		return fmt.Errorf("%s: failed to connect: %s", name, err)
+	}
+	defer c.Disconnect()
+	c.Send(protocol.Connect{Username: name, Token: token, Version: protocol.Version, Codec: protocol.BinaryCodecName})
+
+	joinDeadline := time.Now().Add(testTimeout)
+	for joined := false; !joined; {
+		msg, err := c.Poll()
+		switch {
+		case err == client.ErrQueueEmpty:
+			if time.Now().After(joinDeadline) {
+				return fmt.Errorf("%s: timed out joining", name)
+			}
+			time.Sleep(time.Millisecond)
+		case err != nil:
+			return fmt.Errorf("%s: failed to poll client: %s", name, err)
+		default:
+			if _, joined = msg.(protocol.ConnectSuccess); !joined {
+				return fmt.Errorf("%s: failed to connect: %+v", name, msg)
+			}
+		}
+	}
+
+	var (
+		seq       uint32
+		snapshots int
+		lastTick  uint32
+	)
+	deadline := time.Now().Add(duration)
+	for time.Now().Before(deadline) {
+		seq++
+		c.Send(protocol.Input{Seq: seq, MoveX: int8(seq%3) - 1, MoveY: 1, Duration: time.Millisecond})
+
+		for {
+			msg, err := c.Poll()
+			if err == client.ErrQueueEmpty {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("%s: failed to poll client: %s", name, err)
+			}
+			if snapshot, ok := msg.(protocol.Snapshot); ok {
+				if snapshot.Tick <= lastTick {
+					return fmt.Errorf("%s: snapshot %d received after %d", name, snapshot.Tick, lastTick)
+				}
+				lastTick = snapshot.Tick
+				snapshots++
+				c.Send(protocol.SnapshotAck{Tick: snapshot.Tick})
+			}
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	c.Send(protocol.Disconnect{})
+	// at 60 Hz, a second of play produces far more snapshots than this
+	if snapshots < 10 {
+		return fmt.Errorf("%s: only received %d snapshots", name, snapshots)
+	}
+	return nil
+}
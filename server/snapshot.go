@@ -0,0 +1,81 @@
+package server
+
+import (
+	"github.com/jemgunay/procedural-game/protocol"
+)
+
+// captureState captures the state of the world at the end of the current step, i.e. the vitals of every connected
+// user and every live projectile.
+func (s *Server) captureState() *world {
+	w := newWorld(s.tick)
+
+	for _, user := range s.userDB.users {
+		if user.conn != nil {
+			w.addPlayer(user.Vitals())
+		}
+	}
+	for i := range s.projectileDB.projectiles {
+		w.addProjectile(&s.projectileDB.projectiles[i])
+	}
+
+	return w
+}
+
+// SendSnapshots sends every connected user a snapshot of the world within radius of their player, delta-encoded
+// against the latest snapshot they have acknowledged. Users who haven't acknowledged a snapshot which is still retained
+// in their history, such as those who have just joined or who have lost too many snapshots, are sent a full snapshot.
+// Users are told about players entering and leaving their area of interest before the snapshot is sent.
+func (d *UserDB) SendSnapshots(w *world, radius float64) {
+	for name, user := range d.users {
+		if user.conn == nil {
+			continue
+		}
+		self, ok := w.state.Players[name]
+		if !ok {
+			continue
+		}
+		state := w.interest(self.X, self.Y, radius)
+
+		// notify the user of players entering and leaving their area of interest
+		interest := make(map[string]struct{}, len(state.Players))
+		for other, vitals := range state.Players {
+			if other == name {
+				continue
+			}
+			interest[other] = struct{}{}
+			if _, ok := user.interest[other]; !ok {
+				user.Send(protocol.PlayerEntered{Player: vitals})
+			}
+		}
+		for other := range user.interest {
+			if _, ok := interest[other]; !ok {
+				user.Send(protocol.PlayerLeft{Name: other})
+			}
+		}
+		user.interest = interest
+
+		// each user is sent a different view of the world, so deltas are encoded against the states sent to them
+		if user.sent == nil {
+			user.sent = &protocol.StateHistory{}
+		}
+		baseline, ok := user.sent.Get(user.ackedTick)
+		if !ok {
+			baseline = nil
+		}
+		user.sent.Add(state)
+		user.Send(state.Delta(baseline))
+		d.users[name] = user
+	}
+}
+
+// AckSnapshot records that a user's client has received a snapshot, allowing subsequent snapshots to be delta-encoded
+// against it. Acknowledgements arrive in order as stale ones are dropped by unordered transports, so the latest
+// acknowledgement always replaces the previous one.
+func (d *UserDB) AckSnapshot(username string, tick uint32) {
+	user, ok := d.users[username]
+	if !ok {
+		return
+	}
+	user.ackedTick = tick
+	d.users[username] = user
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"os"
+	"time"
+
+	"github.com/jemgunay/procedural-game/protocol"
+)
+
+const (
+	// DefaultCertFile is the default file containing the server's PEM encoded TLS certificate.
+	DefaultCertFile = "server.crt"
+	// DefaultKeyFile is the default file containing the server's PEM encoded TLS private key.
+	DefaultKeyFile = "server.key"
+	// selfSignedValidity is how long generated self-signed certificates are valid for.
+	selfSignedValidity = time.Hour * 24 * 365 * 10
+)
+
+// TLSConfig creates a server TLS configuration from a PEM encoded certificate and private key pair. If neither file
+// exists, a self-signed certificate is generated and saved to them for LAN play. As clients pin the certificate on
+// first use, the saved certificate keeps the server's identity stable across restarts.
+func TLSConfig(certFile, keyFile string) (*tls.Config, error) {
+	_, certErr := os.Stat(certFile)
+	_, keyErr := os.Stat(keyFile)
+	if errors.Is(certErr, os.ErrNotExist) && errors.Is(keyErr, os.ErrNotExist) {
+		if err := generateCertificate(certFile, keyFile); err != nil {
+			return nil, fmt.Errorf("failed to generate self-signed certificate: %s", err)
+		}
+		slog.Info("generated self-signed TLS certificate", "path", certFile)
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %s", err)
+	}
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return nil, fmt.Errorf("failed to parse TLS certificate: %s", err)
+	}
+	slog.Info("loaded TLS certificate", "fingerprint", protocol.Fingerprint(cert.Leaf))
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// generateCertificate generates a self-signed certificate and private key, writing them PEM encoded to certFile and
+// keyFile.
+func generateCertificate(certFile, keyFile string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to generate key: %s", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return fmt.Errorf("failed to generate serial number: %s", err)
+	}
+
+	now := time.Now().UTC()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "procedural-game"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return fmt.Errorf("failed to create certificate: %s", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("failed to marshal key: %s", err)
+	}
+
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return fmt.Errorf("failed to write key: %s", err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return fmt.Errorf("failed to write certificate: %s", err)
+	}
+	return nil
+}
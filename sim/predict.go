@@ -0,0 +1,84 @@
+package sim
+
+import (
+	"sync"
+
+	"github.com/jemgunay/procedural-game/protocol"
+)
+
+// inputBufferSize is the number of unacknowledged input commands retained for replay. At 120 inputs per second this
+// covers round-trips of roughly two seconds.
+const inputBufferSize = 256
+
+// Predictor applies a player's input commands locally ahead of the server so that movement is responsive. When
+// authoritative state arrives from the server, the prediction is rewound to that state and the input commands the
+// server has yet to process are replayed on top of it. It is safe for concurrent use.
+type Predictor struct {
+	mu sync.Mutex
+	x  float64
+	y  float64
+
+	// pending is a ring buffer of input commands which have not been acknowledged by the server, in sequence order
+	pending [inputBufferSize]protocol.Input
+	head    int
+	count   int
+}
+
+// NewPredictor creates a new Predictor starting at the provided position.
+func NewPredictor(x, y float64) *Predictor {
+	return &Predictor{x: x, y: y}
+}
+
+// Apply records an input command which has been sent to the server and applies it to the predicted position, which
+// is returned.
+func (p *Predictor) Apply(in protocol.Input) (float64, float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// if the buffer is full, the oldest input is overwritten and the server state will correct any error
+	if p.count == inputBufferSize {
+		p.head = (p.head + 1) % inputBufferSize
+		p.count--
+	}
+	p.pending[(p.head+p.count)%inputBufferSize] = in
+	p.count++
+
+	p.x, p.y = Move(p.x, p.y, in)
+	return p.x, p.y
+}
+
+// Reconcile rewinds the prediction to the authoritative state received from the server, discards the input commands
+// the server has acknowledged and replays the remaining ones. The corrected predicted position is returned.
+func (p *Predictor) Reconcile(state protocol.Vitals) (float64, float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// discard acknowledged inputs
+	for p.count > 0 && p.pending[p.head].Seq <= state.Seq {
+		p.head = (p.head + 1) % inputBufferSize
+		p.count--
+	}
+
+	// rewind and replay
+	p.x, p.y = state.X, state.Y
+	for i := 0; i < p.count; i++ {
+		p.x, p.y = Move(p.x, p.y, p.pending[(p.head+i)%inputBufferSize])
+	}
+	return p.x, p.y
+}
+
+// Reset discards all unacknowledged input commands and moves the prediction to the provided position, e.g. after the
+// server has respawned the player.
+func (p *Predictor) Reset(x, y float64) {
+	p.mu.Lock()
+	p.x, p.y = x, y
+	p.head, p.count = 0, 0
+	p.mu.Unlock()
+}
+
+// Pending returns the number of input commands which have not been acknowledged by the server.
+func (p *Predictor) Pending() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.count
+}
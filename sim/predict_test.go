@@ -0,0 +1,142 @@
+package sim
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jemgunay/procedural-game/protocol"
+)
+
+const (
+	// frameRate is the simulated client frame rate and tickRate the simulated server update rate.
+	frameRate = 120
+	tickRate  = 60
+)
+
+// delayed delivers values a fixed number of frames after they were sent.
+type delayed struct {
+	delay  int
+	queue  []interface{}
+	frames []int
+}
+
+func (d *delayed) send(frame int, v interface{}) {
+	d.queue = append(d.queue, v)
+	d.frames = append(d.frames, frame+d.delay)
+}
+
+func (d *delayed) receive(frame int) []interface{} {
+	var out []interface{}
+	for len(d.queue) > 0 && d.frames[0] <= frame {
+		out = append(out, d.queue[0])
+		d.queue, d.frames = d.queue[1:], d.frames[1:]
+	}
+	return out
+}
+
+// input produces the input command for a frame, changing direction every half second.
+func input(frame int, seq uint32) protocol.Input {
+	dirs := [][2]int8{{1, 0}, {1, 1}, {0, -1}, {-1, 0}, {0, 0}, {-1, 1}}
+	dir := dirs[(frame/(frameRate/2))%len(dirs)]
+	return protocol.Input{
+		Seq:      seq,
+		MoveX:    dir[0],
+		MoveY:    dir[1],
+		Duration: InputDuration(1.0 / frameRate),
+	}
+}
+
+func TestPredictorConvergesUnderLatency(t *testing.T) {
+	for _, latency := range []time.Duration{0, 50 * time.Millisecond, 100 * time.Millisecond, 200 * time.Millisecond} {
+		t.Run(fmt.Sprint(latency), func(t *testing.T) {
+			oneWay := int(latency.Seconds() * frameRate / 2)
+			toServer, toClient := &delayed{delay: oneWay}, &delayed{delay: oneWay}
+
+			const startX, startY = 4000.0, 2000.0
+			predictor := NewPredictor(startX, startY)
+			serverX, serverY := startX, startY
+			// idealX/Y is the position reached by applying every input immediately, i.e. with no latency at all
+			idealX, idealY := startX, startY
+
+			var (
+				seq            uint32
+				serverInputs   []protocol.Input
+				lastServerSeq  uint32
+				reconciliation int
+			)
+			const inputFrames = frameRate * 3
+			for frame := 0; frame < inputFrames+4*oneWay+frameRate/tickRate+1; frame++ {
+				// client samples and predicts input for the first few seconds
+				if frame < inputFrames {
+					seq++
+					in := input(frame, seq)
+					toServer.send(frame, in)
+					idealX, idealY = Move(idealX, idealY, in)
+
+					x, y := predictor.Apply(in)
+					if x != idealX || y != idealY {
+						t.Fatalf("frame %d: predicted position (%f, %f) diverged from (%f, %f)", frame, x, y, idealX, idealY)
+					}
+				}
+
+				// server receives inputs and simulates them on each tick
+				for _, v := range toServer.receive(frame) {
+					serverInputs = append(serverInputs, v.(protocol.Input))
+				}
+				if frame%(frameRate/tickRate) == 0 && len(serverInputs) > 0 {
+					for _, in := range serverInputs {
+						serverX, serverY = Move(serverX, serverY, in)
+						lastServerSeq = in.Seq
+					}
+					serverInputs = serverInputs[:0]
+					toClient.send(frame, protocol.Vitals{X: serverX, Y: serverY, Seq: lastServerSeq})
+				}
+
+				// client reconciles authoritative state; the corrected prediction must not jump
+				for _, v := range toClient.receive(frame) {
+					reconciliation++
+					x, y := predictor.Reconcile(v.(protocol.Vitals))
+					if x != idealX || y != idealY {
+						t.Fatalf("frame %d: reconciled position (%f, %f) diverged from (%f, %f)", frame, x, y, idealX, idealY)
+					}
+				}
+			}
+
+			if reconciliation == 0 {
+				t.Fatal("no server state was reconciled")
+			}
+			if n := predictor.Pending(); n != 0 {
+				t.Fatalf("expected all inputs to be acknowledged, %d pending", n)
+			}
+			x, y := predictor.Reconcile(protocol.Vitals{X: serverX, Y: serverY, Seq: lastServerSeq})
+			if x != serverX || y != serverY {
+				t.Fatalf("prediction (%f, %f) did not converge with server (%f, %f)", x, y, serverX, serverY)
+			}
+		})
+	}
+}
+
+func TestPredictorCorrectsMisprediction(t *testing.T) {
+	predictor := NewPredictor(0, 0)
+	step := InputDuration(1.0 / frameRate)
+	for seq := uint32(1); seq <= 10; seq++ {
+		predictor.Apply(protocol.Input{Seq: seq, MoveX: 1, Duration: step})
+	}
+
+	// the server only moved the player half as far for the first 5 inputs, e.g. due to its movement budget
+	serverX, _ := Move(0, 0, protocol.Input{MoveX: 1, Duration: step * 5 / 2})
+	x, y := predictor.Reconcile(protocol.Vitals{X: serverX, Seq: 5})
+
+	// the remaining 5 inputs are replayed on top of the corrected state
+	wantX := serverX
+	for i := 0; i < 5; i++ {
+		wantX, _ = Move(wantX, 0, protocol.Input{MoveX: 1, Duration: step})
+	}
+	if x != wantX || y != 0 {
+		t.Fatalf("expected corrected position (%f, 0), got (%f, %f)", wantX, x, y)
+	}
+	if n := predictor.Pending(); n != 5 {
+		t.Fatalf("expected 5 pending inputs, got %d", n)
+	}
+}
@@ -0,0 +1,66 @@
+// Package sim contains the game simulation rules shared by the client and the server, so that client-side prediction
+// produces the same results as the authoritative server simulation.
+package sim
+
+import (
+	"math"
+	"time"
+
+	"github.com/jemgunay/procedural-game/protocol"
+)
+
+const (
+	// BaseSpeed is the number of pixels a player moves per second along each axis.
+	BaseSpeed = 300.0
+	// MaxInputDuration is the longest period of time a single input command can apply movement for.
+	MaxInputDuration = time.Millisecond * 100
+	// InterpolationDelay is the delay behind the present at which clients render remote players by default. The server
+	// accounts for it when rewinding players to the state a shooter saw.
+	InterpolationDelay = time.Millisecond * 100
+	// TileSize is the width and height of a world tile in pixels - just greater than 200 to overlap, preventing a
+	// stitching glitch when rendered.
+	TileSize = 201
+)
+
+// InputDuration converts a frame's elapsed seconds into an input command duration. It is truncated to the precision
+// supported on the wire so that the client and server simulate the exact same duration.
+func InputDuration(dt float64) time.Duration {
+	d := time.Duration(dt * float64(time.Second)).Truncate(time.Microsecond)
+	if d > MaxInputDuration {
+		return MaxInputDuration
+	}
+	return d
+}
+
+// Move applies a movement input command to a position and returns the resulting position.
+func Move(x, y float64, in protocol.Input) (float64, float64) {
+	dt := in.Duration
+	if dt < 0 {
+		dt = 0
+	}
+	if dt > MaxInputDuration {
+		dt = MaxInputDuration
+	}
+	dist := BaseSpeed * dt.Seconds()
+	return x + axis(in.MoveX)*dist, y + axis(in.MoveY)*dist
+}
+
+// Aim returns the orientation requested by an input command, or the current orientation if the requested one is not
+// a valid angle.
+func Aim(current float64, in protocol.Input) float64 {
+	if math.IsNaN(in.Aim) || math.IsInf(in.Aim, 0) {
+		return current
+	}
+	return in.Aim
+}
+
+// axis clamps a movement axis to a unit direction.
+func axis(v int8) float64 {
+	switch {
+	case v > 0:
+		return 1
+	case v < 0:
+		return -1
+	}
+	return 0
+}
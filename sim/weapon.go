@@ -0,0 +1,84 @@
+package sim
+
+import "time"
+
+const (
+	// MaxHealth is the health a player spawns with.
+	MaxHealth uint64 = 100
+	// ProjectileSpeed is the distance a projectile travels per 100ms.
+	ProjectileSpeed = 100.0
+	// ProjectileTTL is how long a projectile exists for before it is destroyed.
+	ProjectileTTL = time.Second * 5
+)
+
+// Ammo represents an ammunition type.
+type Ammo string
+
+// Ammunition type constants.
+const (
+	PistolAmmo  Ammo = "pistol"
+	RifleAmmo   Ammo = "rifle"
+	ShotgunAmmo Ammo = "shotgun"
+)
+
+// WeaponName represents a weapon name.
+type WeaponName string
+
+// Weapon type constants.
+const (
+	Deagle WeaponName = "Deagle"
+	M4A1   WeaponName = "M4A1"
+)
+
+// Weapon describes the characteristics of a projectile weapon.
+type Weapon struct {
+	Name            WeaponName
+	Ammo            Ammo
+	Automatic       bool
+	MaxAmmoCapacity uint
+	FireDelay       time.Duration
+	ReloadDelay     time.Duration
+
+	BarrelLength   float64
+	MaxSpreadAngle float64
+	// Damage is the health removed from a player hit by one of the weapon's projectiles.
+	Damage uint64
+}
+
+// Weapons is the list of supported weapons.
+var Weapons = map[WeaponName]Weapon{
+	Deagle: {
+		Name:            Deagle,
+		Ammo:            PistolAmmo,
+		Automatic:       false,
+		MaxAmmoCapacity: 7,
+		BarrelLength:    40,
+		MaxSpreadAngle:  3.2,
+		Damage:          50,
+
+		FireDelay:   time.Millisecond * 500,
+		ReloadDelay: time.Second * 3,
+	},
+	M4A1: {
+		Name:            M4A1,
+		Ammo:            RifleAmmo,
+		Automatic:       true,
+		MaxAmmoCapacity: 30,
+		BarrelLength:    70,
+		MaxSpreadAngle:  4,
+		Damage:          20,
+
+		FireDelay:   time.Millisecond * 150,
+		ReloadDelay: time.Second * 3,
+	},
+}
+
+// StartingWeapons are the weapons a player spawns with, in inventory order.
+var StartingWeapons = []WeaponName{Deagle, M4A1}
+
+// StartingAmmo is the ammo a player spawns with, excluding the rounds loaded in their weapons.
+var StartingAmmo = map[Ammo]int{
+	PistolAmmo:  14,
+	RifleAmmo:   60,
+	ShotgunAmmo: 20,
+}